@@ -0,0 +1,209 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// deleteAccountJob is the name of the background job that deletes an
+// account once it is due for deletion.
+const deleteAccountJob = "delete_account"
+
+// deleteAccount deletes the account with the "user_id" in args, if it is
+// still due for deletion. Accounts that were reactivated, or that are already
+// gone, are left alone.
+func deleteAccount(args worker.Args) error {
+	id, err := uuid.FromString(fmt.Sprint(args["user_id"]))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var keys []string
+	err = models.DB.Transaction(func(tx *pop.Connection) error {
+		u, err := models.GetUserByID(tx, id)
+		if err != nil || !u.DueForDeletion() {
+			return nil
+		}
+
+		keys, err = models.DeleteUser(tx, id)
+		return err
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return removeFiles(keys)
+}
+
+// removeFiles deletes stored files, carrying on past failures so that one
+// missing file does not leave the rest behind.
+func removeFiles(keys []string) error {
+	var failed error
+	for _, key := range keys {
+		if err := storage.Delete(key); err != nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+// scheduleAccountDeletion queues the deletion of u's account once its grace
+// period is up, or straight away if they have confirmed its deletion.
+func scheduleAccountDeletion(c buffalo.Context, u *models.User) {
+	job := worker.Job{
+		Queue:   "default",
+		Handler: deleteAccountJob,
+		Args:    worker.Args{"user_id": u.ID.String()},
+	}
+
+	var err error
+	if u.DeletionRequested() {
+		err = app.Worker.Perform(job)
+	} else {
+		err = app.Worker.PerformIn(job, models.DeactivationGrace())
+	}
+
+	if err != nil {
+		c.Logger().Errorf("could not schedule deletion of %s: %v", u.Username, err)
+	}
+}
+
+// checkPassword binds a body of the form {"password": "..."} and checks the
+// password against u's.
+func checkPassword(c buffalo.Context, u *models.User) error {
+	type argument struct {
+		Password string `json:"password"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(arg.Password)) != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("password is incorrect"))
+	}
+
+	return nil
+}
+
+// UserDeactivate hides the current user's profile and media. The account
+// can be reactivated within the grace period, after which it is deleted.
+func UserDeactivate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to deactivate account"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if err := checkPassword(c, u); err != nil {
+		return err
+	}
+
+	if u.Deactivated() {
+		return c.Render(http.StatusOK, r.JSON(u))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := u.Deactivate(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	scheduleAccountDeletion(c, u)
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// UserReactivate restores the current user's deactivated account.
+func UserReactivate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to reactivate account"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if !u.Deactivated() {
+		return c.Render(http.StatusOK, r.JSON(u))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := u.Reactivate(tx); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// UserRequestDeletion emails the current user a link to confirm that their
+// account should be deleted.
+func UserRequestDeletion(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to delete account"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if err := checkPassword(c, u); err != nil {
+		return err
+	}
+
+	ts, err := u.CreateAccountDeletionToken()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := mailers.SendAccountDeletion(u, ts); err != nil {
+		return c.Error(http.StatusInternalServerError, fmt.Errorf("could not send confirmation email %v", err))
+	}
+
+	return c.Render(http.StatusAccepted, r.JSON(""))
+}
+
+// UserConfirmDeletion deletes the account named by a token from the deletion
+// confirmation email. The account is hidden at once and deleted in the
+// background.
+func UserConfirmDeletion(c buffalo.Context) error {
+	type argument struct {
+		Token string `json:"token"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	// The confirmation is written outside the request's transaction so that
+	// the deletion job, which runs straight away, can see it.
+	u, err := models.ConfirmAccountDeletion(models.DB, arg.Token)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid deletion token"))
+	}
+
+	scheduleAccountDeletion(c, u)
+
+	return c.Render(http.StatusAccepted, r.JSON(""))
+}
@@ -0,0 +1,231 @@
+package actions
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/markbates/pop/nulls"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+func (as *ActionSuite) passwordRequest(path string, u *models.User, password string) int {
+	arg := struct {
+		Password string `json:"password"`
+	}{
+		Password: password,
+	}
+
+	req := as.JSON(path)
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	return req.Post(arg).Code
+}
+
+// Test_Account_Deactivate hides a deactivated account's page and media until
+// it is reactivated.
+func (as *ActionSuite) Test_Account_Deactivate() {
+	u := as.createOreo()
+
+	m := &models.Medium{
+		URI:        "something1",
+		User:       u.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(m))
+
+	as.Equal(http.StatusOK, as.passwordRequest("/api/1/user/deactivate", u, "goodpassword"))
+
+	res := as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.JSON("/api/1/media?id=" + m.ID.String()).Get()
+	as.NotContains(res.Body.String(), "something1")
+
+	// the session only works to reactivate the account
+	res = as.authJSON(u, "/api/1/user").Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	req := as.JSON("/api/1/user/reactivate")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res = req.Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "something1")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
+
+// Test_Account_Deactivate_Bad_Password refuses to deactivate without the
+// user's password.
+func (as *ActionSuite) Test_Account_Deactivate_Bad_Password() {
+	u := as.createOreo()
+
+	as.Equal(http.StatusUnprocessableEntity, as.passwordRequest("/api/1/user/deactivate", u, "badpassword"))
+
+	u, err := models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.False(u.Deactivated())
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Account_Grace_Expired refuses to log in to an account deactivated for
+// longer than the grace period.
+func (as *ActionSuite) Test_Account_Grace_Expired() {
+	u := as.createOreo()
+	u.DeactivatedAt = nulls.NewTime(time.Now().Add(-models.DeactivationGrace() - time.Hour))
+	as.NoError(as.DB.Update(u))
+
+	req := as.JSON("/api/1/user/reactivate")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Post(nil)
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	due, err := models.GetUsersDueForDeletion(as.DB)
+	as.NoError(err)
+	as.Len(due, 1)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Account_Delete removes a confirmed account, its follows in both
+// directions, and its media and files in the background. Files it only
+// points at, and reports about it, are kept.
+func (as *ActionSuite) Test_Account_Delete() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+		as.NoError(err)
+
+		raja := models.User{
+			FirstName:    "Raja",
+			LastName:     "Hawk",
+			Email:        "clutz@example.com",
+			Username:     "raja",
+			PasswordHash: string(ph),
+		}
+		as.NoError(as.DB.Create(&raja))
+		as.NoError(as.DB.Create(&models.Follow{Follower: raja.ID, Followed: u.ID}))
+		as.NoError(as.DB.Create(&models.Follow{Follower: u.ID, Followed: raja.ID}))
+
+		as.NoError(storage.Put("media/sketch.png", bytes.NewReader(testPNG(10, 10))))
+		as.NoError(as.DB.Create(&models.Medium{
+			URI:        storage.URL("media/sketch.png"),
+			StorageKey: "media/sketch.png",
+			User:       u.ID,
+			Filetype:   "image/png",
+			Permission: "public",
+		}))
+
+		// a medium pointing at someone else's file does not take it along
+		as.NoError(storage.Put("media/other.png", bytes.NewReader(testPNG(10, 10))))
+		as.NoError(as.DB.Create(&models.Medium{
+			URI:        storage.URL("media/other.png"),
+			User:       u.ID,
+			Filetype:   "image/png",
+			Permission: "public",
+		}))
+
+		as.NoError(as.DB.Create(models.NewReport(&raja, u, nil, models.ReportSpam, "")))
+
+		ts, err := u.CreateAccountDeletionToken()
+		as.NoError(err)
+
+		arg := struct {
+			Token string `json:"token"`
+		}{
+			Token: ts,
+		}
+
+		res := as.JSON("/api/1/user/delete/confirm").Post(arg)
+		as.Equal(http.StatusAccepted, res.Code)
+
+		for i := 0; i < 50; i++ {
+			if _, err := models.GetUserByID(as.DB, u.ID); err != nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		_, err = models.GetUserByID(as.DB, u.ID)
+		as.Error(err)
+
+		follows, err := as.DB.Count(&models.Follow{})
+		as.NoError(err)
+		as.Equal(0, follows)
+
+		media, err := as.DB.Count(&models.Medium{})
+		as.NoError(err)
+		as.Equal(0, media)
+
+		_, err = os.Stat(filepath.Join(dir, "media", "sketch.png"))
+		as.True(os.IsNotExist(err))
+
+		_, err = os.Stat(filepath.Join(dir, "media", "other.png"))
+		as.NoError(err)
+
+		reports := models.Reports{}
+		as.NoError(as.DB.Where("user_id = ?", u.ID).All(&reports))
+		as.Len(reports, 1)
+		as.Equal(models.ReportDismissed, reports[0].Status)
+
+		_, err = models.GetUserByID(as.DB, raja.ID)
+		as.NoError(err)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM reports")
+}
+
+// Test_Account_Delete_Bad_Token refuses to delete without a valid deletion
+// token.
+func (as *ActionSuite) Test_Account_Delete_Bad_Token() {
+	u := as.createOreo()
+
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+
+	arg := struct {
+		Token string `json:"token"`
+	}{
+		Token: ts,
+	}
+
+	res := as.JSON("/api/1/user/delete/confirm").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	_, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Account_Delete_Job_Skips_Active leaves alone an account that was
+// reactivated before its deletion job ran.
+func (as *ActionSuite) Test_Account_Delete_Job_Skips_Active() {
+	u := as.createOreo()
+
+	as.NoError(deleteAccount(worker.Args{"user_id": u.ID.String()}))
+
+	_, err := models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
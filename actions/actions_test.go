@@ -17,3 +17,10 @@ func Test_ActionSuite(t *testing.T) {
 	as := &ActionSuite{suite.NewAction(App())}
 	suite.Run(t, as)
 }
+
+// SetupTest gives every test a clean slate of failed logins, as requests in
+// tests all come from the same address.
+func (as *ActionSuite) SetupTest() {
+	as.Action.SetupTest()
+	throttle = newLoginThrottle()
+}
@@ -0,0 +1,408 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+)
+
+// maxPerPage is the largest page size a listing will return.
+const maxPerPage = 100
+
+// pageParams reads the page and per_page query parameters, defaulting to the
+// first page of 20.
+func pageParams(c buffalo.Context) (int, int) {
+	page, err := strconv.Atoi(c.Param("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(c.Param("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 20
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	return page, perPage
+}
+
+// adminTarget looks up the user named in the route, including deactivated
+// and suspended accounts.
+func adminTarget(c buffalo.Context, tx *pop.Connection) (*models.User, error) {
+	u, err := models.GetUserByUsername(tx, c.Param("username"))
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", c.Param("username")))
+	}
+
+	return u, nil
+}
+
+// adminReason reads the reason an administrator gives for an action. The
+// body may be left out.
+func adminReason(c buffalo.Context) (string, error) {
+	if c.Request().ContentLength == 0 {
+		return "", nil
+	}
+
+	type argument struct {
+		Reason string `json:"reason"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return "", c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	return arg.Reason, nil
+}
+
+// AdminUserSearch lists the users whose username, email or display name
+// contains the q parameter.
+func AdminUserSearch(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	page, perPage := pageParams(c)
+
+	users, err := models.SearchUsers(tx, c.Param("q"), page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(users))
+}
+
+// AdminUserRead shows a user's account along with their roles and audit log.
+func AdminUserRead(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	roles, err := u.RoleNames(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	audit, err := models.GetAuditEntries(tx, u, 1, maxPerPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		User  *models.User        `json:"user"`
+		Roles []string            `json:"roles"`
+		Audit models.AuditEntries `json:"audit"`
+	}{
+		User:  u,
+		Roles: roles,
+		Audit: audit,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// AdminUserSuspend suspends a user, who can no longer log in or use the API,
+// for the given reason. The suspension lasts until the user is unsuspended,
+// or until the time given by until. Administrators cannot suspend themselves
+// or the last active account able to manage roles.
+func AdminUserSuspend(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	type argument struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+
+	arg := &argument{}
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(arg); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+		}
+	}
+
+	admin := c.Value("user").(*models.User)
+	if u.ID == admin.ID {
+		return c.Error(http.StatusForbidden, fmt.Errorf("administrators cannot suspend themselves"))
+	}
+
+	can, err := u.HasPermission(tx, models.PermissionRolesManage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if can {
+		sole, err := u.SolePermissionHolder(tx, models.PermissionRolesManage)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if sole {
+			return c.Error(http.StatusForbidden, fmt.Errorf("%s is the last administrator and cannot be suspended", u.Username))
+		}
+	}
+
+	until := nulls.Time{}
+	if arg.Until != nil {
+		if !arg.Until.After(time.Now()) {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("suspension must end in the future"))
+		}
+		until = nulls.NewTime(*arg.Until)
+	}
+
+	if err := u.Suspend(tx, arg.Reason, until); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := models.RecordAudit(tx, admin, models.AuditSuspend, u, nil, u.SuspensionReason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// AdminUserUnsuspend lifts a user's suspension.
+func AdminUserUnsuspend(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	reason, err := adminReason(c)
+	if err != nil {
+		return err
+	}
+
+	if err := u.Unsuspend(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditUnsuspend, u, nil, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// AdminAppealList lists the suspended users who have appealed, oldest appeal
+// first.
+func AdminAppealList(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	users, err := models.GetSuspensionAppeals(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(users))
+}
+
+// AdminUserPasswordReset logs a user out everywhere and mails them a link to
+// choose a new password, which they must do before logging in again.
+func AdminUserPasswordReset(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	reason, err := adminReason(c)
+	if err != nil {
+		return err
+	}
+
+	if err := u.ForcePasswordReset(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditPasswordReset, u, nil, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ts, err := u.CreatePasswordResetToken()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	onCommit(c, func() {
+		if err := mailers.SendPasswordReset(u, ts); err != nil {
+			c.Logger().Errorf("could not send password reset to %s: %v", u.Username, err)
+		}
+	})
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// AdminUserMedia lists all of a user's media, whoever they are shared with.
+func AdminUserMedia(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	media, err := models.GetAllMediaByUser(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(media))
+}
+
+// AdminUserFollows shows who follows a user and whom they follow.
+func AdminUserFollows(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	followers, err := models.GetFollowers(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	following, err := models.GetFollowing(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		Followers []models.FollowEntry `json:"followers"`
+		Following []models.FollowEntry `json:"following"`
+	}{
+		Followers: followers,
+		Following: following,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// adminMedium looks up the medium named in the route, whoever may see it.
+func adminMedium(c buffalo.Context, tx *pop.Connection) (*models.Medium, error) {
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetAnyMediumByID(tx, id)
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	return m, nil
+}
+
+// AdminMediumRead shows any medium.
+func AdminMediumRead(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := adminMedium(c, tx)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, r.JSON(m))
+}
+
+// AdminMediumRemove deletes any medium along with its stored file.
+func AdminMediumRemove(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := adminMedium(c, tx)
+	if err != nil {
+		return err
+	}
+
+	reason, err := adminReason(c)
+	if err != nil {
+		return err
+	}
+
+	owner, _ := models.GetUserByID(tx, m.User)
+
+	key, err := models.RemoveMedium(tx, m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditRemoveMedium, owner, m, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	removeFileOnCommit(c, key)
+
+	return c.Render(http.StatusOK, r.JSON(m))
+}
+
+// AdminImpersonate issues a short-lived token for acting as a user, so that
+// support staff can see what they see. A reason is required and recorded,
+// and the token cannot be used to change the user's security settings.
+func AdminImpersonate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := adminTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	reason, err := adminReason(c)
+	if err != nil {
+		return err
+	}
+
+	if reason == "" {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("a reason is required to impersonate a user"))
+	}
+
+	admin := c.Value("user").(*models.User)
+	if admin.ID == u.ID {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("cannot impersonate yourself"))
+	}
+
+	if err := models.RecordAudit(tx, admin, models.AuditImpersonate, u, nil, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ts, err := u.CreateImpersonationToken(admin)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		Token     string       `json:"token"`
+		User      *models.User `json:"user"`
+		ExpiresAt time.Time    `json:"expires_at"`
+	}{
+		Token:     ts,
+		User:      u,
+		ExpiresAt: time.Now().Add(models.ImpersonationTTL()),
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// AdminAuditLog lists what administrators have done, newest first.
+func AdminAuditLog(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	page, perPage := pageParams(c)
+
+	audit, err := models.GetAuditEntries(tx, nil, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(audit))
+}
@@ -0,0 +1,227 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/markbates/pop/slices"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) login(email string, password string) int {
+	arg := map[string]string{
+		"email":    email,
+		"password": password,
+	}
+
+	return as.JSON("/api/1/login").Post(arg).Code
+}
+
+// Test_Admin_User_Search finds users by part of their username or email,
+// and only for administrators.
+func (as *ActionSuite) Test_Admin_User_Search() {
+	admin := as.createAdmin()
+	raja := as.createRaja()
+
+	res := as.authJSON(admin, "/api/admin/users?q=CLUTZ").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	users := []models.User{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &users))
+	as.Len(users, 1)
+	as.Equal("raja", users[0].Username)
+
+	res = as.authJSON(raja, "/api/admin/users").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+}
+
+// Test_Admin_Suspend locks a user out until they are unsuspended, records
+// both actions, and keeps the last administrator from being suspended.
+func (as *ActionSuite) Test_Admin_Suspend() {
+	admin := as.createAdmin()
+	raja := as.createRaja()
+
+	res := as.authJSON(admin, "/api/admin/users/oreo/suspend").Post(map[string]string{"reason": "oops"})
+	as.Equal(http.StatusForbidden, res.Code)
+
+	support := &models.Role{Name: "support", Permissions: slices.String{models.PermissionUsersManage}}
+	verrs, err := support.Create(as.DB)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+	as.NoError(models.GrantRole(as.DB, raja, support, admin))
+
+	res = as.authJSON(raja, "/api/admin/users/oreo/suspend").Post(map[string]string{"reason": "takeover"})
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "last administrator")
+	as.NoError(models.RevokeRole(as.DB, raja, support))
+
+	res = as.authJSON(admin, "/api/admin/users/raja/suspend").Post(map[string]string{"reason": "spam"})
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(raja, "/api/1/user").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "account is suspended")
+	as.Equal(http.StatusForbidden, as.login("clutz@example.com", "goodpassword"))
+
+	res = as.authJSON(admin, "/api/admin/users/raja/unsuspend").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(raja, "/api/1/user").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/users/raja").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	detail := struct {
+		Audit []models.AuditEntry `json:"audit"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &detail))
+	as.Len(detail.Audit, 2)
+
+	actions := map[string]string{}
+	for _, e := range detail.Audit {
+		actions[e.Action] = e.Reason
+	}
+	as.Equal("spam", actions[models.AuditSuspend])
+	as.Contains(actions, models.AuditUnsuspend)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM roles")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Admin_Password_Reset keeps a user out until they choose a new
+// password with the emailed link.
+func (as *ActionSuite) Test_Admin_Password_Reset() {
+	admin := as.createAdmin()
+	raja := as.createRaja()
+	_, key := as.createAPIKey(raja, models.ScopeProfileRead)
+	cl := as.createOAuthClient(admin)
+	_, token := as.exchange(cl, as.authorize(raja, cl, "profile:read"), testVerifier)
+
+	res := as.authJSON(admin, "/api/admin/users/raja/password/reset").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(raja, "/api/1/user").Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	req := as.JSON("/api/1/user")
+	req.Headers["Authorization"] = key
+	res = req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	req = as.JSON("/api/1/user")
+	req.Headers["Authorization"] = "Bearer " + token
+	res = req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+	as.Equal(http.StatusForbidden, as.login("clutz@example.com", "goodpassword"))
+
+	raja, err := models.GetUserByID(as.DB, raja.ID)
+	as.NoError(err)
+	ts, err := raja.CreatePasswordResetToken()
+	as.NoError(err)
+
+	reset := map[string]string{
+		"token":        ts,
+		"new_password": "newpassword",
+	}
+	res = as.JSON("/api/1/user/password/reset").Post(reset)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/user/password/reset").Post(reset)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "already been used")
+
+	as.Equal(http.StatusOK, as.login("clutz@example.com", "newpassword"))
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+	as.DB.RawQuery("DELETE FROM oauth_clients")
+}
+
+// Test_Admin_Impersonate needs a reason, records it, and gives a token that
+// sees what the user sees but cannot change their security settings.
+func (as *ActionSuite) Test_Admin_Impersonate() {
+	admin := as.createAdmin()
+	as.createRaja()
+
+	res := as.authJSON(admin, "/api/admin/users/raja/impersonate").Post(nil)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/users/raja/impersonate").Post(map[string]string{"reason": "ticket 42"})
+	as.Equal(http.StatusOK, res.Code)
+
+	imp := struct {
+		Token string `json:"token"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &imp))
+
+	req := as.JSON("/api/1/user")
+	req.Headers["Authorization"] = imp.Token
+	res = req.Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "clutz@example.com")
+
+	req = as.JSON("/api/1/user/password")
+	req.Headers["Authorization"] = imp.Token
+	res = req.Put(map[string]string{"current_password": "goodpassword", "new_password": "hijacked!"})
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "not allowed while impersonating")
+
+	res = as.authJSON(admin, "/api/admin/audit").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), models.AuditImpersonate)
+	as.Contains(res.Body.String(), "ticket 42")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Admin_Media views and removes a medium whatever its permission, and
+// shows a user's follows.
+func (as *ActionSuite) Test_Admin_Media() {
+	admin := as.createAdmin()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:        "https://example.com/secret.png",
+		User:       raja.ID,
+		Filetype:   "image/png",
+		Permission: "follower",
+	}
+	as.NoError(as.DB.Create(m))
+	as.NoError(as.DB.Create(&models.Follow{Follower: admin.ID, Followed: raja.ID}))
+
+	res := as.authJSON(admin, "/api/admin/users/raja/media").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "secret.png")
+
+	res = as.authJSON(admin, "/api/admin/users/raja/follows").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	follows := struct {
+		Followers []models.FollowEntry `json:"followers"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &follows))
+	as.Len(follows.Followers, 1)
+	as.Equal("oreo", follows.Followers[0].Username)
+
+	res = as.authJSON(admin, "/api/admin/media/"+m.ID.String()).Delete()
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/media/"+m.ID.String()).Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM follows")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
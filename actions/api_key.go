@@ -0,0 +1,152 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// hasScope reports whether the credentials the request was made with allow
+// scope. Session tokens allow everything; API keys only what they were
+// granted.
+func hasScope(c buffalo.Context, scope string) bool {
+	scopes, ok := c.Value("scopes").([]string)
+	if !ok {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireScope renders a 403 unless the request's credentials allow scope.
+func requireScope(c buffalo.Context, scope string) error {
+	if hasScope(c, scope) {
+		return nil
+	}
+
+	return c.Error(http.StatusForbidden, fmt.Errorf("credentials do not allow %s", scope))
+}
+
+// requireSession renders a 403 unless the request was made with a session
+// token. Account security settings cannot be changed with an API key, nor by
+// an administrator impersonating the user.
+func requireSession(c buffalo.Context) error {
+	if _, ok := c.Value("scopes").([]string); ok {
+		return c.Error(http.StatusForbidden, fmt.Errorf("must be logged in with a session token"))
+	}
+
+	if _, ok := c.Value("impersonator").(*models.User); ok {
+		return c.Error(http.StatusForbidden, fmt.Errorf("not allowed while impersonating a user"))
+	}
+
+	return nil
+}
+
+// APIKeyList lists the current user's API keys.
+func APIKeyList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list api keys"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	k, err := models.GetAPIKeysByUser(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(k))
+}
+
+// APIKeyCreate creates an API key for the current user. The key itself is
+// only ever returned in this response.
+func APIKeyCreate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to create api keys"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt nulls.Time `json:"expires_at"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	k, key, err := models.NewAPIKey(u, arg.Name, arg.Scopes, arg.ExpiresAt)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	verrs, err := k.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	res := struct {
+		Key    string         `json:"key"`
+		APIKey *models.APIKey `json:"api_key"`
+	}{
+		Key:    key,
+		APIKey: k,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// APIKeyRevoke deletes one of the current user's API keys.
+func APIKeyRevoke(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to revoke api keys"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("api key %s does not exist", c.Param("id")))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := models.RevokeAPIKey(tx, u, id); err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("api key %s does not exist", c.Param("id")))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(""))
+}
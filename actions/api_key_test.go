@@ -0,0 +1,156 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/markbates/pop/nulls"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) createAPIKey(u *models.User, scopes ...string) (*models.APIKey, string) {
+	k, key, err := models.NewAPIKey(u, "uploader", scopes, nulls.Time{})
+	as.NoError(err)
+
+	verrs, err := k.Create(as.DB)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+
+	return k, key
+}
+
+// Test_API_Key_Create creates a key and uses it to read the user.
+func (as *ActionSuite) Test_API_Key_Create() {
+	u := as.createOreo()
+
+	arg := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{
+		Name:   "uploader",
+		Scopes: []string{models.ScopeProfileRead},
+	}
+
+	req := as.JSON("/api/1/user/keys")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), models.APIKeyPrefix)
+	as.NotContains(res.Body.String(), "key_hash")
+
+	body := struct {
+		Key string `json:"key"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+
+	req = as.JSON("/api/1/user")
+	req.Headers["Authorization"] = body.Key
+	res = req.Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "oreo")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM api_keys")
+}
+
+// Test_API_Key_Bad_Scope refuses to create a key with an unknown scope.
+func (as *ActionSuite) Test_API_Key_Bad_Scope() {
+	u := as.createOreo()
+
+	arg := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{
+		Name:   "uploader",
+		Scopes: []string{"everything"},
+	}
+
+	req := as.JSON("/api/1/user/keys")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "unknown scope in everything")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_API_Key_Missing_Scope refuses an action the key was not granted.
+func (as *ActionSuite) Test_API_Key_Missing_Scope() {
+	u := as.createOreo()
+	_, key := as.createAPIKey(u, models.ScopeMediaRead)
+
+	arg := struct {
+		URI      string `json:"uri"`
+		FileType string `json:"type"`
+	}{
+		URI:      "someplace",
+		FileType: "image/png",
+	}
+
+	req := as.JSON("/api/1/media")
+	req.Headers["Authorization"] = key
+	res := req.Post(arg)
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "credentials do not allow media:write")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM api_keys")
+}
+
+// Test_API_Key_Expired refuses a key past its expiry.
+func (as *ActionSuite) Test_API_Key_Expired() {
+	u := as.createOreo()
+
+	k, key, err := models.NewAPIKey(u, "uploader", []string{models.ScopeProfileRead}, nulls.NewTime(time.Now().Add(-time.Hour)))
+	as.NoError(err)
+	as.NoError(as.DB.Create(k))
+
+	req := as.JSON("/api/1/user")
+	req.Headers["Authorization"] = key
+	res := req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM api_keys")
+}
+
+// Test_API_Key_Revoke revokes a key, which then stops working.
+func (as *ActionSuite) Test_API_Key_Revoke() {
+	u := as.createOreo()
+	k, key := as.createAPIKey(u, models.ScopeProfileRead)
+
+	req := as.JSON(fmt.Sprintf("/api/1/user/keys/%s", k.ID))
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Delete()
+	as.Equal(http.StatusOK, res.Code)
+
+	req = as.JSON("/api/1/user")
+	req.Headers["Authorization"] = key
+	res = req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_API_Key_Cannot_Manage_Keys keeps keys from creating more keys.
+func (as *ActionSuite) Test_API_Key_Cannot_Manage_Keys() {
+	u := as.createOreo()
+	_, key := as.createAPIKey(u, models.Scopes...)
+
+	req := as.JSON("/api/1/user/keys")
+	req.Headers["Authorization"] = key
+	res := req.Get()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM api_keys")
+}
@@ -26,6 +26,12 @@ func App() *buffalo.App {
 			SessionName:  "_rmuse_session",
 		})
 
+		throttle = newLoginThrottle()
+
+		// Background jobs
+		app.Worker.Register(deleteAccountJob, deleteAccount)
+		app.Worker.Register(exportAccountJob, exportAccount)
+
 		// Set the request content type to JSON
 		app.Use(middleware.SetContentType("application/json"))
 
@@ -40,32 +46,158 @@ func App() *buffalo.App {
 			return nil
 		}
 
+		// Runs work queued with onCommit once the transaction commits.
+		app.Use(AfterCommit)
+
 		// Wraps each request in a transaction.
 		//  c.Value("tx").(*pop.PopTransaction)
 		// Remove to disable this.
 		app.Use(middleware.PopTransaction(models.DB))
 
+		// Uploaded files, served to whoever may see them
+		uploads := app.Group("/uploads")
+		uploads.Use(VerifyToken)
+		uploads.GET("/{key:.+}", UploadServe)
+
 		// API V1 Grouping
 		v1 := app.Group("/api/1")
 
 		// Add middleware
 		v1.Use(VerifyToken)
-		v1.Middleware.Skip(VerifyToken, AuthCreateSession, UserCreate)
+		v1.Middleware.Skip(VerifyToken, AuthCreateSession, AuthVerifyMFA, UserCreate, UserVerifyEmail, UserConfirmDeletion, UserResetPassword, SuspensionAppeal, ExportDownload, OAuthToken, AuthOIDCStart, AuthOIDCCallback)
 
 		// Login
 		v1.POST("/login", AuthCreateSession)
+		v1.POST("/login/mfa", AuthVerifyMFA)
+		v1.GET("/login/oidc", AuthOIDCStart)
+		v1.POST("/login/oidc", AuthOIDCCallback)
 
 		// Users
 		v1.GET("/user", UserRead)
 		v1.POST("/user", UserCreate)
 		v1.PUT("/user", UserUpdate)
+		v1.PATCH("/user", UserUpdate)
+		v1.PUT("/user/password", UserChangePassword)
+		v1.POST("/user/password/reset", UserResetPassword)
+		v1.POST("/user/suspension/appeal", SuspensionAppeal)
+		v1.PUT("/user/email", UserChangeEmail)
+		v1.PUT("/user/username", UserChangeUsername)
+		v1.GET("/user/username/history", UserUsernameHistory)
+		v1.GET("/user/roles", UserRoles)
+		v1.GET("/user/content", UserContentRead)
+		v1.PUT("/user/content", UserContentUpdate)
+		v1.GET("/user/notifications", UserNotificationsRead)
+		v1.PUT("/user/notifications", UserNotificationsUpdate)
+		v1.POST("/user/age", UserConfirmAge)
+		v1.DELETE("/user/age", UserWithdrawAge)
+		v1.GET("/user/artist/application", ArtistApplicationList)
+		v1.POST("/user/artist/application", ArtistApply)
+		v1.POST("/user/verify", UserVerifyEmail)
+		v1.POST("/user/verify/resend", UserResendVerification)
+		v1.POST("/user/mfa", MFAEnroll)
+		v1.POST("/user/mfa/confirm", MFAConfirm)
+		v1.POST("/user/mfa/disable", MFADisable)
+		v1.GET("/user/keys", APIKeyList)
+		v1.POST("/user/keys", APIKeyCreate)
+		v1.DELETE("/user/keys/{id}", APIKeyRevoke)
+		v1.GET("/user/apps", UserAppList)
+		v1.DELETE("/user/apps/{client_id}", UserAppRevoke)
+		v1.PUT("/user/avatar", UserSetAvatar)
+		v1.DELETE("/user/avatar", UserRemoveAvatar)
+		v1.PUT("/user/banner", UserSetBanner)
+		v1.DELETE("/user/banner", UserRemoveBanner)
+		v1.POST("/user/deactivate", UserDeactivate)
+		v1.POST("/user/reactivate", UserReactivate)
+		v1.POST("/user/delete", UserRequestDeletion)
+		v1.POST("/user/delete/confirm", UserConfirmDeletion)
+		v1.GET("/user/exports", UserExportList)
+		v1.POST("/user/export", UserExportCreate)
+		v1.GET("/export/download", ExportDownload)
 		v1.GET("/media", MediaGet)
 		v1.POST("/media", MediaUpload)
+		v1.POST("/media/import", MediaImport)
+		v1.PUT("/media/{id}/warnings", MediumSetWarnings)
+		v1.POST("/media/{id}/like", MediumLike)
+		v1.DELETE("/media/{id}/like", MediumUnlike)
+		v1.GET("/media/{id}/likes", MediumLikers)
+		v1.GET("/media/{id}/comments", MediumComments)
+		v1.POST("/media/{id}/comments", CommentCreate)
+		v1.POST("/media/{id}/comments/disable", MediumDisableComments)
+		v1.POST("/media/{id}/comments/enable", MediumEnableComments)
+		v1.GET("/comments/{id}/replies", CommentReplies)
+		v1.PUT("/comments/{id}", CommentUpdate)
+		v1.DELETE("/comments/{id}", CommentDelete)
 		v1.GET("/user/{username}", UserPageFetch)
 		v1.POST("/user/{username}/follow", UserFollow)
 		v1.DELETE("/user/{username}/follow", UserUnfollow)
+		v1.GET("/user/{username}/likes", UserLikes)
+		v1.GET("/tags/{tag}", TagMedia)
+		v1.GET("/notifications", NotificationList)
+		v1.POST("/notifications/read", NotificationReadAll)
+		v1.POST("/notifications/{id}/read", NotificationRead)
+		v1.GET("/reports", ReportList)
+		v1.POST("/reports", ReportCreate)
+
+		// OAuth
+		v1.GET("/oauth/clients", OAuthClientList)
+		v1.POST("/oauth/clients", OAuthClientCreate)
+		v1.GET("/oauth/authorize", OAuthAuthorize)
+		v1.POST("/oauth/authorize", OAuthConsent)
+		v1.POST("/oauth/token", OAuthToken)
+
+		// Administration
+		admin := app.Group("/api/admin")
+		admin.Use(VerifyToken)
+		admin.Use(RequirePermission(models.PermissionUsersManage))
+		admin.GET("/users", AdminUserSearch)
+		admin.GET("/users/{username}", AdminUserRead)
+		admin.POST("/users/{username}/suspend", AdminUserSuspend)
+		admin.POST("/users/{username}/unsuspend", AdminUserUnsuspend)
+		admin.GET("/appeals", AdminAppealList)
+		admin.POST("/users/{username}/password/reset", AdminUserPasswordReset)
+		admin.GET("/users/{username}/media", AdminUserMedia)
+		admin.GET("/users/{username}/follows", AdminUserFollows)
+		admin.POST("/users/{username}/impersonate", AdminImpersonate)
+		admin.GET("/media/{id}", AdminMediumRead)
+		admin.DELETE("/media/{id}", AdminMediumRemove)
+		admin.GET("/audit", AdminAuditLog)
+
+		// Roles
+		roles := app.Group("/api/admin")
+		roles.Use(VerifyToken)
+		roles.Use(RequirePermission(models.PermissionRolesManage))
+		roles.GET("/roles", AdminRoleList)
+		roles.POST("/roles", AdminRoleCreate)
+		roles.PUT("/roles/{role}", AdminRoleUpdate)
+		roles.DELETE("/roles/{role}", AdminRoleDelete)
+		roles.GET("/users/{username}/roles", AdminUserRoles)
+		roles.PUT("/users/{username}/roles/{role}", AdminRoleGrant)
+		roles.DELETE("/users/{username}/roles/{role}", AdminRoleRevoke)
+
+		// Artist applications
+		review := v1.Group("/moderation")
+		review.Use(RequirePermission(models.PermissionArtistsReview))
+		review.GET("/artists", ArtistReviewQueue)
+		review.POST("/artists/{id}/approve", ArtistApprove)
+		review.POST("/artists/{id}/reject", ArtistReject)
+
+		// Reports and the image blocklist
+		moderation := v1.Group("/moderation")
+		moderation.Use(RequirePermission(models.PermissionContentModerate))
+		moderation.GET("/reports", ModerationQueue)
+		moderation.POST("/reports/{id}/assign", ReportAssign)
+		moderation.DELETE("/reports/{id}/assign", ReportUnassign)
+		moderation.POST("/reports/{id}/dismiss", ReportDismiss)
+		moderation.POST("/reports/{id}/hide", ReportHideMedium)
+		moderation.POST("/reports/{id}/suspend", ReportSuspendUser)
+		moderation.POST("/reports/{id}/warn", ReportWarnUser)
+		moderation.GET("/blocklist", BlocklistList)
+		moderation.POST("/blocklist", BlocklistAdd)
+		moderation.GET("/blocklist/matches", BlocklistMatches)
+		moderation.DELETE("/blocklist/{id}", BlocklistRemove)
+		moderation.POST("/quarantine/{id}/release", QuarantineRelease)
+		moderation.POST("/quarantine/{id}/reject", QuarantineReject)
 	}
 
 	return app
 }
-
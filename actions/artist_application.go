@@ -0,0 +1,207 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+)
+
+// ArtistApply asks for the current user to be made an artist. The
+// application waits in the review queue until a reviewer decides it.
+func ArtistApply(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to apply"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if u.Artist {
+		return c.Error(http.StatusConflict, fmt.Errorf("already an artist"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	pending, err := models.HasPendingArtistApplication(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if pending {
+		return c.Error(http.StatusConflict, fmt.Errorf("an application is already waiting for review"))
+	}
+
+	type argument struct {
+		Links     []string `json:"links"`
+		Statement string   `json:"statement"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	a := models.NewArtistApplication(u, arg.Links, arg.Statement)
+	verrs, err := a.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return c.Render(http.StatusCreated, r.JSON(a))
+}
+
+// ArtistApplicationList lists the current user's applications and their
+// outcomes.
+func ArtistApplicationList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list applications"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	a, err := models.GetArtistApplicationsByUser(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(a))
+}
+
+// ArtistReviewQueue lists the applications waiting for review, oldest first.
+func ArtistReviewQueue(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	apps, err := models.GetPendingArtistApplications(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	type queued struct {
+		models.ArtistApplication
+		Username string `json:"username"`
+	}
+
+	queue := []queued{}
+	for _, a := range apps {
+		u, err := models.GetUserByID(tx, a.User)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		queue = append(queue, queued{ArtistApplication: a, Username: u.Username})
+	}
+
+	return c.Render(http.StatusOK, r.JSON(queue))
+}
+
+// reviewedApplication looks up the application named in the route, refusing
+// reviewers who applied themselves.
+func reviewedApplication(c buffalo.Context, tx *pop.Connection) (*models.ArtistApplication, error) {
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("application not found"))
+	}
+
+	a, err := models.GetArtistApplicationByID(tx, id)
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("application not found"))
+	}
+
+	if reviewer, ok := c.Value("user").(*models.User); ok && reviewer.ID == a.User {
+		return nil, c.Error(http.StatusForbidden, fmt.Errorf("cannot review your own application"))
+	}
+
+	if !a.Pending() {
+		return nil, c.Error(http.StatusConflict, fmt.Errorf("application has already been %s", a.Status))
+	}
+
+	return a, nil
+}
+
+// sendApplicationDecision notifies the applicant of the outcome, and mails it
+// once the decision has committed. Mail failures are logged; the decision
+// stands either way and shows in their application list.
+func sendApplicationDecision(c buffalo.Context, tx *pop.Connection, a *models.ArtistApplication) error {
+	u, err := models.GetUserByID(tx, a.User)
+	if err != nil {
+		c.Logger().Errorf("could not find applicant %s: %v", a.User, err)
+		return nil
+	}
+
+	if err := models.Notify(tx, u, models.Event{Type: models.NotifyModeration, Message: a.DecisionMessage()}); err != nil {
+		return err
+	}
+
+	onCommit(c, func() {
+		if err := mailers.SendArtistApplicationDecision(u, a); err != nil {
+			c.Logger().Errorf("could not send application decision to %s: %v", u.Username, err)
+		}
+	})
+
+	return nil
+}
+
+// ArtistApprove approves an application, making its applicant an artist.
+func ArtistApprove(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	a, err := reviewedApplication(c, tx)
+	if err != nil {
+		return err
+	}
+
+	if err := a.Approve(tx, c.Value("user").(*models.User)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := sendApplicationDecision(c, tx, a); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(a))
+}
+
+// ArtistReject rejects an application. A reason is required, and is passed
+// on to the applicant.
+func ArtistReject(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	a, err := reviewedApplication(c, tx)
+	if err != nil {
+		return err
+	}
+
+	type argument struct {
+		Reason string `json:"reason"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	verrs, err := a.Reject(tx, c.Value("user").(*models.User), arg.Reason)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	if err := sendApplicationDecision(c, tx, a); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(a))
+}
@@ -0,0 +1,163 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) createModerator() *models.User {
+	u := as.createOreo()
+
+	r, err := models.GetRoleByName(as.DB, models.RoleModerator)
+	as.NoError(err)
+	as.NoError(models.GrantRole(as.DB, u, r, nil))
+
+	return u
+}
+
+func (as *ActionSuite) applyForArtist(u *models.User) *models.ArtistApplication {
+	arg := map[string]interface{}{
+		"links":     []string{"https://raja.example.com/portfolio"},
+		"statement": "I make sound sculptures.",
+	}
+
+	res := as.authJSON(u, "/api/1/user/artist/application").Post(arg)
+	as.Equal(http.StatusCreated, res.Code)
+
+	a := &models.ArtistApplication{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), a))
+	as.Equal(models.ApplicationPending, a.Status)
+
+	return a
+}
+
+// Test_Artist_Application_Approve queues an application and approves it,
+// making the applicant a verified artist.
+func (as *ActionSuite) Test_Artist_Application_Approve() {
+	mod := as.createModerator()
+	raja := as.createRaja()
+
+	a := as.applyForArtist(raja)
+
+	res := as.authJSON(raja, "/api/1/user/artist/application").Post(map[string]interface{}{
+		"links":     []string{"https://raja.example.com/portfolio"},
+		"statement": "Again.",
+	})
+	as.Equal(http.StatusConflict, res.Code)
+
+	res = as.authJSON(raja, "/api/1/moderation/artists").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(mod, "/api/1/moderation/artists").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "raja")
+	as.Contains(res.Body.String(), "I make sound sculptures.")
+
+	res = as.authJSON(mod, "/api/1/moderation/artists/"+a.ID.String()+"/approve").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	u, err := models.GetUserByID(as.DB, raja.ID)
+	as.NoError(err)
+	as.True(u.Artist)
+	as.True(u.VerifiedArtist())
+
+	res = as.JSON("/api/1/user/raja").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	page := struct {
+		Profile models.Profile `json:"profile"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &page))
+	as.True(page.Profile.VerifiedArtist)
+	as.NotNil(page.Profile.ArtistSince)
+
+	res = as.authJSON(mod, "/api/1/moderation/artists/"+a.ID.String()+"/reject").Post(map[string]string{"reason": "Too late."})
+	as.Equal(http.StatusConflict, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM artist_applications")
+}
+
+// Test_Artist_Application_Reject needs a reason to reject an application,
+// and shows it to the applicant.
+func (as *ActionSuite) Test_Artist_Application_Reject() {
+	mod := as.createModerator()
+	raja := as.createRaja()
+
+	a := as.applyForArtist(raja)
+
+	path := "/api/1/moderation/artists/" + a.ID.String() + "/reject"
+	res := as.authJSON(mod, path).Post(map[string]string{"reason": ""})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "a reason is required to reject an application")
+
+	res = as.authJSON(mod, path).Post(map[string]string{"reason": "The portfolio link is broken."})
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(raja, "/api/1/user/artist/application").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	apps := []models.ArtistApplication{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &apps))
+	as.Len(apps, 1)
+	as.Equal(models.ApplicationRejected, apps[0].Status)
+	as.Equal("The portfolio link is broken.", apps[0].Reason)
+
+	u, err := models.GetUserByID(as.DB, raja.ID)
+	as.NoError(err)
+	as.False(u.Artist)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM artist_applications")
+}
+
+// Test_Artist_Application_Own refuses to let a reviewer decide their own
+// application.
+func (as *ActionSuite) Test_Artist_Application_Own() {
+	raja := as.createRaja()
+
+	r, err := models.GetRoleByName(as.DB, models.RoleModerator)
+	as.NoError(err)
+	as.NoError(models.GrantRole(as.DB, raja, r, nil))
+
+	a := as.applyForArtist(raja)
+
+	res := as.authJSON(raja, "/api/1/moderation/artists/"+a.ID.String()+"/approve").Post(nil)
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(raja, "/api/1/moderation/artists/"+a.ID.String()+"/reject").Post(map[string]string{"reason": "No."})
+	as.Equal(http.StatusForbidden, res.Code)
+
+	u, err := models.GetUserByID(as.DB, raja.ID)
+	as.NoError(err)
+	as.False(u.Artist)
+
+	// the artist flag alone, as legacy accounts have it, grants nothing
+	u.Artist = true
+	names, err := u.RoleNames(as.DB)
+	as.NoError(err)
+	as.NotContains(names, models.RoleArtist)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM artist_applications")
+}
+
+// Test_User_Update_Artist refuses to let a user make themselves an artist.
+func (as *ActionSuite) Test_User_Update_Artist() {
+	raja := as.createRaja()
+
+	res := as.authJSON(raja, "/api/1/user").Put(map[string]bool{"artist": true})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "artist status must be applied for")
+
+	u, err := models.GetUserByID(as.DB, raja.ID)
+	as.NoError(err)
+	as.False(u.Artist)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
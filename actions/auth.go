@@ -3,27 +3,19 @@ package actions
 import (
 	"database/sql"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gobuffalo/buffalo"
-	"github.com/gobuffalo/envy"
 	"github.com/markbates/pop"
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
-	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/derhabicht/rmuse/models"
 )
 
 func AuthCreateSession(c buffalo.Context) error {
-	bad := func() error {
-		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid email or password"))
-	}
-
 	type argument struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -34,6 +26,22 @@ func AuthCreateSession(c buffalo.Context) error {
 		return errors.WithStack(err)
 	}
 
+	keys := []string{accountKey(arg.Email), addressKey(c)}
+	bad := func(reason string) error {
+		throttle.fail(c, arg.Email, reason, keys...)
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid email or password"))
+	}
+
+	wait, err := throttle.retryAfter(keys...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if wait > 0 {
+		throttle.fail(c, arg.Email, models.LoginLockedOut)
+		return throttle.refuse(c, wait)
+	}
+
 	u := &models.User{
 		Email: arg.Email,
 	}
@@ -41,11 +49,11 @@ func AuthCreateSession(c buffalo.Context) error {
 	tx := c.Value("tx").(*pop.Connection)
 
 	// Try to find the user by email
-	err := tx.Where("email = ?", strings.ToLower(u.Email)).First(u)
+	err = tx.Where("email = ?", strings.ToLower(u.Email)).First(u)
 
 	if err != nil {
 		if errors.Cause(err) == sql.ErrNoRows {
-			return bad()
+			return bad(models.LoginUnknownEmail)
 		}
 		return errors.WithStack(err)
 	}
@@ -53,9 +61,124 @@ func AuthCreateSession(c buffalo.Context) error {
 	// Test the user's password
 	err = bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(arg.Password))
 	if err != nil {
+		return bad(models.LoginBadPassword)
+	}
+
+	// Two-factor accounts stay counted until the second factor is given too.
+	if !u.TOTPEnabled {
+		if err := throttle.Store.Reset(accountKey(u.Email)); err != nil {
+			c.Logger().Errorf("could not reset failed logins: %v", err)
+		}
+	}
+
+	return beginSession(c, u)
+}
+
+// beginSession logs u in once they have authenticated with their first
+// factor. Users with two-factor authentication get a token to exchange at
+// AuthVerifyMFA rather than a session token.
+func beginSession(c buffalo.Context, u *models.User) error {
+	if u.DueForDeletion() {
+		return c.Error(http.StatusForbidden, fmt.Errorf("account is being deleted"))
+	}
+
+	if u.Suspended() {
+		return renderSuspension(c, u)
+	}
+
+	if u.PasswordResetRequired {
+		return c.Error(http.StatusForbidden, fmt.Errorf("password must be reset, follow the link emailed to you"))
+	}
+
+	if !u.TOTPEnabled {
+		return renderSession(c, u)
+	}
+
+	ts, err := u.CreateMFAToken()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{
+		MFARequired: true,
+		MFAToken:    ts,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// AuthVerifyMFA exchanges the token returned by AuthCreateSession for a
+// two-factor user, together with a TOTP or recovery code, for a session
+// token.
+func AuthVerifyMFA(c buffalo.Context) error {
+	bad := func() error {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid authentication code"))
+	}
+
+	type argument struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return errors.WithStack(err)
+	}
+
+	claims, err := models.ParseToken(arg.MFAToken, models.TokenMFA)
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, err)
+	}
+
+	jti, _ := claims["jti"].(string)
+	id, err := uuid.FromString(jti)
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := models.GetUserByID(tx, id)
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+	}
+
+	if !u.TOTPEnabled {
 		return bad()
 	}
 
+	keys := []string{accountKey(u.Email), addressKey(c)}
+	wait, err := throttle.retryAfter(keys...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if wait > 0 {
+		throttle.fail(c, u.Email, models.LoginLockedOut)
+		return throttle.refuse(c, wait)
+	}
+
+	if !u.UseTOTP(tx, arg.Code) && !models.UseRecoveryCode(tx, u, arg.Code) {
+		throttle.fail(c, u.Email, models.LoginBadCode, keys...)
+		return bad()
+	}
+
+	if err := throttle.Store.Reset(accountKey(u.Email)); err != nil {
+		c.Logger().Errorf("could not reset failed logins: %v", err)
+	}
+
+	// the account may have been suspended since the first factor was given
+	if u.Suspended() {
+		return renderSuspension(c, u)
+	}
+
+	return renderSession(c, u)
+}
+
+// renderSession issues u a session token and renders it along with the user.
+func renderSession(c buffalo.Context, u *models.User) error {
 	ts, err := u.CreateJWTToken()
 	if err != nil {
 		return errors.WithStack(err)
@@ -75,56 +198,152 @@ func AuthCreateSession(c buffalo.Context) error {
 func VerifyToken(next buffalo.Handler) buffalo.Handler {
 	return func(c buffalo.Context) error {
 		tokenString := c.Request().Header.Get("Authorization")
+		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
 		if len(tokenString) == 0 {
 			c.Set("user", nil)
 			return next(c)
 		}
 
-		// parsing token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		tx := c.Value("tx").(*pop.Connection)
+
+		if strings.HasPrefix(tokenString, models.APIKeyPrefix) {
+			k, u, err := models.AuthenticateAPIKey(tx, tokenString)
+			if err != nil {
+				return c.Error(http.StatusUnauthorized, fmt.Errorf("invalid api key"))
 			}
 
-			// key
-			sk, err := ioutil.ReadFile(envy.Get("JWT_KEY_PATH", "jwtRS256.key"))
+			if u.Deactivated() {
+				return c.Error(http.StatusUnauthorized, fmt.Errorf("account is deactivated"))
+			}
 
-			if err != nil {
-				return nil, fmt.Errorf("could not open jwt key, %v", err)
+			if err := refuseAccount(c, u); err != nil {
+				return err
 			}
 
-			return sk, nil
-		})
+			c.Set("user", u)
+			c.Set("scopes", []string(k.Scopes))
+
+			return next(c)
+		}
 
+		// parsing token
+		claims, err := models.ParseToken(tokenString, "")
+		if err != nil {
+			return c.Error(http.StatusUnauthorized, err)
+		}
+
+		// retrieving user from db
+		jti, _ := claims["jti"].(string)
+		id, err := uuid.FromString(jti)
 		if err != nil {
-			return c.Error(http.StatusUnauthorized, fmt.Errorf("could not parse the token, %v", err))
+			return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+		}
+
+		u, err := models.GetUserByID(tx, id)
+
+		if err != nil || u.DueForDeletion() {
+			return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
 		}
 
-		// getting claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			tx := c.Value("tx").(*pop.Connection)
+		iat, _ := claims["iat"].(float64)
+		if u.TokenRevoked(int64(iat)) {
+			return c.Error(http.StatusUnauthorized, fmt.Errorf("token has been revoked"))
+		}
 
-			logrus.Errorf("claims: %v", claims)
+		if err := refuseAccount(c, u); err != nil {
+			return err
+		}
 
-			// retrieving user from db
-			id, err := uuid.FromString(claims["jti"].(string))
+		// impersonation tokens only work while the administrator who
+		// asked for one still may
+		if imp, ok := claims["imp"].(string); ok {
+			admin, err := impersonator(tx, imp)
 			if err != nil {
-				return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+				return c.Error(http.StatusUnauthorized, err)
 			}
 
-			u, err := models.GetUserByID(tx, id)
+			c.Set("impersonator", admin)
+		}
+
+		// tokens issued to OAuth clients are limited to the scopes the
+		// user granted, and only while the grant stands
+		if cid, ok := claims["cid"].(string); ok {
+			if u.Deactivated() {
+				return c.Error(http.StatusUnauthorized, fmt.Errorf("account is deactivated"))
+			}
 
+			client, err := uuid.FromString(cid)
 			if err != nil {
-				return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+				return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the application"))
 			}
 
-			c.Set("user", u)
+			g, err := models.GetOAuthGrant(tx, u, client)
+			if err != nil {
+				return c.Error(http.StatusUnauthorized, fmt.Errorf("application access has been revoked"))
+			}
 
-		} else {
-			return c.Error(http.StatusUnauthorized, fmt.Errorf("failed to validate token: %v", claims))
+			scopes := []string{}
+			scope, _ := claims["scope"].(string)
+			for _, s := range strings.Fields(scope) {
+				if g.HasScope(s) {
+					scopes = append(scopes, s)
+				}
+			}
+
+			c.Set("scopes", scopes)
+		}
+
+		// a deactivated account's session can only bring it back or see it
+		// deleted
+		if u.Deactivated() && !deactivatedPaths[c.Request().URL.Path] {
+			return c.Error(http.StatusUnauthorized, fmt.Errorf("account is deactivated"))
 		}
 
+		c.Set("user", u)
+
 		return next(c)
 	}
 }
+
+// deactivatedPaths are the routes a deactivated user's session token still
+// works for.
+var deactivatedPaths = map[string]bool{
+	"/api/1/user/reactivate": true,
+	"/api/1/user/delete":     true,
+}
+
+// refuseAccount renders an error if u may not use the API at all, whatever
+// credentials they present.
+func refuseAccount(c buffalo.Context, u *models.User) error {
+	if u.Suspended() {
+		return c.Error(http.StatusForbidden, fmt.Errorf("account is suspended"))
+	}
+
+	if u.PasswordResetRequired {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("password must be reset"))
+	}
+
+	return nil
+}
+
+// impersonator returns the administrator named by an impersonation token, if
+// they are still allowed to impersonate users.
+func impersonator(tx *pop.Connection, id string) (*models.User, error) {
+	aid, err := uuid.FromString(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify the administrator")
+	}
+
+	admin, err := models.GetUserByID(tx, aid)
+	if err != nil || admin.Suspended() || admin.Deactivated() {
+		return nil, fmt.Errorf("could not identify the administrator")
+	}
+
+	can, err := admin.HasPermission(tx, models.PermissionUsersManage)
+	if err != nil || !can {
+		return nil, fmt.Errorf("impersonation is no longer allowed")
+	}
+
+	return admin, nil
+}
@@ -2,7 +2,9 @@ package actions
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/markbates/pop/nulls"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/derhabicht/rmuse/models"
@@ -29,12 +31,13 @@ func (as *ActionSuite) Test_Login_Bad_Password() {
 	as.NoError(err)
 
 	u := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		UserType:     "artist",
-		PasswordHash: string(ph),
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
+		PasswordHash:     string(ph),
 	}
 
 	err = as.DB.Create(&u)
@@ -61,12 +64,13 @@ func (as *ActionSuite) Test_Login_Good_User() {
 	as.NoError(err)
 
 	u := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&u)
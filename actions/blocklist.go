@@ -0,0 +1,216 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// BlocklistList shows a page of the images that may not be uploaded.
+func BlocklistList(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	page, perPage := pageParams(c)
+
+	blocked, err := models.GetBlockedHashes(tx, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(blocked))
+}
+
+// BlocklistAdd blocks uploads of images like one of the site's media, given
+// by medium_id, or like the image with a known hash, given as 16 hex digits.
+func BlocklistAdd(c buffalo.Context) error {
+	type argument struct {
+		Medium string `json:"medium_id"`
+		Hash   string `json:"hash"`
+		Reason string `json:"reason"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if (arg.Medium == "") == (arg.Hash == "") {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("exactly one of medium_id and hash is required"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	var h models.ImageHash
+	var m *models.Medium
+	var owner *models.User
+
+	if arg.Medium != "" {
+		id, err := uuid.FromString(arg.Medium)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("medium %s not found", arg.Medium))
+		}
+
+		m, err = models.GetAnyMediumByID(tx, id)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("medium %s not found", arg.Medium))
+		}
+
+		if m.PerceptualHash.Valid {
+			h = models.ImageHash(m.PerceptualHash.Int64)
+		} else {
+			img, err := m.StoredImage()
+			if err != nil {
+				return c.Error(http.StatusUnprocessableEntity, err)
+			}
+			h = models.PerceptualHash(img)
+		}
+
+		owner, _ = models.GetUserByID(tx, m.User)
+	} else {
+		var err error
+		if h, err = models.ParseImageHash(arg.Hash); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, err)
+		}
+	}
+
+	mu := c.Value("user").(*models.User)
+	b := models.NewBlockedHash(mu, h, m, arg.Reason)
+
+	verrs, err := b.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	if err := models.RecordAudit(tx, mu, models.AuditBlockHash, owner, m, b.Reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(b))
+}
+
+// BlocklistRemove lets images like a blocked one be uploaded again.
+func BlocklistRemove(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("blocked hash not found"))
+	}
+
+	b, err := models.GetBlockedHashByID(tx, id)
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("blocked hash not found"))
+	}
+
+	if err := b.Delete(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	reason := fmt.Sprintf("unblocked hash %s", b.Hash)
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditUnblockHash, nil, nil, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(b))
+}
+
+// BlocklistMatches shows a page of the uploads the blocklist has rejected or
+// quarantined, newest first.
+func BlocklistMatches(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	page, perPage := pageParams(c)
+
+	matches, err := models.GetHashMatches(tx, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(matches))
+}
+
+// quarantinedMedium looks up the quarantined medium named in the route.
+func quarantinedMedium(c buffalo.Context, tx *pop.Connection) (*models.Medium, error) {
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetAnyMediumByID(tx, id)
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	q, err := m.Quarantined(tx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !q {
+		return nil, c.Error(http.StatusConflict, fmt.Errorf("medium is not quarantined"))
+	}
+
+	return m, nil
+}
+
+// QuarantineRelease shows a quarantined upload to everyone who may see it.
+func QuarantineRelease(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := quarantinedMedium(c, tx)
+	if err != nil {
+		return err
+	}
+
+	reason, err := adminReason(c)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Release(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	owner, _ := models.GetUserByID(tx, m.User)
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditReleaseUpload, owner, m, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(m))
+}
+
+// QuarantineReject deletes a quarantined upload along with its stored file.
+func QuarantineReject(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := quarantinedMedium(c, tx)
+	if err != nil {
+		return err
+	}
+
+	reason, err := adminReason(c)
+	if err != nil {
+		return err
+	}
+
+	owner, _ := models.GetUserByID(tx, m.User)
+
+	key, err := models.RemoveMedium(tx, m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditRejectUpload, owner, m, reason); err != nil {
+		return errors.WithStack(err)
+	}
+
+	removeFileOnCommit(c, key)
+
+	return c.Render(http.StatusOK, r.JSON(m))
+}
@@ -0,0 +1,236 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop/nulls"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// patternPNG draws a size by size grid of grey blocks, inverted if invert is
+// set. The same pattern at different sizes has nearly the same perceptual
+// hash.
+func patternPNG(size int, invert bool) []byte {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := uint8((x*8/size*37 + y*8/size*91) % 256)
+			if invert {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, img)
+	return buf.Bytes()
+}
+
+// uploadMedium uploads data as a new medium for u.
+func (as *ActionSuite) uploadMedium(u *models.User, data []byte) (*httptest.ResponseRecorder, *models.Medium) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "pattern.png")
+	as.NoError(err)
+	fw.Write(data)
+	as.NoError(mw.WriteField("medium", `{"caption": "a pattern"}`))
+	as.NoError(mw.Close())
+
+	req, err := http.NewRequest("POST", "/api/1/media", body)
+	as.NoError(err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Header.Set("Authorization", ts)
+
+	res := httptest.NewRecorder()
+	as.App.ServeHTTP(res, req)
+
+	m := &models.Medium{}
+	json.Unmarshal(res.Body.Bytes(), m)
+	return res, m
+}
+
+// createUploader creates raja as a verified artist.
+func (as *ActionSuite) createUploader() *models.User {
+	u := as.createRaja()
+	u.EmailVerifiedAt = nulls.NewTime(time.Now())
+	as.NoError(as.DB.Update(u))
+
+	r, err := models.GetRoleByName(as.DB, models.RoleArtist)
+	as.NoError(err)
+	as.NoError(models.GrantRole(as.DB, u, r, nil))
+
+	return u
+}
+
+// Test_Blocklist_Reject rejects uploads like a blocked medium until it is
+// taken off the blocklist.
+func (as *ActionSuite) Test_Blocklist_Reject() {
+	as.withStorage(func(dir string) {
+		mod := as.createModerator()
+		raja := as.createUploader()
+
+		res, m := as.uploadMedium(raja, patternPNG(200, false))
+		as.Equal(http.StatusOK, res.Code)
+		as.Equal("image/png", m.Filetype)
+		as.Equal("a pattern", m.Caption)
+
+		arg := map[string]string{
+			"medium_id": m.ID.String(),
+			"reason":    "prohibited image",
+		}
+
+		res = as.authJSON(raja, "/api/1/moderation/blocklist").Post(arg)
+		as.Equal(http.StatusForbidden, res.Code)
+
+		res = as.authJSON(mod, "/api/1/moderation/blocklist").Post(arg)
+		as.Equal(http.StatusOK, res.Code)
+
+		b := &models.BlockedHash{}
+		as.NoError(json.Unmarshal(res.Body.Bytes(), b))
+
+		res = as.authJSON(mod, "/api/1/moderation/blocklist").Post(map[string]string{"hash": b.Hash.String()})
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+		// a rescaled copy matches, a different image does not
+		res, _ = as.uploadMedium(raja, patternPNG(120, false))
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+		as.Contains(res.Body.String(), "matches a blocked image")
+
+		res, _ = as.uploadMedium(raja, patternPNG(200, true))
+		as.Equal(http.StatusOK, res.Code)
+
+		res = as.authJSON(mod, "/api/1/moderation/blocklist/matches").Get()
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), models.AuditBlockedUpload)
+
+		res = as.authJSON(mod, "/api/1/moderation/blocklist/"+b.ID.String()).Delete()
+		as.Equal(http.StatusOK, res.Code)
+
+		res, _ = as.uploadMedium(raja, patternPNG(120, false))
+		as.Equal(http.StatusOK, res.Code)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM blocked_hashes")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Blocklist_Quarantine hides uploads like a blocked hash instead of
+// rejecting them when configured to.
+func (as *ActionSuite) Test_Blocklist_Quarantine() {
+	prev := models.HashMatchAction()
+	envy.Set("HASH_MATCH_ACTION", models.HashQuarantine)
+	defer envy.Set("HASH_MATCH_ACTION", prev)
+
+	as.withStorage(func(dir string) {
+		mod := as.createModerator()
+		raja := as.createUploader()
+
+		img, err := png.Decode(bytes.NewReader(patternPNG(200, false)))
+		as.NoError(err)
+
+		res := as.authJSON(mod, "/api/1/moderation/blocklist").Post(map[string]string{"hash": "not a hash"})
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+		res = as.authJSON(mod, "/api/1/moderation/blocklist").Post(map[string]string{"hash": models.PerceptualHash(img).String()})
+		as.Equal(http.StatusOK, res.Code)
+
+		res, m := as.uploadMedium(raja, patternPNG(160, false))
+		as.Equal(http.StatusOK, res.Code)
+		as.True(m.Hidden())
+
+		res = as.JSON("/api/1/media?id=" + m.ID.String()).Get()
+		as.Equal(http.StatusOK, res.Code)
+		as.Equal("[]", res.Body.String())
+
+		res = as.authJSON(mod, "/api/1/moderation/blocklist/matches").Get()
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), models.AuditQuarantinedUpload)
+		as.Contains(res.Body.String(), m.ID.String())
+
+		res = as.authJSON(raja, "/api/1/moderation/quarantine/"+m.ID.String()+"/release").Post(nil)
+		as.Equal(http.StatusForbidden, res.Code)
+
+		res = as.authJSON(mod, "/api/1/moderation/quarantine/"+m.ID.String()+"/release").Post(map[string]string{"reason": "false match"})
+		as.Equal(http.StatusOK, res.Code)
+
+		res = as.JSON("/api/1/media?id=" + m.ID.String()).Get()
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), m.ID.String())
+
+		res = as.authJSON(mod, "/api/1/moderation/quarantine/"+m.ID.String()+"/reject").Post(nil)
+		as.Equal(http.StatusConflict, res.Code)
+
+		res, m = as.uploadMedium(raja, patternPNG(180, false))
+		as.Equal(http.StatusOK, res.Code)
+		as.True(m.Hidden())
+
+		key, ok := storage.KeyFromURL(m.URI)
+		as.True(ok)
+
+		res = as.authJSON(mod, "/api/1/moderation/quarantine/"+m.ID.String()+"/reject").Post(nil)
+		as.Equal(http.StatusOK, res.Code)
+
+		_, err = models.GetAnyMediumByID(as.DB, m.ID)
+		as.Error(err)
+		_, err = os.Stat(filepath.Join(dir, key))
+		as.True(os.IsNotExist(err))
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM blocked_hashes")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Blocklist_External_Image quarantines images hosted elsewhere, which
+// cannot be screened, and refuses URIs that claim files on rmuse.
+func (as *ActionSuite) Test_Blocklist_External_Image() {
+	as.withStorage(func(dir string) {
+		as.createModerator()
+		raja := as.createUploader()
+
+		res := as.authJSON(raja, "/api/1/media").Post(map[string]string{"uri": "https://example.com/elsewhere.png", "type": "image/png"})
+		as.Equal(http.StatusOK, res.Code)
+
+		m := &models.Medium{}
+		as.NoError(json.Unmarshal(res.Body.Bytes(), m))
+		as.True(m.Hidden())
+
+		q, err := m.Quarantined(as.DB)
+		as.NoError(err)
+		as.True(q)
+
+		res = as.authJSON(raja, "/api/1/media").Post(map[string]string{"uri": "https://example.com/song.mp3", "type": "audio/mpeg"})
+		as.Equal(http.StatusOK, res.Code)
+		as.NotContains(res.Body.String(), `"hidden_at":"`)
+
+		res = as.authJSON(raja, "/api/1/media").Post(map[string]string{"uri": storage.URL("media/someone-else.png"), "type": "image/png"})
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
@@ -0,0 +1,384 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// commentReader returns who is reading comments, for deciding which media
+// they can see. It is nil for requests that are not logged in or whose
+// credentials cannot read comments.
+func commentReader(c buffalo.Context) *models.User {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || !hasScope(c, models.ScopeCommentsRead) {
+		return nil
+	}
+
+	return u
+}
+
+// commentWriter returns the current user if they may write comments.
+func commentWriter(c buffalo.Context) (*models.User, error) {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return nil, c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to comment"))
+	}
+
+	if err := requireScope(c, models.ScopeCommentsWrite); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// visibleMedium looks up the medium in the route, if u can see it and their
+// content preference does not hide it.
+func visibleMedium(c buffalo.Context, tx *pop.Connection, u *models.User) (*models.Medium, error) {
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetMediumByID(tx, id, u)
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	ok, err := contentVisible(tx, m, u)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !ok {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	return m, nil
+}
+
+// visibleComment looks up the comment in the route and its medium, if u can
+// see the medium, their content preference does not hide it and the comment's
+// author is neither deactivated nor suspended.
+func visibleComment(c buffalo.Context, tx *pop.Connection, u *models.User) (*models.Comment, *models.Medium, error) {
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("comment not found"))
+	}
+
+	cm, err := models.GetCommentByID(tx, id)
+	if err != nil {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("comment not found"))
+	}
+
+	if authorHidden(tx, cm) {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("comment not found"))
+	}
+
+	m, err := models.GetMediumByID(tx, cm.Medium, u)
+	if err != nil {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("comment not found"))
+	}
+
+	ok, err := contentVisible(tx, m, u)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if !ok {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("comment not found"))
+	}
+
+	return cm, m, nil
+}
+
+// authorHidden reports whether the author of cm is gone, deactivated or
+// suspended, so that the comment is not shown.
+func authorHidden(tx *pop.Connection, cm *models.Comment) bool {
+	author, err := models.GetUserByID(tx, cm.User)
+	return err != nil || author.Deactivated() || author.Suspended()
+}
+
+// contentVisible reports whether u's content preference, or the defaults if
+// u is nil, lets them see m at all.
+func contentVisible(tx *pop.Connection, m *models.Medium, u *models.User) (bool, error) {
+	p, err := models.GetContentPreference(tx, u)
+	if err != nil {
+		return false, err
+	}
+
+	return len(models.FilterMedia(models.Media{*m}, u, p)) > 0, nil
+}
+
+// renderComments renders a page of the comments on m, or the replies to
+// parent if it is not nil. The page starts after the "cursor" parameter and
+// holds up to "per_page" comments.
+func renderComments(c buffalo.Context, tx *pop.Connection, m *models.Medium, parent *models.Comment) error {
+	var cursor *models.Cursor
+	if s := c.Param("cursor"); s != "" {
+		var err error
+		if cursor, err = models.ParseCursor(s); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, err)
+		}
+	}
+
+	_, perPage := pageParams(c)
+	page, err := models.GetComments(tx, m, parent, cursor, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(page))
+}
+
+// MediumComments lists the comments on a medium, oldest first. Replies are
+// counted but listed by CommentReplies.
+func MediumComments(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := visibleMedium(c, tx, commentReader(c))
+	if err != nil {
+		return err
+	}
+
+	return renderComments(c, tx, m, nil)
+}
+
+// CommentReplies lists the replies to a comment, oldest first.
+func CommentReplies(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	cm, m, err := visibleComment(c, tx, commentReader(c))
+	if err != nil {
+		return err
+	}
+
+	return renderComments(c, tx, m, cm)
+}
+
+// notifyComment tells the owner of m about cm, and the author of parent if cm
+// is a reply. Users already told they were mentioned in cm are not told
+// again.
+func notifyComment(tx *pop.Connection, author *models.User, m *models.Medium, parent *models.Comment, cm *models.Comment, mentioned []*models.User) error {
+	told := map[uuid.UUID]bool{}
+	for _, u := range mentioned {
+		told[u.ID] = true
+	}
+
+	ids := []uuid.UUID{m.User}
+	if parent != nil {
+		ids = append(ids, parent.User)
+	}
+
+	for _, id := range ids {
+		if told[id] {
+			continue
+		}
+		told[id] = true
+
+		u, err := models.GetUserByID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		e := models.Event{Type: models.NotifyComment, Actor: author, Medium: m, Comment: cm}
+		if err := models.Notify(tx, u, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CommentCreate comments on a medium the current user can see, or replies to
+// one of its comments if parent_id is given. Users mentioned in it, the
+// medium's owner and the author of the comment replied to are told about it.
+func CommentCreate(c buffalo.Context) error {
+	u, err := commentWriter(c)
+	if err != nil {
+		return err
+	}
+
+	if restrictedUnverified(u, actionComment) {
+		return c.Error(http.StatusForbidden, fmt.Errorf("must verify email to comment"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := visibleMedium(c, tx, u)
+	if err != nil {
+		return err
+	}
+
+	if m.CommentsDisabled {
+		return c.Error(http.StatusForbidden, fmt.Errorf("comments are disabled on this medium"))
+	}
+
+	type argument struct {
+		Body   string `json:"body"`
+		Parent string `json:"parent_id"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	var parent *models.Comment
+	if arg.Parent != "" {
+		id, err := uuid.FromString(arg.Parent)
+		if err != nil {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("comment %s not found", arg.Parent))
+		}
+
+		if parent, err = models.GetCommentByID(tx, id); err != nil || authorHidden(tx, parent) {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("comment %s not found", arg.Parent))
+		}
+	}
+
+	cm := models.NewComment(u, m, parent, arg.Body)
+	verrs, err := cm.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	mentioned, err := cm.IndexMentions(tx, m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := notifyMentions(c, tx, u, m, cm, mentioned); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := notifyComment(tx, u, m, parent, cm, mentioned); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(cm))
+}
+
+// CommentUpdate edits one of the current user's comments. Users it newly
+// mentions are told about it.
+func CommentUpdate(c buffalo.Context) error {
+	u, err := commentWriter(c)
+	if err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	cm, m, err := visibleComment(c, tx, u)
+	if err != nil {
+		return err
+	}
+
+	if cm.User != u.ID {
+		return c.Error(http.StatusForbidden, fmt.Errorf("only the author can edit a comment"))
+	}
+
+	type argument struct {
+		Body string `json:"body"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	verrs, err := cm.Edit(tx, arg.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	mentioned, err := cm.IndexMentions(tx, m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := notifyMentions(c, tx, u, m, cm, mentioned); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(cm))
+}
+
+// CommentDelete deletes a comment, and any replies to it. Comments can be
+// deleted by their author or by the owner of the medium.
+func CommentDelete(c buffalo.Context) error {
+	u, err := commentWriter(c)
+	if err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	cm, m, err := visibleComment(c, tx, u)
+	if err != nil {
+		return err
+	}
+
+	if cm.User != u.ID && m.User != u.ID {
+		return c.Error(http.StatusForbidden, fmt.Errorf("only the author or the medium's owner can delete a comment"))
+	}
+
+	if err := cm.Delete(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(cm))
+}
+
+// setCommentsDisabled stops or allows new comments on one of the current
+// user's media.
+func setCommentsDisabled(c buffalo.Context, disabled bool) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to change media"))
+	}
+
+	if err := requireScope(c, models.ScopeMediaWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetAnyMediumByID(tx, id)
+	if err != nil || m.User != u.ID {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	if err := m.SetCommentsDisabled(tx, disabled); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(m))
+}
+
+// MediumDisableComments stops new comments on one of the current user's
+// media. Existing comments are kept.
+func MediumDisableComments(c buffalo.Context) error {
+	return setCommentsDisabled(c, true)
+}
+
+// MediumEnableComments allows comments on one of the current user's media
+// again.
+func MediumEnableComments(c buffalo.Context) error {
+	return setCommentsDisabled(c, false)
+}
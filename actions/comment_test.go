@@ -0,0 +1,204 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// comment posts a comment as u and returns it.
+func (as *ActionSuite) comment(u *models.User, m *models.Medium, arg map[string]string) *models.Comment {
+	res := as.authJSON(u, "/api/1/media/"+m.ID.String()+"/comments").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	cm := &models.Comment{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), cm))
+	return cm
+}
+
+// comments fetches a page of comments anonymously.
+func (as *ActionSuite) comments(path string) *models.CommentPage {
+	res := as.JSON(path).Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	page := &models.CommentPage{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), page))
+	return page
+}
+
+// Test_Comments_Thread comments and replies one level deep, pages through
+// comments by cursor, and edits and deletes them.
+func (as *ActionSuite) Test_Comments_Thread() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:        "https://example.com/song.mp3",
+		User:       oreo.ID,
+		Filetype:   "audio/mpeg",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(m))
+
+	path := "/api/1/media/" + m.ID.String() + "/comments"
+
+	res := as.authJSON(raja, path).Post(map[string]string{"body": "  "})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	first := as.comment(raja, m, map[string]string{"body": "lovely"})
+	as.Equal("raja", first.Username)
+
+	reply := as.comment(oreo, m, map[string]string{"body": "thank you", "parent_id": first.ID.String()})
+
+	// replies cannot be replied to
+	res = as.authJSON(raja, path).Post(map[string]string{"body": "you're welcome", "parent_id": reply.ID.String()})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	as.comment(raja, m, map[string]string{"body": "second"})
+	as.comment(raja, m, map[string]string{"body": "third"})
+
+	page := as.comments(path + "?per_page=2")
+	as.Len(page.Comments, 2)
+	as.Equal("lovely", page.Comments[0].Body)
+	as.Equal(1, page.Comments[0].ReplyCount)
+	as.NotEmpty(page.NextCursor)
+
+	page = as.comments(path + "?per_page=2&cursor=" + page.NextCursor)
+	as.Len(page.Comments, 1)
+	as.Equal("third", page.Comments[0].Body)
+	as.Empty(page.NextCursor)
+
+	res = as.JSON(path + "?cursor=nonsense").Get()
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	page = as.comments("/api/1/comments/" + first.ID.String() + "/replies")
+	as.Len(page.Comments, 1)
+	as.Equal("thank you", page.Comments[0].Body)
+
+	// only the author edits, but the medium's owner can delete
+	res = as.authJSON(oreo, "/api/1/comments/"+first.ID.String()).Put(map[string]string{"body": "meh"})
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(raja, "/api/1/comments/"+first.ID.String()).Put(map[string]string{"body": "lovely tune"})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "lovely tune")
+	as.NotContains(res.Body.String(), `"edited_at":null`)
+
+	res = as.authJSON(raja, "/api/1/comments/"+reply.ID.String()).Delete()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(oreo, "/api/1/comments/"+first.ID.String()).Delete()
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/comments/" + reply.ID.String() + "/replies").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	page = as.comments(path)
+	as.Len(page.Comments, 2)
+
+	// comments by suspended accounts cannot be fetched or replied to
+	second := page.Comments[0]
+	raja.SuspendedAt = nulls.NewTime(time.Now())
+	as.NoError(as.DB.Update(raja))
+
+	res = as.JSON("/api/1/comments/" + second.ID.String() + "/replies").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(oreo, path).Post(map[string]string{"body": "thanks", "parent_id": second.ID.String()})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	as.Empty(as.comments(path).Comments)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM comments")
+}
+
+// Test_Comments_Visibility ties comments to who can see the medium, and lets
+// its owner turn new comments off.
+func (as *ActionSuite) Test_Comments_Visibility() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:        "https://example.com/private.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "follower",
+	}
+	as.NoError(as.DB.Create(m))
+
+	path := "/api/1/media/" + m.ID.String() + "/comments"
+
+	res := as.JSON(path).Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(raja, path).Post(map[string]string{"body": "hello"})
+	as.Equal(http.StatusNotFound, res.Code)
+
+	as.NoError(as.DB.Create(&models.Follow{Follower: raja.ID, Followed: oreo.ID}))
+
+	as.comment(raja, m, map[string]string{"body": "hello"})
+
+	res = as.authJSON(raja, path+"/disable").Post(nil)
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(oreo, path+"/disable").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"comments_disabled":true`)
+
+	res = as.authJSON(raja, path).Post(map[string]string{"body": "hello again"})
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(raja, path).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "hello")
+
+	res = as.authJSON(oreo, path+"/enable").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	as.comment(raja, m, map[string]string{"body": "hello again"})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM follows")
+	as.DB.RawQuery("DELETE FROM comments")
+}
+
+// Test_Comments_Content_Preference hides the comments on media the viewer's
+// content preference hides.
+func (as *ActionSuite) Test_Comments_Content_Preference() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:             "https://example.com/nude.png",
+		User:            oreo.ID,
+		Filetype:        "image/png",
+		Permission:      "public",
+		ContentWarnings: slices.String{models.WarningNudity},
+	}
+	as.NoError(as.DB.Create(m))
+
+	c := as.comment(oreo, m, map[string]string{"body": "hello"})
+
+	path := "/api/1/media/" + m.ID.String() + "/comments"
+
+	res := as.JSON(path).Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(raja, path).Post(map[string]string{"body": "hello"})
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(raja, "/api/1/comments/"+c.ID.String()+"/replies").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM comments")
+}
@@ -0,0 +1,45 @@
+package actions
+
+import (
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+)
+
+// AfterCommit runs the functions handlers queue with onCommit once the
+// request's transaction has committed, and drops them if it was rolled back.
+// Requests that end in an error status are treated as rolled back. It must
+// wrap the transaction middleware.
+func AfterCommit(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		fns := []func(){}
+		c.Set("after_commit", &fns)
+
+		if err := next(c); err != nil {
+			return err
+		}
+
+		if res, ok := c.Response().(*buffalo.Response); ok && res.Status >= http.StatusBadRequest {
+			return nil
+		}
+
+		for _, fn := range fns {
+			fn()
+		}
+
+		return nil
+	}
+}
+
+// onCommit queues fn to run once the request's transaction has committed, for
+// work such as deleting files or sending mail that cannot be rolled back.
+// Outside a request it runs fn straight away.
+func onCommit(c buffalo.Context, fn func()) {
+	fns, ok := c.Value("after_commit").(*[]func())
+	if !ok {
+		fn()
+		return
+	}
+
+	*fns = append(*fns, fn)
+}
@@ -0,0 +1,216 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/slices"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// contentViewer returns who is viewing media, for filtering by content
+// warning, and their content preference. The viewer is nil for requests that
+// are not logged in or whose credentials cannot read media.
+func contentViewer(c buffalo.Context, tx *pop.Connection) (*models.User, *models.ContentPreference, error) {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || !hasScope(c, models.ScopeMediaRead) {
+		u = nil
+	}
+
+	p, err := models.GetContentPreference(tx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, p, nil
+}
+
+// renderContentSettings renders u's content preference and whether they
+// have confirmed their age.
+func renderContentSettings(c buffalo.Context, u *models.User, p *models.ContentPreference) error {
+	res := struct {
+		*models.ContentPreference
+		AgeConfirmed bool `json:"age_confirmed"`
+	}{
+		ContentPreference: p,
+		AgeConfirmed:      u.AgeConfirmed(),
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// UserContentRead shows how the current user wants media with content
+// warnings shown.
+func UserContentRead(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to read content settings"))
+	}
+
+	if err := requireScope(c, models.ScopeProfileRead); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	p, err := models.GetContentPreference(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderContentSettings(c, u, p)
+}
+
+// UserContentUpdate changes how the current user wants media with each
+// content warning shown: show, blur or hide. Warnings left out of the body
+// are unchanged.
+func UserContentUpdate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to change content settings"))
+	}
+
+	if err := requireScope(c, models.ScopeProfileWrite); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Nudity         *string `json:"nudity"`
+		Violence       *string `json:"violence"`
+		FlashingLights *string `json:"flashing_lights"`
+		Custom         *string `json:"custom"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	p, err := models.GetContentPreference(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if arg.Nudity != nil {
+		p.Nudity = *arg.Nudity
+	}
+	if arg.Violence != nil {
+		p.Violence = *arg.Violence
+	}
+	if arg.FlashingLights != nil {
+		p.FlashingLights = *arg.FlashingLights
+	}
+	if arg.Custom != nil {
+		p.Custom = *arg.Custom
+	}
+
+	verrs, err := p.Save(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return renderContentSettings(c, u, p)
+}
+
+// userConfirmAge sets or withdraws the current user's confirmation that they
+// are old enough to see mature media. Only the user themselves can do either.
+func userConfirmAge(c buffalo.Context, confirmed bool) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to confirm age"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := u.ConfirmAge(tx, confirmed); err != nil {
+		return errors.WithStack(err)
+	}
+
+	p, err := models.GetContentPreference(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderContentSettings(c, u, p)
+}
+
+// UserConfirmAge confirms that the current user is old enough to see media
+// with mature content warnings, which are otherwise always hidden from them.
+func UserConfirmAge(c buffalo.Context) error {
+	return userConfirmAge(c, true)
+}
+
+// UserWithdrawAge withdraws the current user's age confirmation.
+func UserWithdrawAge(c buffalo.Context) error {
+	return userConfirmAge(c, false)
+}
+
+// MediumSetWarnings replaces the content warnings on one of the current
+// user's media.
+func MediumSetWarnings(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to change media"))
+	}
+
+	if err := requireScope(c, models.ScopeMediaWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetAnyMediumByID(tx, id)
+	if err != nil || m.User != u.ID {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	type argument struct {
+		ContentWarnings    []string `json:"content_warnings"`
+		ContentWarningText string   `json:"content_warning_text"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	m.ContentWarnings = slices.String{}
+	if arg.ContentWarnings != nil {
+		m.ContentWarnings = slices.String(arg.ContentWarnings)
+	}
+	m.ContentWarningText = strings.TrimSpace(arg.ContentWarningText)
+
+	verrs, err := tx.ValidateAndUpdate(m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(m))
+}
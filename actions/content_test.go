@@ -0,0 +1,147 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/markbates/pop/slices"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// viewMedia fetches media by id as u, or anonymously if u is nil, and returns
+// whether each was blurred, by URI.
+func (as *ActionSuite) viewMedia(u *models.User, media ...*models.Medium) map[string]bool {
+	path := "/api/1/media?"
+	for _, m := range media {
+		path += "id=" + m.ID.String() + "&"
+	}
+
+	req := as.JSON(path)
+	if u != nil {
+		req = as.authJSON(u, path)
+	}
+
+	res := req.Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	seen := models.Media{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &seen))
+
+	blurred := map[string]bool{}
+	for _, m := range seen {
+		blurred[m.URI] = m.Blurred
+	}
+
+	return blurred
+}
+
+// Test_Content_Warnings filters media by the viewer's content preferences and
+// age confirmation.
+func (as *ActionSuite) Test_Content_Warnings() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	plain := &models.Medium{
+		URI:        "plain.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	nude := &models.Medium{
+		URI:             "nude.png",
+		User:            oreo.ID,
+		Filetype:        "image/png",
+		Permission:      "public",
+		ContentWarnings: slices.String{models.WarningNudity},
+	}
+	flashing := &models.Medium{
+		URI:             "flashing.gif",
+		User:            oreo.ID,
+		Filetype:        "image/gif",
+		Permission:      "public",
+		ContentWarnings: slices.String{models.WarningFlashingLights},
+	}
+	for _, m := range []*models.Medium{plain, nude, flashing} {
+		verrs, err := m.Create(as.DB)
+		as.NoError(err)
+		as.False(verrs.HasAny())
+	}
+
+	// mature media are hidden until the viewer confirms their age
+	as.Equal(map[string]bool{"plain.png": false, "flashing.gif": true}, as.viewMedia(nil, plain, nude, flashing))
+	as.Equal(map[string]bool{"plain.png": false, "flashing.gif": true}, as.viewMedia(raja, plain, nude, flashing))
+
+	res := as.authJSON(raja, "/api/1/user/age").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"age_confirmed":true`)
+
+	as.Equal(map[string]bool{"plain.png": false, "nude.png": true, "flashing.gif": true}, as.viewMedia(raja, plain, nude, flashing))
+
+	res = as.authJSON(raja, "/api/1/user/content").Put(map[string]string{"nudity": "unblur"})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(raja, "/api/1/user/content").Put(map[string]string{"nudity": models.ContentShow, "flashing_lights": models.ContentHide})
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(raja, "/api/1/user/content").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"violence":"blur"`)
+
+	as.Equal(map[string]bool{"plain.png": false, "nude.png": false}, as.viewMedia(raja, plain, nude, flashing))
+
+	res = as.authJSON(raja, "/api/1/user/oreo").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "nude.png")
+	as.NotContains(res.Body.String(), "flashing.gif")
+
+	// owners always see their own media as they are
+	as.Equal(map[string]bool{"plain.png": false, "nude.png": false, "flashing.gif": false}, as.viewMedia(oreo, plain, nude, flashing))
+
+	res = as.authJSON(raja, "/api/1/user/age").Delete()
+	as.Equal(http.StatusOK, res.Code)
+
+	as.Equal(map[string]bool{"plain.png": false}, as.viewMedia(raja, plain, nude, flashing))
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM content_preferences")
+}
+
+// Test_Content_Warnings_Set lets only a medium's owner change its warnings.
+func (as *ActionSuite) Test_Content_Warnings_Set() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:        "battle.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	verrs, err := m.Create(as.DB)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+
+	path := "/api/1/media/" + m.ID.String() + "/warnings"
+	arg := map[string]interface{}{
+		"content_warnings":     []string{models.WarningViolence},
+		"content_warning_text": "depicts a battle",
+	}
+
+	res := as.authJSON(raja, path).Put(arg)
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(oreo, path).Put(map[string]interface{}{"content_warnings": []string{"gore"}})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(oreo, path).Put(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	m, err = models.GetAnyMediumByID(as.DB, m.ID)
+	as.NoError(err)
+	as.Equal([]string{models.WarningViolence, models.WarningCustom}, m.Warnings())
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
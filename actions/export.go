@@ -0,0 +1,174 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// exportAccountJob is the name of the background job that builds an export.
+const exportAccountJob = "export_account"
+
+// exportAccount builds the export with the "export_id" in args and emails
+// its owner a link to download it.
+func exportAccount(args worker.Args) error {
+	id, err := uuid.FromString(fmt.Sprint(args["export_id"]))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	e, err := models.GetExportByID(models.DB, id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if e.Status != models.ExportPending {
+		return nil
+	}
+
+	u, err := models.GetUserByID(models.DB, e.User)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := buildExport(e, u); err != nil {
+		e.Status = models.ExportFailed
+		models.DB.Update(e)
+		return errors.WithStack(err)
+	}
+
+	e.Status = models.ExportReady
+	e.ExpiresAt = nulls.NewTime(time.Now().Add(models.ExportTTL()))
+	if err := models.DB.Update(e); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ts, err := e.CreateDownloadToken()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return mailers.SendExportReady(u, ts, e.ExpiresAt.Time)
+}
+
+// buildExport writes u's archive to a scratch file and stores it.
+func buildExport(e *models.Export, u *models.User) error {
+	f, err := ioutil.TempFile("", "rmuse-export")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := models.WriteExport(models.DB, u, f); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return storage.Put(e.Key(), f)
+}
+
+// UserExportCreate starts building an archive of the current user's data.
+// They are emailed a link to download it when it is ready.
+func UserExportCreate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to export data"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	pending, err := models.HasPendingExport(models.DB, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if pending {
+		return c.Error(http.StatusConflict, fmt.Errorf("an export is already in progress"))
+	}
+
+	// The export is written outside the request's transaction so that the
+	// job, which runs straight away, can see it.
+	e := &models.Export{
+		User:   u.ID,
+		Status: models.ExportPending,
+	}
+	if err := models.DB.Create(e); err != nil {
+		return errors.WithStack(err)
+	}
+
+	job := worker.Job{
+		Queue:   "default",
+		Handler: exportAccountJob,
+		Args:    worker.Args{"export_id": e.ID.String()},
+	}
+	if err := app.Worker.Perform(job); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusAccepted, r.JSON(e))
+}
+
+// UserExportList lists the current user's exports.
+func UserExportList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list exports"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	e, err := models.GetExportsByUser(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(e))
+}
+
+// ExportDownload sends the archive named by the token in an export email.
+func ExportDownload(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	e, err := models.GetExportByDownloadToken(tx, c.Param("token"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("export not found or expired"))
+	}
+
+	f, err := storage.Open(e.Key())
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("export not found or expired"))
+	}
+	defer f.Close()
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "application/zip")
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"rmuse-export-%s.zip\"", e.CreatedAt.Format("2006-01-02")))
+	res.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(res, f)
+	return err
+}
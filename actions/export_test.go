@@ -0,0 +1,159 @@
+package actions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markbates/pop/nulls"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// Test_Export builds an archive in the background and downloads it with the
+// emailed token.
+func (as *ActionSuite) Test_Export() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		as.NoError(storage.Put("media/sketch.png", bytes.NewReader(testPNG(10, 10))))
+		m := &models.Medium{
+			URI:        storage.URL("media/sketch.png"),
+			StorageKey: "media/sketch.png",
+			User:       u.ID,
+			Filetype:   "image/png",
+			Permission: "public",
+			PosX:       2,
+			PosY:       3,
+		}
+		as.NoError(as.DB.Create(m))
+
+		req := as.JSON("/api/1/user/export")
+		ts, err := u.CreateJWTToken()
+		as.NoError(err)
+		req.Headers["Authorization"] = ts
+		res := req.Post(nil)
+		as.Equal(http.StatusAccepted, res.Code)
+
+		e := &models.Export{}
+		as.NoError(json.Unmarshal(res.Body.Bytes(), e))
+
+		for i := 0; i < 50; i++ {
+			e, err = models.GetExportByID(as.DB, e.ID)
+			as.NoError(err)
+			if e.Status != models.ExportPending {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		as.Equal(models.ExportReady, e.Status)
+
+		// the archive is kept out of the served directory
+		_, err = os.Stat(filepath.Join(dir, "exports", e.ID.String()+".zip"))
+		as.True(os.IsNotExist(err))
+
+		dl, err := e.CreateDownloadToken()
+		as.NoError(err)
+
+		res = as.JSON("/api/1/export/download?token=" + url.QueryEscape(dl)).Get()
+		as.Equal(http.StatusOK, res.Code)
+		as.Equal("application/zip", res.Header().Get("Content-Type"))
+
+		z, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+		as.NoError(err)
+
+		files := map[string][]byte{}
+		for _, f := range z.File {
+			r, err := f.Open()
+			as.NoError(err)
+			files[f.Name], err = ioutil.ReadAll(r)
+			as.NoError(err)
+			r.Close()
+		}
+
+		as.Contains(string(files["profile.json"]), "oreo")
+		as.Contains(files, "followers.json")
+		as.Contains(files, "following.json")
+
+		media := []models.ExportedMedium{}
+		as.NoError(json.Unmarshal(files["media.json"], &media))
+		as.Len(media, 1)
+		as.Equal(3, media[0].PosY)
+		as.Equal(testPNG(10, 10), files[media[0].File])
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM exports")
+}
+
+// Test_Export_Expired refuses to download an export past its expiry.
+func (as *ActionSuite) Test_Export_Expired() {
+	u := as.createOreo()
+
+	e := &models.Export{
+		User:      u.ID,
+		Status:    models.ExportReady,
+		ExpiresAt: nulls.NewTime(time.Now().Add(time.Hour)),
+	}
+	as.NoError(as.DB.Create(e))
+
+	dl, err := e.CreateDownloadToken()
+	as.NoError(err)
+
+	e.ExpiresAt = nulls.NewTime(time.Now().Add(-time.Minute))
+	as.NoError(as.DB.Update(e))
+
+	res := as.JSON("/api/1/export/download?token=" + url.QueryEscape(dl)).Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM exports")
+}
+
+// Test_Export_In_Progress refuses to start a second export while one is
+// being built.
+func (as *ActionSuite) Test_Export_In_Progress() {
+	u := as.createOreo()
+
+	as.NoError(as.DB.Create(&models.Export{User: u.ID, Status: models.ExportPending}))
+
+	req := as.JSON("/api/1/user/export")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Post(nil)
+	as.Equal(http.StatusConflict, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM exports")
+}
+
+// Test_Export_Stale gives up on an export whose job was lost, so that
+// another can be started.
+func (as *ActionSuite) Test_Export_Stale() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		e := &models.Export{User: u.ID, Status: models.ExportPending}
+		as.NoError(as.DB.Create(e))
+		as.NoError(as.DB.RawQuery("UPDATE exports SET created_at = ? WHERE id = ?", time.Now().Add(-models.ExportTimeout()-time.Minute), e.ID).Exec())
+
+		res := as.authJSON(u, "/api/1/user/export").Post(nil)
+		as.Equal(http.StatusAccepted, res.Code)
+
+		e, err := models.GetExportByID(as.DB, e.ID)
+		as.NoError(err)
+		as.Equal(models.ExportFailed, e.Status)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM exports")
+}
@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+)
+
+// maxImportUpload is the largest archive that can be uploaded for import, in
+// bytes.
+const maxImportUpload = 1 << 30
+
+// MediaImport creates media for the current user from an uploaded ZIP
+// archive, sent as the "archive" field of a multipart form. An optional
+// "manifest" field gives captions, permissions and grid positions; without
+// one, the archive's own manifest.json or media.json is used. The response
+// reports how each item fared.
+func MediaImport(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to import media"))
+	}
+
+	if err := requireScope(c, models.ScopeMediaWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	can, err := u.HasPermission(tx, models.PermissionMediaUpload)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !can {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be artist to upload media"))
+	}
+
+	if restrictedUnverified(u, actionUpload) {
+		return c.Error(http.StatusForbidden, fmt.Errorf("must verify email to upload media"))
+	}
+
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxImportUpload)
+
+	f, fh, err := req.FormFile("archive")
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("missing archive file"))
+	}
+	defer f.Close()
+
+	z, err := zip.NewReader(f, fh.Size)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("archive is not a zip file"))
+	}
+
+	var manifest []models.ImportItem
+	if mf, _, err := req.FormFile("manifest"); err == nil {
+		defer mf.Close()
+		if manifest, err = models.ParseManifest(mf); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, err)
+		}
+	} else if m := req.FormValue("manifest"); m != "" {
+		if manifest, err = models.ParseManifest(strings.NewReader(m)); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, err)
+		}
+	}
+
+	// Each batch commits on its own, so that a large import is not lost to
+	// one bad batch.
+	report, err := models.ImportMedia(models.DB, u, z, manifest)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	for _, res := range report.Results {
+		for _, mu := range res.Mentioned {
+			if err := mailers.SendMention(mu, u, res.Medium, nil); err != nil {
+				c.Logger().Errorf("could not send mention to %s: %v", mu.Username, err)
+			}
+		}
+	}
+
+	if report.Imported == 0 && report.Failed > 0 {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(report))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(report))
+}
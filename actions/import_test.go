@@ -0,0 +1,160 @@
+package actions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gobuffalo/envy"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func testArchive(files map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	z := zip.NewWriter(buf)
+	for name, data := range files {
+		f, _ := z.Create(name)
+		f.Write(data)
+	}
+	z.Close()
+	return buf.Bytes()
+}
+
+func (as *ActionSuite) importArchive(u *models.User, archive []byte, manifest string) (*httptest.ResponseRecorder, *models.ImportReport) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("archive", "portfolio.zip")
+	as.NoError(err)
+	fw.Write(archive)
+	if manifest != "" {
+		as.NoError(mw.WriteField("manifest", manifest))
+	}
+	as.NoError(mw.Close())
+
+	req, err := http.NewRequest("POST", "/api/1/media/import", body)
+	as.NoError(err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Header.Set("Authorization", ts)
+
+	res := httptest.NewRecorder()
+	as.App.ServeHTTP(res, req)
+
+	report := &models.ImportReport{}
+	json.Unmarshal(res.Body.Bytes(), report)
+	return res, report
+}
+
+// Test_Media_Import creates media from an archive and its manifest, and
+// reports the items that could not be imported.
+func (as *ActionSuite) Test_Media_Import() {
+	as.withStorage(func(dir string) {
+		u := as.createVerifiedOreo()
+
+		archive := testArchive(map[string][]byte{
+			"sunrise.png": testPNG(10, 10),
+			"sunset.png":  testPNG(20, 10),
+			"notes.txt":   []byte("not a picture"),
+			"manifest.json": []byte(`[
+				{"file": "sunrise.png", "caption": "Morning", "permission": "follower", "col": 1, "row": 2},
+				{"file": "sunset.png"},
+				{"file": "notes.txt"},
+				{"file": "missing.png"}
+			]`),
+		})
+
+		res, report := as.importArchive(u, archive, "")
+		as.Equal(http.StatusOK, res.Code)
+		as.Equal(2, report.Imported)
+		as.Equal(2, report.Failed)
+		as.Contains(report.Results[2].Error, "unsupported file type")
+		as.Equal("file not found in archive", report.Results[3].Error)
+
+		m, err := models.GetMediumByID(as.DB, report.Results[0].Medium.ID, u)
+		as.NoError(err)
+		as.Equal("Morning", m.Caption)
+		as.Equal("follower", m.Permission)
+		as.Equal("image/png", m.Filetype)
+		as.Equal(1, m.PosX)
+		as.Equal(2, m.PosY)
+
+		count, err := as.DB.Count(&models.Medium{})
+		as.NoError(err)
+		as.Equal(2, count)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
+
+// Test_Media_Import_Batches commits each batch on its own.
+func (as *ActionSuite) Test_Media_Import_Batches() {
+	as.withStorage(func(dir string) {
+		u := as.createVerifiedOreo()
+
+		prev := envy.Get("IMPORT_BATCH_SIZE", "50")
+		envy.Set("IMPORT_BATCH_SIZE", "2")
+		defer envy.Set("IMPORT_BATCH_SIZE", prev)
+
+		files := map[string][]byte{}
+		for _, name := range []string{"a.png", "b.png", "c.png", "d.png", "e.png"} {
+			files[name] = testPNG(5, 5)
+		}
+
+		res, report := as.importArchive(u, testArchive(files), "")
+		as.Equal(http.StatusOK, res.Code)
+		as.Equal(5, report.Imported)
+		as.Equal("a.png", report.Results[0].File)
+		as.Equal("e.png", report.Results[4].File)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
+
+// Test_Media_Import_Manifest_Field takes the manifest from the form rather
+// than the archive, and notifies the users its captions mention.
+func (as *ActionSuite) Test_Media_Import_Manifest_Field() {
+	as.withStorage(func(dir string) {
+		u := as.createVerifiedOreo()
+		raja := as.createRaja()
+
+		archive := testArchive(map[string][]byte{"sunrise.png": testPNG(10, 10)})
+		manifest := `[{"file": "sunrise.png", "caption": "Morning with @raja"}]`
+
+		res, report := as.importArchive(u, archive, manifest)
+		as.Equal(http.StatusOK, res.Code)
+		as.Equal(1, report.Imported)
+		as.Equal("Morning with @raja", report.Results[0].Medium.Caption)
+
+		l := as.notifications(raja, "/api/1/notifications")
+		as.Len(l.Notifications, 1)
+		as.Equal(models.NotifyMention, l.Notifications[0].Type)
+		as.Equal([]string{"oreo"}, l.Notifications[0].Actors)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM media_tags")
+	as.DB.RawQuery("DELETE FROM mentions")
+	as.DB.RawQuery("DELETE FROM notifications")
+}
+
+// Test_Media_Import_Not_Zip refuses an upload that is not an archive.
+func (as *ActionSuite) Test_Media_Import_Not_Zip() {
+	as.withStorage(func(dir string) {
+		u := as.createVerifiedOreo()
+
+		res, _ := as.importArchive(u, []byte("not an archive"), "")
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+		as.Contains(res.Body.String(), "archive is not a zip file")
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+}
@@ -0,0 +1,156 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// countLikes fills in the like counts of media, and which of them the
+// current user likes if their credentials can read likes.
+func countLikes(c buffalo.Context, tx *pop.Connection, media models.Media) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || !hasScope(c, models.ScopeLikesRead) {
+		u = nil
+	}
+
+	return models.CountLikes(tx, media, u)
+}
+
+// likeMedium likes or unlikes the medium in the route as the current user.
+// Either can be repeated without effect. The owner is notified the first time
+// a user likes the medium, not when they like it again after unliking it.
+func likeMedium(c buffalo.Context, like bool) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to like media"))
+	}
+
+	if err := requireScope(c, models.ScopeLikesWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetMediumByID(tx, id, u)
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	if !like {
+		if err := u.Unlike(tx, m); err != nil {
+			return errors.WithStack(err)
+		}
+	} else if liked, err := u.Like(tx, m); err != nil {
+		return errors.WithStack(err)
+	} else if liked {
+		first, err := u.FirstLike(tx, m)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if first {
+			owner, err := models.GetUserByID(tx, m.User)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if err := models.Notify(tx, owner, models.Event{Type: models.NotifyLike, Actor: u, Medium: m}); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	media := models.Media{*m}
+	if err := countLikes(c, tx, media); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(media[0]))
+}
+
+// MediumLike likes a medium the current user can see.
+func MediumLike(c buffalo.Context) error {
+	return likeMedium(c, true)
+}
+
+// MediumUnlike withdraws the current user's like of a medium.
+func MediumUnlike(c buffalo.Context) error {
+	return likeMedium(c, false)
+}
+
+// MediumLikers lists who likes one of the current user's media, most recent
+// first.
+func MediumLikers(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to see likes"))
+	}
+
+	if err := requireScope(c, models.ScopeLikesRead); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	m, err := models.GetAnyMediumByID(tx, id)
+	if err != nil || m.User != u.ID {
+		return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+	}
+
+	page, perPage := pageParams(c)
+	likers, err := models.GetLikers(tx, m, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(likers))
+}
+
+// UserLikes lists the media a user likes, most recently liked first, leaving
+// out those the current user may not see.
+func UserLikes(c buffalo.Context) error {
+	username := c.Param("username")
+	tx := c.Value("tx").(*pop.Connection)
+
+	lu, err := models.GetUserByUsername(tx, username)
+	if err != nil || lu.Deactivated() || lu.Suspended() {
+		return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", username))
+	}
+
+	viewer, cp, err := contentViewer(c, tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	page, perPage := pageParams(c)
+	media, err := models.GetLikedMedia(tx, lu, viewer, cp, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := countLikes(c, tx, media); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(media))
+}
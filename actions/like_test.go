@@ -0,0 +1,115 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/markbates/pop/nulls"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// Test_Like likes and unlikes media, and lists likes as each viewer may see
+// them.
+func (as *ActionSuite) Test_Like() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	public := &models.Medium{
+		URI:        "https://example.com/public.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(public))
+
+	private := &models.Medium{
+		URI:        "https://example.com/private.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "follower",
+	}
+	as.NoError(as.DB.Create(private))
+
+	// liking is idempotent
+	for i := 0; i < 2; i++ {
+		res := as.authJSON(raja, "/api/1/media/"+public.ID.String()+"/like").Post(nil)
+		as.Equal(http.StatusOK, res.Code)
+
+		m := &models.Medium{}
+		as.NoError(json.Unmarshal(res.Body.Bytes(), m))
+		as.Equal(1, m.LikeCount)
+		as.True(m.LikedByMe)
+	}
+
+	res := as.authJSON(raja, "/api/1/media/"+private.ID.String()+"/like").Post(nil)
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.JSON("/api/1/media?id=" + public.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"like_count":1`)
+	as.Contains(res.Body.String(), `"liked_by_me":false`)
+
+	// only the owner sees who liked a medium
+	res = as.authJSON(raja, "/api/1/media/"+public.ID.String()+"/likes").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(oreo, "/api/1/media/"+public.ID.String()+"/likes").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"username":"raja"`)
+
+	as.NoError(as.DB.Create(&models.Follow{Follower: raja.ID, Followed: oreo.ID}))
+
+	res = as.authJSON(raja, "/api/1/media/"+private.ID.String()+"/like").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/user/raja/likes").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "public.png")
+	as.NotContains(res.Body.String(), "private.png")
+
+	res = as.authJSON(oreo, "/api/1/user/raja/likes").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "private.png")
+
+	media := models.Media{*public, *private}
+	as.NoError(models.CountLikes(as.DB, media, oreo))
+	for _, m := range media {
+		as.Equal(1, m.LikeCount)
+		as.False(m.LikedByMe)
+	}
+
+	as.NoError(models.CountLikes(as.DB, media, raja))
+	for _, m := range media {
+		as.True(m.LikedByMe)
+	}
+
+	// likes by deactivated accounts are neither listed nor counted
+	raja.DeactivatedAt = nulls.NewTime(time.Now())
+	as.NoError(as.DB.Update(raja))
+
+	res = as.authJSON(oreo, "/api/1/media/"+public.ID.String()+"/likes").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("[]", res.Body.String())
+
+	as.NoError(models.CountLikes(as.DB, media, oreo))
+	for _, m := range media {
+		as.Equal(0, m.LikeCount)
+	}
+
+	raja.DeactivatedAt = nulls.Time{}
+	as.NoError(as.DB.Update(raja))
+
+	for i := 0; i < 2; i++ {
+		res = as.authJSON(raja, "/api/1/media/"+public.ID.String()+"/like").Delete()
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), `"like_count":0`)
+	}
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM follows")
+	as.DB.RawQuery("DELETE FROM likes")
+	as.DB.RawQuery("DELETE FROM like_notices")
+}
@@ -1,26 +1,35 @@
 package actions
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gobuffalo/buffalo"
 	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 
 	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
 )
 
 // MediaGet default implementation.
 func MediaGet(c buffalo.Context) error {
 	u, ok := c.Value("user").(*models.User)
 
-	if !ok {
+	if !ok || !hasScope(c, models.ScopeMediaRead) {
 		u = nil
 	}
 
-	media := make([]*models.Medium, 0)
+	media := models.Media{}
 	tx := c.Value("tx").(*pop.Connection)
 
 	if p, ok := c.Params().(url.Values)["id"]; ok {
@@ -29,17 +38,87 @@ func MediaGet(c buffalo.Context) error {
 			if err == nil {
 				m, err := models.GetMediumByID(tx, uuid, u)
 				if err == nil {
-					media = append(media, m)
+					media = append(media, *m)
 				}
 			}
 		}
+
+		cp, err := models.GetContentPreference(tx, u)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		media = models.FilterMedia(media, u, cp)
+		if err := countLikes(c, tx, media); err != nil {
+			return errors.WithStack(err)
+		}
+
 		return c.Render(http.StatusOK, r.JSON(media))
 	}
 
 	return c.Render(http.StatusInternalServerError, nil)
 }
 
-// MediaUpload default implementation.
+// maxMediumUpload is the largest file that can be uploaded as a medium, in
+// bytes.
+const maxMediumUpload = 100 << 20
+
+// storeMediumUpload stores the file uploaded as the "file" field of a
+// multipart form and points m at it. An optional "medium" field holds the
+// rest of the medium as JSON. It returns the key the file was stored under.
+func storeMediumUpload(c buffalo.Context, m *models.Medium) (string, error) {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxMediumUpload)
+
+	f, fh, err := req.FormFile("file")
+	if err != nil {
+		return "", c.Error(http.StatusUnprocessableEntity, fmt.Errorf("missing media file"))
+	}
+	defer f.Close()
+
+	if fields := req.FormValue("medium"); fields != "" {
+		if err := json.Unmarshal([]byte(fields), m); err != nil {
+			return "", c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+		}
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", c.Error(http.StatusUnprocessableEntity, fmt.Errorf("could not read media file"))
+	}
+
+	filetype := http.DetectContentType(data)
+	if i := strings.Index(filetype, ";"); i >= 0 {
+		filetype = filetype[:i]
+	}
+
+	if !strings.HasPrefix(filetype, "image/") && !strings.HasPrefix(filetype, "audio/") && !strings.HasPrefix(filetype, "video/") {
+		return "", c.Error(http.StatusUnprocessableEntity, fmt.Errorf("unsupported file type %s", filetype))
+	}
+
+	key, err := models.NewMediumKey(fh.Filename)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if err := storage.Put(key, bytes.NewReader(data)); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	m.URI = storage.URL(key)
+	m.StorageKey = key
+	m.Filetype = filetype
+
+	return key, nil
+}
+
+// MediaUpload creates a medium for the current user. The file is either
+// uploaded as the "file" field of a multipart form, or already hosted at the
+// URI given in a JSON body. Images stored on rmuse are checked against the
+// blocklist; depending on HASH_MATCH_ACTION, one that matches a blocked
+// image is either rejected or quarantined, hidden until a moderator looks at
+// it. Images hosted elsewhere cannot be checked and are always quarantined.
+// Hashtags in the caption are indexed, and users it mentions are told.
 func MediaUpload(c buffalo.Context) error {
 	u, ok := c.Value("user").(*models.User)
 
@@ -47,23 +126,78 @@ func MediaUpload(c buffalo.Context) error {
 		return c.Render(http.StatusUnauthorized, r.JSON("must be logged in to upload files"))
 	}
 
-	if !u.Artist {
+	if err := requireScope(c, models.ScopeMediaWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	can, err := u.HasPermission(tx, models.PermissionMediaUpload)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !can {
 		return c.Render(http.StatusUnauthorized, r.JSON("must be artist to upload media"))
 	}
 
+	if restrictedUnverified(u, actionUpload) {
+		return c.Error(http.StatusForbidden, fmt.Errorf("must verify email to upload media"))
+	}
+
 	m := &models.Medium{}
+	key := ""
 
-	if err := c.Bind(m); err != nil {
+	if mt, _, _ := mime.ParseMediaType(c.Request().Header.Get("Content-Type")); mt == "multipart/form-data" {
+		if key, err = storeMediumUpload(c, m); err != nil {
+			return err
+		}
+	} else if err := c.Bind(m); err != nil {
 		return c.Error(http.StatusInternalServerError, fmt.Errorf("unable to bind medium %v", err))
 	}
 
+	// files on rmuse are only ever claimed by the upload that stored them
+	if _, ok := storage.KeyFromURL(m.URI); ok && key == "" {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("uri must not point into rmuse storage; upload the file instead"))
+	}
+
+	// a failed upload leaves nothing behind in storage
+	created := false
+	defer func() {
+		if key != "" && !created {
+			storage.Delete(key)
+		}
+	}()
+
 	m.User = u.ID
+	m.StorageKey = key
+	m.HiddenAt = nulls.Time{}
+	m.PerceptualHash = nulls.Int64{}
 
 	if m.Permission == "" {
 		m.Permission = "public"
 	}
 
-	tx := c.Value("tx").(*pop.Connection)
+	match, err := m.Screen(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if match != nil {
+		if models.HashMatchAction() == models.HashReject {
+			// recorded outside the request transaction, which is rolled back
+			if err := match.Record(models.DB, u, nil); err != nil {
+				c.Logger().Errorf("could not audit blocked upload: %v", err)
+			}
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("upload matches a blocked image"))
+		}
+		m.HiddenAt = nulls.NewTime(time.Now())
+	}
+
+	unscreened := key == "" && strings.HasPrefix(m.Filetype, "image/")
+	if unscreened {
+		m.HiddenAt = nulls.NewTime(time.Now())
+	}
+
 	verrs, err := m.Create(tx)
 	if err != nil {
 		return c.Error(http.StatusInternalServerError, fmt.Errorf("unable to create medium %v", err))
@@ -73,5 +207,26 @@ func MediaUpload(c buffalo.Context) error {
 		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
 	}
 
+	if match != nil {
+		if err := match.Record(tx, u, m); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if unscreened {
+		if err := models.RecordAudit(tx, u, models.AuditQuarantinedUpload, u, m, "image hosted elsewhere could not be screened"); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	mentioned, err := m.IndexCaption(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := notifyMentions(c, tx, u, m, nil, mentioned); err != nil {
+		return errors.WithStack(err)
+	}
+
+	created = true
 	return c.Render(http.StatusOK, r.JSON(m))
 }
@@ -3,8 +3,10 @@ package actions
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/derhabicht/rmuse/models"
+	"github.com/markbates/pop/nulls"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -32,12 +34,14 @@ func (as *ActionSuite) Test_Media_Upload_Logged_In() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		UserType:     "artist",
-		PasswordHash: string(ph),
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
+		PasswordHash:     string(ph),
+		EmailVerifiedAt:  nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -69,12 +73,14 @@ func (as *ActionSuite) Test_Media_Upload_Empty_Type() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		EmailVerifiedAt:  nulls.NewTime(time.Now()),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -107,12 +113,14 @@ func (as *ActionSuite) Test_Media_Upload_Empty_URI() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		EmailVerifiedAt:  nulls.NewTime(time.Now()),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -145,12 +153,12 @@ func (as *ActionSuite) Test_Media_Upload_Follower() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		UserType:     "follower",
-		PasswordHash: string(ph),
+		FirstName:       "Oreo",
+		LastName:        "Hawk",
+		Email:           "cat@example.com",
+		Username:        "oreo",
+		PasswordHash:    string(ph),
+		EmailVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -183,12 +191,14 @@ func (as *ActionSuite) Test_Media_Upload_Duplicate_URI() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		EmailVerifiedAt:  nulls.NewTime(time.Now()),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -249,12 +259,12 @@ func (as *ActionSuite) Test_Media_Get_Follower() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "follower",
+		FirstName:       "Oreo",
+		LastName:        "Hawk",
+		Email:           "cat@example.com",
+		Username:        "oreo",
+		PasswordHash:    string(ph),
+		EmailVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -264,12 +274,14 @@ func (as *ActionSuite) Test_Media_Get_Follower() {
 	as.NoError(err)
 
 	user = models.User{
-		FirstName:    "Raja",
-		LastName:     "Hawk",
-		Email:        "clutz@example.com",
-		Username:     "raja",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Raja",
+		LastName:         "Hawk",
+		Email:            "clutz@example.com",
+		Username:         "raja",
+		PasswordHash:     string(ph),
+		EmailVerifiedAt:  nulls.NewTime(time.Now()),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -338,12 +350,12 @@ func (as *ActionSuite) Test_Media_Get_Follower_Unauthorized() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "follower",
+		FirstName:       "Oreo",
+		LastName:        "Hawk",
+		Email:           "cat@example.com",
+		Username:        "oreo",
+		PasswordHash:    string(ph),
+		EmailVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -353,12 +365,14 @@ func (as *ActionSuite) Test_Media_Get_Follower_Unauthorized() {
 	as.NoError(err)
 
 	user = models.User{
-		FirstName:    "Raja",
-		LastName:     "Hawk",
-		Email:        "clutz@example.com",
-		Username:     "raja",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Raja",
+		LastName:         "Hawk",
+		Email:            "clutz@example.com",
+		Username:         "raja",
+		PasswordHash:     string(ph),
+		EmailVerifiedAt:  nulls.NewTime(time.Now()),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -0,0 +1,156 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// MFAEnroll starts TOTP enrollment for the current user, returning the
+// secret and a provisioning URI for their authenticator app. The secret is
+// not used at login until it is confirmed with MFAConfirm.
+func MFAEnroll(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to enable two-factor authentication"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if u.TOTPEnabled {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("two-factor authentication is already enabled"))
+	}
+
+	uri, err := u.BeginTOTPEnrollment()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := tx.Update(u); err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"provisioning_uri"`
+	}{
+		Secret: u.TOTPSecret,
+		URI:    uri,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// MFAConfirm enables two-factor authentication once the user proves their
+// authenticator produces valid codes, and returns a fresh set of recovery
+// codes.
+func MFAConfirm(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to enable two-factor authentication"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Code string `json:"code"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if u.TOTPEnabled {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("two-factor authentication is already enabled"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	if !u.UseTOTP(tx, arg.Code) {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid authentication code"))
+	}
+
+	u.TOTPEnabled = true
+
+	if err := tx.Update(u); err != nil {
+		return errors.WithStack(err)
+	}
+
+	codes, err := models.GenerateRecoveryCodes(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// MFADisable turns off two-factor authentication. The user must give their
+// password and a current TOTP or recovery code.
+func MFADisable(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to disable two-factor authentication"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if !u.TOTPEnabled {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("two-factor authentication is not enabled"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(arg.Password)) != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid password or authentication code"))
+	}
+
+	if !u.UseTOTP(tx, arg.Code) && !models.UseRecoveryCode(tx, u, arg.Code) {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid password or authentication code"))
+	}
+
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+
+	if err := tx.Update(u); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM recovery_codes WHERE user_id = ?", u.ID).Exec(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
@@ -0,0 +1,179 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) createTOTPUser() (*models.User, []string) {
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := &models.User{
+		FirstName:    "Oreo",
+		LastName:     "Hawk",
+		Email:        "cat@example.com",
+		Username:     "oreo",
+		PasswordHash: string(ph),
+	}
+
+	_, err = u.BeginTOTPEnrollment()
+	as.NoError(err)
+	u.TOTPEnabled = true
+
+	err = as.DB.Create(u)
+	as.NoError(err)
+
+	codes, err := models.GenerateRecoveryCodes(as.DB, u)
+	as.NoError(err)
+
+	return u, codes
+}
+
+func (as *ActionSuite) loginMFAToken() string {
+	arg := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		Email:    "cat@example.com",
+		Password: "goodpassword",
+	}
+
+	res := as.JSON("/api/1/login").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), "\"token\"")
+
+	body := struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+	as.True(body.MFARequired)
+
+	return body.MFAToken
+}
+
+// Test_MFA_Enroll enrolls a user and confirms their authenticator.
+func (as *ActionSuite) Test_MFA_Enroll() {
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := &models.User{
+		FirstName:    "Oreo",
+		LastName:     "Hawk",
+		Email:        "cat@example.com",
+		Username:     "oreo",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(u))
+
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+
+	req := as.JSON("/api/1/user/mfa")
+	req.Headers["Authorization"] = ts
+	res := req.Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "otpauth://totp/")
+
+	enrollment := struct {
+		Secret string `json:"secret"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &enrollment))
+
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	as.NoError(err)
+
+	arg := struct {
+		Code string `json:"code"`
+	}{
+		Code: code,
+	}
+
+	req = as.JSON("/api/1/user/mfa/confirm")
+	req.Headers["Authorization"] = ts
+	res = req.Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "recovery_codes")
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.True(u.TOTPEnabled)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM recovery_codes")
+}
+
+// Test_MFA_Login logs in with a password followed by a TOTP code.
+func (as *ActionSuite) Test_MFA_Login() {
+	u, _ := as.createTOTPUser()
+	mt := as.loginMFAToken()
+
+	code, err := totp.GenerateCode(u.TOTPSecret, time.Now())
+	as.NoError(err)
+
+	arg := struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}{
+		MFAToken: mt,
+		Code:     code,
+	}
+
+	res := as.JSON("/api/1/login/mfa").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "token")
+	as.Contains(res.Body.String(), "oreo")
+
+	// a code cannot be used twice
+	arg.MFAToken = as.loginMFAToken()
+	res = as.JSON("/api/1/login/mfa").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM recovery_codes")
+}
+
+// Test_MFA_Login_Recovery_Code logs in with a recovery code, which may only
+// be used once.
+func (as *ActionSuite) Test_MFA_Login_Recovery_Code() {
+	_, codes := as.createTOTPUser()
+
+	arg := struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}{
+		MFAToken: as.loginMFAToken(),
+		Code:     codes[0],
+	}
+
+	res := as.JSON("/api/1/login/mfa").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/login/mfa").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "invalid authentication code")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM recovery_codes")
+}
+
+// Test_MFA_Token_Not_Session makes sure the pending token cannot be used in
+// place of a session token.
+func (as *ActionSuite) Test_MFA_Token_Not_Session() {
+	as.createTOTPUser()
+
+	req := as.JSON("/api/1/user")
+	req.Headers["Authorization"] = as.loginMFAToken()
+	res := req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM recovery_codes")
+}
@@ -0,0 +1,186 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// NotificationList shows a page of the current user's notifications, most
+// recently updated first, along with how many are unread. Setting "unread"
+// lists only the unread ones.
+func NotificationList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to read notifications"))
+	}
+
+	if err := requireScope(c, models.ScopeNotificationsRead); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	page, perPage := pageParams(c)
+
+	notifications, err := models.GetNotifications(tx, u, c.Param("unread") == "true", page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	unread, err := models.UnreadNotificationCount(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		UnreadCount   int                  `json:"unread_count"`
+		Notifications models.Notifications `json:"notifications"`
+	}{
+		UnreadCount:   unread,
+		Notifications: notifications,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// NotificationRead marks one of the current user's notifications as read.
+func NotificationRead(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to read notifications"))
+	}
+
+	if err := requireScope(c, models.ScopeNotificationsWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("notification not found"))
+	}
+
+	n, err := models.GetNotificationByID(tx, id)
+	if err != nil || n.User != u.ID {
+		return c.Error(http.StatusNotFound, fmt.Errorf("notification not found"))
+	}
+
+	if err := n.MarkRead(tx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(n))
+}
+
+// NotificationReadAll marks all of the current user's notifications as read.
+func NotificationReadAll(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to read notifications"))
+	}
+
+	if err := requireScope(c, models.ScopeNotificationsWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := models.MarkAllNotificationsRead(tx, u); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(map[string]int{"unread_count": 0}))
+}
+
+// UserNotificationsRead shows which types of notification the current user
+// wants.
+func UserNotificationsRead(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to read notification settings"))
+	}
+
+	if err := requireScope(c, models.ScopeProfileRead); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	p, err := models.GetNotificationPreference(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(p))
+}
+
+// UserNotificationsUpdate turns types of notification on or off for the
+// current user. Types left out of the body are unchanged.
+func UserNotificationsUpdate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to change notification settings"))
+	}
+
+	if err := requireScope(c, models.ScopeProfileWrite); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Follow        *bool `json:"follow"`
+		FollowRequest *bool `json:"follow_request"`
+		Like          *bool `json:"like"`
+		Comment       *bool `json:"comment"`
+		Mention       *bool `json:"mention"`
+		Moderation    *bool `json:"moderation"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	p, err := models.GetNotificationPreference(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	settings := []struct {
+		arg  *bool
+		pref *bool
+	}{
+		{arg.Follow, &p.Follow},
+		{arg.FollowRequest, &p.FollowRequest},
+		{arg.Like, &p.Like},
+		{arg.Comment, &p.Comment},
+		{arg.Mention, &p.Mention},
+		{arg.Moderation, &p.Moderation},
+	}
+	for _, s := range settings {
+		if s.arg != nil {
+			*s.pref = *s.arg
+		}
+	}
+
+	verrs, err := p.Save(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(p))
+}
@@ -0,0 +1,206 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/markbates/pop/nulls"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// notificationList is the body of a notification list.
+type notificationList struct {
+	UnreadCount   int                  `json:"unread_count"`
+	Notifications models.Notifications `json:"notifications"`
+}
+
+// notifications fetches u's notifications from path.
+func (as *ActionSuite) notifications(u *models.User, path string) *notificationList {
+	res := as.authJSON(u, path).Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	l := &notificationList{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), l))
+	return l
+}
+
+// Test_Notifications groups repeated likes into one notification, marks
+// notifications read, stops types the user has turned off and leaves out
+// suspended actors.
+func (as *ActionSuite) Test_Notifications() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+	liker := func(username string) *models.User {
+		u := &models.User{
+			FirstName:    username,
+			LastName:     "Hawk",
+			Email:        username + "@example.com",
+			Username:     username,
+			PasswordHash: string(ph),
+		}
+		as.NoError(as.DB.Create(u))
+		return u
+	}
+	moss := liker("moss")
+	wren := liker("wren")
+	finch := liker("finch")
+
+	m := &models.Medium{
+		URI:        "https://example.com/song.mp3",
+		User:       oreo.ID,
+		Filetype:   "audio/mpeg",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(m))
+
+	like := "/api/1/media/" + m.ID.String() + "/like"
+
+	as.Equal(http.StatusOK, as.authJSON(raja, like).Post(nil).Code)
+	as.Equal(http.StatusOK, as.authJSON(moss, like).Post(nil).Code)
+
+	// liking again after unliking does not notify again
+	as.Equal(http.StatusOK, as.authJSON(raja, like).Delete().Code)
+	as.Equal(http.StatusOK, as.authJSON(raja, like).Post(nil).Code)
+
+	// oreo's own like is not notified
+	as.Equal(http.StatusOK, as.authJSON(oreo, like).Post(nil).Code)
+
+	l := as.notifications(oreo, "/api/1/notifications")
+	as.Equal(1, l.UnreadCount)
+	as.Len(l.Notifications, 1)
+	as.Equal(models.NotifyLike, l.Notifications[0].Type)
+	as.Equal(2, l.Notifications[0].Count)
+	as.Equal([]string{"moss", "raja"}, l.Notifications[0].Actors)
+
+	res := as.authJSON(raja, "/api/1/user/oreo/follow").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	l = as.notifications(oreo, "/api/1/notifications")
+	as.Equal(2, l.UnreadCount)
+	as.Equal(models.NotifyFollow, l.Notifications[0].Type)
+
+	// only the owner marks a notification read
+	n := l.Notifications[0]
+	res = as.authJSON(raja, "/api/1/notifications/"+n.ID.String()+"/read").Post(nil)
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(oreo, "/api/1/notifications/"+n.ID.String()+"/read").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), `"read_at":null`)
+
+	l = as.notifications(oreo, "/api/1/notifications?unread=true")
+	as.Equal(1, l.UnreadCount)
+	as.Len(l.Notifications, 1)
+	as.Equal(models.NotifyLike, l.Notifications[0].Type)
+
+	res = as.authJSON(oreo, "/api/1/notifications/read").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	l = as.notifications(oreo, "/api/1/notifications")
+	as.Equal(0, l.UnreadCount)
+	as.Len(l.Notifications, 2)
+
+	// a like after the group was read starts a new one, but liking again
+	// does not
+	as.Equal(http.StatusOK, as.authJSON(wren, like).Post(nil).Code)
+	as.Equal(http.StatusOK, as.authJSON(moss, like).Delete().Code)
+	as.Equal(http.StatusOK, as.authJSON(moss, like).Post(nil).Code)
+
+	l = as.notifications(oreo, "/api/1/notifications?unread=true")
+	as.Len(l.Notifications, 1)
+	as.Equal(1, l.Notifications[0].Count)
+	as.Equal([]string{"wren"}, l.Notifications[0].Actors)
+
+	res = as.authJSON(oreo, "/api/1/user/notifications").Put(map[string]bool{"like": false})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"like":false`)
+	as.Contains(res.Body.String(), `"comment":true`)
+
+	as.Equal(http.StatusOK, as.authJSON(finch, like).Post(nil).Code)
+
+	l = as.notifications(oreo, "/api/1/notifications?unread=true")
+	as.Len(l.Notifications, 1)
+	as.Equal([]string{"wren"}, l.Notifications[0].Actors)
+
+	// comments still notify
+	as.comment(raja, m, map[string]string{"body": "lovely"})
+
+	l = as.notifications(oreo, "/api/1/notifications?unread=true")
+	as.Equal(2, l.UnreadCount)
+	as.Equal(models.NotifyComment, l.Notifications[0].Type)
+
+	// suspended actors are left out, along with notifications that had no
+	// one else behind them
+	for _, u := range []*models.User{moss, wren} {
+		u.SuspendedAt = nulls.NewTime(time.Now())
+		as.NoError(as.DB.Update(u))
+	}
+
+	l = as.notifications(oreo, "/api/1/notifications?unread=true")
+	as.Equal(1, l.UnreadCount)
+	as.Len(l.Notifications, 1)
+	as.Equal(models.NotifyComment, l.Notifications[0].Type)
+
+	l = as.notifications(oreo, "/api/1/notifications?per_page=2")
+	as.Len(l.Notifications, 2)
+
+	l = as.notifications(oreo, "/api/1/notifications")
+	as.Len(l.Notifications, 3)
+	for _, n := range l.Notifications {
+		if n.Type == models.NotifyLike {
+			as.Equal(1, n.Count)
+			as.Equal([]string{"raja"}, n.Actors)
+		}
+	}
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM follows")
+	as.DB.RawQuery("DELETE FROM likes")
+	as.DB.RawQuery("DELETE FROM like_notices")
+	as.DB.RawQuery("DELETE FROM comments")
+	as.DB.RawQuery("DELETE FROM notifications")
+	as.DB.RawQuery("DELETE FROM notification_preferences")
+}
+
+// Test_Notifications_Moderation tells the reporter and the owner of a hidden
+// medium how a report was resolved.
+func (as *ActionSuite) Test_Notifications_Moderation() {
+	mod := as.createModerator()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:        "https://example.com/spam.png",
+		User:       raja.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(m))
+
+	rep := as.report(mod, map[string]string{"medium_id": m.ID.String(), "category": models.ReportSpam})
+
+	res := as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/hide").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	l := as.notifications(raja, "/api/1/notifications")
+	as.Len(l.Notifications, 1)
+	as.Equal(models.NotifyModeration, l.Notifications[0].Type)
+	as.Equal(m.ID, l.Notifications[0].Medium.UUID)
+
+	l = as.notifications(mod, "/api/1/notifications")
+	as.Len(l.Notifications, 1)
+	as.Contains(l.Notifications[0].Message, "taken action")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM reports")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+	as.DB.RawQuery("DELETE FROM notifications")
+}
@@ -0,0 +1,369 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// oauthError is an error response as defined by RFC 6749.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// OAuthClientCreate registers a third-party application owned by the current
+// user. Confidential clients get a secret, which is only returned here.
+func OAuthClientCreate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to register applications"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	cl, secret, err := models.NewOAuthClient(u, arg.Name, arg.RedirectURIs, arg.Confidential)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	verrs, err := cl.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	res := struct {
+		*models.OAuthClient
+		Secret string `json:"client_secret,omitempty"`
+	}{
+		OAuthClient: cl,
+		Secret:      secret,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// OAuthClientList lists the applications the current user has registered.
+func OAuthClientList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list applications"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	cl, err := models.GetOAuthClientsByUser(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(cl))
+}
+
+// authorizeRequest is an authorization request as sent to the consent
+// endpoints.
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// check validates an authorization request, returning the client and the
+// requested scopes.
+func (a *authorizeRequest) check(tx *pop.Connection) (*models.OAuthClient, []string, error) {
+	id, err := uuid.FromString(a.ClientID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown client")
+	}
+
+	cl, err := models.GetOAuthClientByID(tx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown client")
+	}
+
+	if !cl.AllowsRedirect(a.RedirectURI) {
+		return nil, nil, fmt.Errorf("redirect_uri is not registered for this client")
+	}
+
+	if a.ResponseType != "code" {
+		return nil, nil, fmt.Errorf("response_type must be code")
+	}
+
+	if a.CodeChallenge == "" || a.CodeChallengeMethod != "S256" {
+		return nil, nil, fmt.Errorf("a S256 code_challenge is required")
+	}
+
+	scopes := strings.Fields(a.Scope)
+	if len(scopes) == 0 {
+		return nil, nil, fmt.Errorf("scope is empty")
+	}
+
+	for _, s := range scopes {
+		if !models.ValidScope(s) {
+			return nil, nil, fmt.Errorf("unknown scope %s", s)
+		}
+	}
+
+	return cl, scopes, nil
+}
+
+// OAuthAuthorize describes an authorization request so that the front end
+// can ask the current user for consent.
+func OAuthAuthorize(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to authorize applications"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	q := c.Request().URL.Query()
+	a := &authorizeRequest{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	cl, scopes, err := a.check(tx)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	res := struct {
+		Client      string   `json:"client_id"`
+		Name        string   `json:"name"`
+		RedirectURI string   `json:"redirect_uri"`
+		Scopes      []string `json:"scopes"`
+	}{
+		Client:      cl.ID.String(),
+		Name:        cl.Name,
+		RedirectURI: a.RedirectURI,
+		Scopes:      scopes,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// OAuthConsent records the current user's answer to an authorization request
+// and returns the address to send them back to the client with.
+func OAuthConsent(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to authorize applications"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	a := &authorizeRequest{}
+	if err := c.Bind(a); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	cl, scopes, err := a.check(tx)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	redirect, err := url.Parse(a.RedirectURI)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	params := redirect.Query()
+	if a.State != "" {
+		params.Set("state", a.State)
+	}
+
+	if a.Approve {
+		if err := models.Grant(tx, u, cl, scopes); err != nil {
+			return errors.WithStack(err)
+		}
+
+		code, err := models.CreateOAuthCode(tx, cl, u, a.RedirectURI, scopes, a.CodeChallenge)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		params.Set("code", code)
+	} else {
+		params.Set("error", "access_denied")
+	}
+	redirect.RawQuery = params.Encode()
+
+	res := struct {
+		RedirectTo string `json:"redirect_to"`
+	}{
+		RedirectTo: redirect.String(),
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// OAuthToken exchanges an authorization code for an access token. Requests
+// are form encoded, as RFC 6749 requires.
+func OAuthToken(c buffalo.Context) error {
+	bad := func(status int, code string, description string) error {
+		return c.Render(status, r.JSON(oauthError{Error: code, Description: description}))
+	}
+
+	req := c.Request()
+	if err := req.ParseForm(); err != nil {
+		return bad(http.StatusBadRequest, "invalid_request", "malformed form body")
+	}
+
+	if req.PostForm.Get("grant_type") != "authorization_code" {
+		return bad(http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+
+	clientID, secret, ok := req.BasicAuth()
+	if !ok {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	id, err := uuid.FromString(clientID)
+	if err != nil {
+		return bad(http.StatusUnauthorized, "invalid_client", "")
+	}
+
+	cl, err := models.GetOAuthClientByID(tx, id)
+	if err != nil {
+		return bad(http.StatusUnauthorized, "invalid_client", "")
+	}
+
+	if cl.Confidential() && !cl.ValidSecret(secret) {
+		return bad(http.StatusUnauthorized, "invalid_client", "")
+	}
+
+	oc, err := models.RedeemOAuthCode(tx, cl, req.PostForm.Get("code"), req.PostForm.Get("redirect_uri"), req.PostForm.Get("code_verifier"))
+	if err != nil {
+		return bad(http.StatusBadRequest, "invalid_grant", err.Error())
+	}
+
+	u, err := models.GetUserByID(tx, oc.User)
+	if err != nil {
+		return bad(http.StatusBadRequest, "invalid_grant", "")
+	}
+
+	if u.Suspended() {
+		return bad(http.StatusBadRequest, "invalid_grant", "account is suspended")
+	}
+
+	ttl := envDuration("OAUTH_TOKEN_TTL", "1h")
+	ts, err := u.CreateOAuthToken(cl.ID, oc.Scopes, ttl)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	res := struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}{
+		AccessToken: ts,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(ttl.Seconds()),
+		Scope:       strings.Join(oc.Scopes, " "),
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// UserAppList lists the applications the current user has authorized.
+func UserAppList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list applications"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	g, err := models.GetOAuthGrantsByUser(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(g))
+}
+
+// UserAppRevoke withdraws the current user's authorization of an
+// application. Tokens already issued to it stop working.
+func UserAppRevoke(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to revoke applications"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	id, err := uuid.FromString(c.Param("client_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("application %s is not authorized", c.Param("client_id")))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := models.RevokeOAuthGrant(tx, u, id); err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("application %s is not authorized", c.Param("client_id")))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(""))
+}
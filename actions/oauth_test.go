@@ -0,0 +1,170 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func (as *ActionSuite) createOAuthClient(u *models.User) *models.OAuthClient {
+	cl, _, err := models.NewOAuthClient(u, "Player", []string{"https://player.example.com/callback"}, false)
+	as.NoError(err)
+
+	verrs, err := cl.Create(as.DB)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+
+	return cl
+}
+
+// authorize consents to cl on behalf of u and returns the authorization
+// code.
+func (as *ActionSuite) authorize(u *models.User, cl *models.OAuthClient, scope string) string {
+	arg := struct {
+		ResponseType        string `json:"response_type"`
+		ClientID            string `json:"client_id"`
+		RedirectURI         string `json:"redirect_uri"`
+		Scope               string `json:"scope"`
+		State               string `json:"state"`
+		CodeChallenge       string `json:"code_challenge"`
+		CodeChallengeMethod string `json:"code_challenge_method"`
+		Approve             bool   `json:"approve"`
+	}{
+		ResponseType:        "code",
+		ClientID:            cl.ID.String(),
+		RedirectURI:         "https://player.example.com/callback",
+		Scope:               scope,
+		State:               "xyz",
+		CodeChallenge:       models.PKCEChallenge(testVerifier),
+		CodeChallengeMethod: "S256",
+		Approve:             true,
+	}
+
+	req := as.JSON("/api/1/oauth/authorize")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	body := struct {
+		RedirectTo string `json:"redirect_to"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+
+	redirect, err := url.Parse(body.RedirectTo)
+	as.NoError(err)
+	as.Equal("xyz", redirect.Query().Get("state"))
+
+	return redirect.Query().Get("code")
+}
+
+func (as *ActionSuite) exchange(cl *models.OAuthClient, code string, verifier string) (int, string) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://player.example.com/callback"},
+		"client_id":     {cl.ID.String()},
+		"code_verifier": {verifier},
+	}
+
+	res := as.HTML("/api/1/oauth/token").Post(form)
+
+	body := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	json.Unmarshal(res.Body.Bytes(), &body)
+
+	return res.Code, body.AccessToken
+}
+
+// Test_OAuth_Authorization_Code runs through the whole authorization code
+// flow and uses the resulting token.
+func (as *ActionSuite) Test_OAuth_Authorization_Code() {
+	u := as.createOreo()
+	cl := as.createOAuthClient(u)
+
+	code := as.authorize(u, cl, "profile:read")
+	status, token := as.exchange(cl, code, testVerifier)
+	as.Equal(http.StatusOK, status)
+
+	req := as.JSON("/api/1/user")
+	req.Headers["Authorization"] = "Bearer " + token
+	res := req.Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "oreo")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM oauth_clients")
+	as.DB.RawQuery("DELETE FROM oauth_grants")
+}
+
+// Test_OAuth_Bad_Verifier refuses to exchange a code without the PKCE
+// verifier it was issued for.
+func (as *ActionSuite) Test_OAuth_Bad_Verifier() {
+	u := as.createOreo()
+	cl := as.createOAuthClient(u)
+
+	code := as.authorize(u, cl, "profile:read")
+	status, _ := as.exchange(cl, code, "not-the-verifier")
+	as.Equal(http.StatusBadRequest, status)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM oauth_clients")
+	as.DB.RawQuery("DELETE FROM oauth_grants")
+}
+
+// Test_OAuth_Scope_Enforced refuses an action outside the granted scopes.
+func (as *ActionSuite) Test_OAuth_Scope_Enforced() {
+	u := as.createOreo()
+	cl := as.createOAuthClient(u)
+
+	code := as.authorize(u, cl, "media:read")
+	_, token := as.exchange(cl, code, testVerifier)
+
+	req := as.JSON("/api/1/user")
+	req.Headers["Authorization"] = "Bearer " + token
+	res := req.Get()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM oauth_clients")
+	as.DB.RawQuery("DELETE FROM oauth_grants")
+}
+
+// Test_OAuth_Revoke lists and revokes an authorized application, after which
+// its tokens stop working.
+func (as *ActionSuite) Test_OAuth_Revoke() {
+	u := as.createOreo()
+	cl := as.createOAuthClient(u)
+
+	code := as.authorize(u, cl, "profile:read")
+	_, token := as.exchange(cl, code, testVerifier)
+
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+
+	req := as.JSON("/api/1/user/apps")
+	req.Headers["Authorization"] = ts
+	res := req.Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Player")
+
+	req = as.JSON(fmt.Sprintf("/api/1/user/apps/%s", cl.ID))
+	req.Headers["Authorization"] = ts
+	res = req.Delete()
+	as.Equal(http.StatusOK, res.Code)
+
+	req = as.JSON("/api/1/user")
+	req.Headers["Authorization"] = "Bearer " + token
+	res = req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM oauth_clients")
+}
@@ -0,0 +1,241 @@
+package actions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+var (
+	oidcMu        sync.Mutex
+	oidcProviders = map[string]*oidc.Provider{}
+)
+
+// oidcStateCookie holds the state of the login the browser started, so that
+// a callback can only complete a login begun in the same browser.
+const oidcStateCookie = "_rmuse_oidc_state"
+
+// setOIDCState stores state in the browser, or clears it if state is empty.
+func setOIDCState(c buffalo.Context, state string) {
+	ck := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/1/login/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   ENV == "production",
+		HttpOnly: true,
+	}
+	if state == "" {
+		ck.MaxAge = -1
+	}
+
+	http.SetCookie(c.Response(), ck)
+}
+
+// oidcConfig returns the OpenID Connect provider named by OIDC_ISSUER, and
+// the OAuth 2.0 configuration for logging in with it. Providers are
+// discovered on first use.
+func oidcConfig(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	issuer := envy.Get("OIDC_ISSUER", "")
+	if issuer == "" {
+		return nil, nil, fmt.Errorf("oidc login is not configured")
+	}
+
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	p, ok := oidcProviders[issuer]
+	if !ok {
+		var err error
+		p, err = oidc.NewProvider(ctx, issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not discover oidc provider %v", err)
+		}
+		oidcProviders[issuer] = p
+	}
+
+	conf := &oauth2.Config{
+		ClientID:     envy.Get("OIDC_CLIENT_ID", ""),
+		ClientSecret: envy.Get("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  envy.Get("OIDC_REDIRECT_URL", envy.Get("APP_URL", "http://127.0.0.1:3000")+"/login/oidc"),
+		Endpoint:     p.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email"},
+	}
+
+	return p, conf, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// AuthOIDCStart returns the address to send the user to in order to log in
+// with the configured OpenID Connect provider. The signed state is also set
+// as a cookie, which the callback checks.
+func AuthOIDCStart(c buffalo.Context) error {
+	_, conf, err := oidcConfig(c)
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	state, err := models.CreateOIDCStateToken(nonce)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	setOIDCState(c, state)
+
+	res := struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}{
+		AuthorizationURL: conf.AuthCodeURL(state, oidc.Nonce(nonce)),
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// AuthOIDCCallback completes an OpenID Connect login. The front end posts the
+// code and state the provider redirected back with. The external account is
+// matched to a user it was linked to before, or linked now to the user with
+// the same email address if both sides have verified it. The state must
+// match the cookie set by AuthOIDCStart, and failures are throttled like
+// password logins.
+func AuthOIDCCallback(c buffalo.Context) error {
+	type argument struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	p, conf, err := oidcConfig(c)
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	keys := []string{addressKey(c)}
+	bad := func(msg string) error {
+		throttle.fail(c, "", models.LoginBadOIDC, keys...)
+		return c.Error(http.StatusUnprocessableEntity, errors.New(msg))
+	}
+
+	wait, err := throttle.retryAfter(keys...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if wait > 0 {
+		throttle.fail(c, "", models.LoginLockedOut)
+		return throttle.refuse(c, wait)
+	}
+
+	ck, err := c.Request().Cookie(oidcStateCookie)
+	if err != nil || ck.Value != arg.State {
+		return bad("invalid state")
+	}
+	setOIDCState(c, "")
+
+	state, err := models.ParseToken(arg.State, models.TokenOIDCState)
+	if err != nil {
+		return bad("invalid state")
+	}
+
+	ot, err := conf.Exchange(c, arg.Code)
+	if err != nil {
+		return bad("could not exchange code with provider")
+	}
+
+	raw, ok := ot.Extra("id_token").(string)
+	if !ok {
+		return bad("provider did not return an id token")
+	}
+
+	idt, err := p.Verifier(&oidc.Config{ClientID: conf.ClientID}).Verify(c, raw)
+	if err != nil {
+		return bad("invalid id token")
+	}
+
+	if nonce, _ := state["nonce"].(string); nonce == "" || idt.Nonce != nonce {
+		return bad("invalid id token")
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	u, err := models.GetUserByIdentity(tx, idt.Issuer, idt.Subject)
+	if err != nil {
+		claims := struct {
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+		}{}
+		if err := idt.Claims(&claims); err != nil {
+			return errors.WithStack(err)
+		}
+
+		u, err = models.GetUserByEmail(tx, strings.ToLower(claims.Email))
+		if err != nil || claims.Email == "" {
+			return bad("no rmuse account is linked to this identity")
+		}
+
+		// Linking on an address the user never proved they own would let
+		// someone who signed up with it first take over the account.
+		if !claims.EmailVerified || !u.EmailVerified() {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("email must be verified with both rmuse and the provider to link accounts"))
+		}
+
+		i := &models.Identity{
+			User:    u.ID,
+			Issuer:  idt.Issuer,
+			Subject: idt.Subject,
+			Email:   u.Email,
+		}
+
+		verrs, err := i.Create(tx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if verrs.HasAny() {
+			return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+		}
+	}
+
+	// an account locked out by failed passwords stays locked out here too
+	wait, err = throttle.retryAfter(accountKey(u.Email))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if wait > 0 {
+		throttle.fail(c, u.Email, models.LoginLockedOut)
+		return throttle.refuse(c, wait)
+	}
+
+	return beginSession(c, u)
+}
@@ -0,0 +1,252 @@
+package actions
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop/nulls"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// mockProvider is a minimal OpenID Connect provider that issues an ID token
+// for a single account to whoever asks.
+type mockProvider struct {
+	*httptest.Server
+	key           *rsa.PrivateKey
+	nonce         string
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+func newMockProvider() *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &mockProvider{
+		key:           key,
+		subject:       "248289761001",
+		email:         "cat@example.com",
+		emailVerified: true,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   enc.EncodeToString(p.key.N.Bytes()),
+				"e":   enc.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            p.URL,
+			"sub":            p.subject,
+			"aud":            "rmuse",
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          p.nonce,
+			"email":          p.email,
+			"email_verified": p.emailVerified,
+		})
+		token.Header["kid"] = "test"
+
+		idt, err := token.SignedString(p.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idt,
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// oidcCallback posts the code and state to the callback with the state
+// cookie set to cookie.
+func (as *ActionSuite) oidcCallback(code string, state string, cookie string) (int, string) {
+	arg := struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}{
+		Code:  code,
+		State: state,
+	}
+
+	req := as.JSON("/api/1/login/oidc")
+	if cookie != "" {
+		req.Headers["Cookie"] = oidcStateCookie + "=" + cookie
+	}
+
+	res := req.Post(arg)
+	return res.Code, res.Body.String()
+}
+
+// oidcLogin runs an OIDC login against the mock provider and returns the
+// response from the callback.
+func (as *ActionSuite) oidcLogin(p *mockProvider) (int, string) {
+	res := as.JSON("/api/1/login/oidc").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Header().Get("Set-Cookie"), oidcStateCookie)
+
+	start := struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &start))
+
+	au, err := url.Parse(start.AuthorizationURL)
+	as.NoError(err)
+	p.nonce = au.Query().Get("nonce")
+
+	state := au.Query().Get("state")
+	return as.oidcCallback("provider-code", state, state)
+}
+
+func (as *ActionSuite) withMockProvider(test func(p *mockProvider)) {
+	p := newMockProvider()
+	defer p.Close()
+
+	envy.Set("OIDC_ISSUER", p.URL)
+	envy.Set("OIDC_CLIENT_ID", "rmuse")
+	defer envy.Set("OIDC_ISSUER", "")
+
+	test(p)
+}
+
+func (as *ActionSuite) createVerifiedOreo() *models.User {
+	u := as.createOreo()
+	u.EmailVerifiedAt = nulls.NewTime(time.Now())
+	as.NoError(as.DB.Update(u))
+
+	return u
+}
+
+// Test_OIDC_Link_By_Email links an external account to the user with the
+// same verified address, then logs in with it.
+func (as *ActionSuite) Test_OIDC_Link_By_Email() {
+	as.withMockProvider(func(p *mockProvider) {
+		u := as.createVerifiedOreo()
+
+		status, body := as.oidcLogin(p)
+		as.Equal(http.StatusOK, status)
+		as.Contains(body, "token")
+		as.Contains(body, "oreo")
+
+		linked, err := models.GetUserByIdentity(as.DB, p.URL, p.subject)
+		as.NoError(err)
+		as.Equal(u.ID, linked.ID)
+
+		status, _ = as.oidcLogin(p)
+		as.Equal(http.StatusOK, status)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM identities")
+}
+
+// Test_OIDC_Unverified_Email refuses to link on an address the provider has
+// not verified.
+func (as *ActionSuite) Test_OIDC_Unverified_Email() {
+	as.withMockProvider(func(p *mockProvider) {
+		as.createVerifiedOreo()
+		p.emailVerified = false
+
+		status, body := as.oidcLogin(p)
+		as.Equal(http.StatusUnprocessableEntity, status)
+		as.Contains(body, "email must be verified")
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_OIDC_No_Account refuses a login from an identity with no account.
+func (as *ActionSuite) Test_OIDC_No_Account() {
+	as.withMockProvider(func(p *mockProvider) {
+		status, body := as.oidcLogin(p)
+		as.Equal(http.StatusUnprocessableEntity, status)
+		as.Contains(body, "no rmuse account is linked to this identity")
+	})
+}
+
+// Test_OIDC_Bad_Nonce rejects an ID token minted for another login.
+func (as *ActionSuite) Test_OIDC_Bad_Nonce() {
+	as.withMockProvider(func(p *mockProvider) {
+		as.createVerifiedOreo()
+
+		state, err := models.CreateOIDCStateToken("expected-nonce")
+		as.NoError(err)
+		p.nonce = "some-other-nonce"
+
+		status, body := as.oidcCallback("provider-code", state, state)
+		as.Equal(http.StatusUnprocessableEntity, status)
+		as.Contains(body, "invalid id token")
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_OIDC_State_Cookie refuses a callback from a browser that did not start
+// the login, and throttles repeated failures.
+func (as *ActionSuite) Test_OIDC_State_Cookie() {
+	as.withMockProvider(func(p *mockProvider) {
+		as.createVerifiedOreo()
+
+		state, err := models.CreateOIDCStateToken("expected-nonce")
+		as.NoError(err)
+		p.nonce = "expected-nonce"
+
+		status, body := as.oidcCallback("provider-code", state, "")
+		as.Equal(http.StatusUnprocessableEntity, status)
+		as.Contains(body, "invalid state")
+
+		other, err := models.CreateOIDCStateToken("expected-nonce")
+		as.NoError(err)
+
+		status, _ = as.oidcCallback("provider-code", state, other)
+		as.Equal(http.StatusUnprocessableEntity, status)
+
+		throttle.BackoffAfter = 2
+		throttle.BackoffBase = time.Minute
+
+		status, _ = as.oidcCallback("provider-code", state, state)
+		as.Equal(http.StatusTooManyRequests, status)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM login_failures")
+}
@@ -0,0 +1,163 @@
+package actions
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/disintegration/imaging"
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// maxImageUpload is the largest profile image that can be uploaded, in bytes.
+const maxImageUpload = 10 << 20
+
+// profileImageSource decodes the image a request asks to use for a profile
+// image. It is either uploaded as the "file" field of a multipart form, or
+// named as one of the user's media by a JSON body such as
+// {"medium_id": "..."}.
+func profileImageSource(c buffalo.Context, u *models.User) (image.Image, error) {
+	req := c.Request()
+
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, maxImageUpload)
+
+		f, _, err := req.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("missing image file")
+		}
+		defer f.Close()
+
+		return decodeImage(f)
+	}
+
+	arg := struct {
+		Medium string `json:"medium_id"`
+	}{}
+	if err := c.Bind(&arg); err != nil {
+		return nil, fmt.Errorf("malformed argument body")
+	}
+
+	id, err := uuid.FromString(arg.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("medium %s not found", arg.Medium)
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	m, err := models.GetMediumByID(tx, id, u)
+	if err != nil || m.User != u.ID {
+		return nil, fmt.Errorf("medium %s not found", arg.Medium)
+	}
+
+	if m.StorageKey == "" {
+		return nil, fmt.Errorf("medium %s is not stored on rmuse", arg.Medium)
+	}
+
+	f, err := storage.Open(m.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("medium %s is not stored on rmuse", arg.Medium)
+	}
+	defer f.Close()
+
+	return decodeImage(f)
+}
+
+func decodeImage(r io.Reader) (image.Image, error) {
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("file is not a supported image")
+	}
+
+	return img, nil
+}
+
+// removeFileOnCommit deletes the stored file key, if there is one, once the
+// request's transaction has committed.
+func removeFileOnCommit(c buffalo.Context, key string) {
+	if key == "" {
+		return
+	}
+
+	onCommit(c, func() {
+		if err := storage.Delete(key); err != nil {
+			c.Logger().Errorf("could not delete %s: %v", key, err)
+		}
+	})
+}
+
+// setProfileImage sets the current user's image of kind p.
+func setProfileImage(c buffalo.Context, p models.ProfileImage) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to set %s", p.Name))
+	}
+
+	if err := requireScope(c, models.ScopeProfileWrite); err != nil {
+		return err
+	}
+
+	img, err := profileImageSource(c, u)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	old, err := p.Set(tx, u, img)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	removeFileOnCommit(c, old)
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// removeProfileImage clears the current user's image of kind p.
+func removeProfileImage(c buffalo.Context, p models.ProfileImage) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to remove %s", p.Name))
+	}
+
+	if err := requireScope(c, models.ScopeProfileWrite); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	old, err := p.Remove(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	removeFileOnCommit(c, old)
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// UserSetAvatar sets the current user's avatar.
+func UserSetAvatar(c buffalo.Context) error {
+	return setProfileImage(c, models.AvatarImage)
+}
+
+// UserRemoveAvatar clears the current user's avatar.
+func UserRemoveAvatar(c buffalo.Context) error {
+	return removeProfileImage(c, models.AvatarImage)
+}
+
+// UserSetBanner sets the current user's profile banner.
+func UserSetBanner(c buffalo.Context) error {
+	return setProfileImage(c, models.BannerImage)
+}
+
+// UserRemoveBanner clears the current user's profile banner.
+func UserRemoveBanner(c buffalo.Context) error {
+	return removeProfileImage(c, models.BannerImage)
+}
@@ -0,0 +1,220 @@
+package actions
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+	"github.com/gobuffalo/envy"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// withStorage runs test with files stored in a scratch directory, and
+// private files in another.
+func (as *ActionSuite) withStorage(test func(dir string)) {
+	dir, err := ioutil.TempDir("", "rmuse")
+	as.NoError(err)
+	defer os.RemoveAll(dir)
+
+	private, err := ioutil.TempDir("", "rmuse-private")
+	as.NoError(err)
+	defer os.RemoveAll(private)
+
+	prev := storage.Dir()
+	envy.Set("STORAGE_DIR", dir)
+	defer envy.Set("STORAGE_DIR", prev)
+
+	prevPrivate := storage.PrivateDir()
+	envy.Set("PRIVATE_STORAGE_DIR", private)
+	defer envy.Set("PRIVATE_STORAGE_DIR", prevPrivate)
+
+	test(dir)
+}
+
+func testPNG(w, h int) []byte {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func (as *ActionSuite) uploadImage(path string, u *models.User, data []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "picture.png")
+	as.NoError(err)
+	fw.Write(data)
+	as.NoError(mw.Close())
+
+	req, err := http.NewRequest("PUT", path, body)
+	as.NoError(err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Header.Set("Authorization", ts)
+
+	res := httptest.NewRecorder()
+	as.App.ServeHTTP(res, req)
+	return res
+}
+
+// Test_Avatar_Upload crops an uploaded picture to a square avatar.
+func (as *ActionSuite) Test_Avatar_Upload() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		res := as.uploadImage("/api/1/user/avatar", u, testPNG(800, 600))
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), storage.URL("avatars/"))
+
+		u, err := models.GetUserByID(as.DB, u.ID)
+		as.NoError(err)
+		as.True(u.Avatar.Valid)
+
+		img, err := imaging.Open(filepath.Join(dir, "avatars", u.Avatar.UUID.String()+".jpg"))
+		as.NoError(err)
+		as.Equal(400, img.Bounds().Dx())
+		as.Equal(400, img.Bounds().Dy())
+
+		req := as.JSON("/api/1/user")
+		ts, err := u.CreateJWTToken()
+		as.NoError(err)
+		req.Headers["Authorization"] = ts
+		res2 := req.Get()
+		as.Contains(res2.Body.String(), models.AvatarImage.URI(u))
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Avatar_Not_Image refuses an upload that is not a picture.
+func (as *ActionSuite) Test_Avatar_Not_Image() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		res := as.uploadImage("/api/1/user/avatar", u, []byte("not a picture"))
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+		as.Contains(res.Body.String(), "file is not a supported image")
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Banner_From_Medium makes a wide banner from one of the user's media.
+func (as *ActionSuite) Test_Banner_From_Medium() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		as.NoError(storage.Put("media/sketch.png", bytes.NewReader(testPNG(600, 600))))
+		m := &models.Medium{
+			URI:        storage.URL("media/sketch.png"),
+			StorageKey: "media/sketch.png",
+			User:       u.ID,
+			Filetype:   "image/png",
+			Permission: "public",
+		}
+		verrs, err := m.Create(as.DB)
+		as.NoError(err)
+		as.False(verrs.HasAny())
+
+		arg := struct {
+			Medium string `json:"medium_id"`
+		}{
+			Medium: m.ID.String(),
+		}
+
+		req := as.JSON("/api/1/user/banner")
+		ts, err := u.CreateJWTToken()
+		as.NoError(err)
+		req.Headers["Authorization"] = ts
+		res := req.Put(arg)
+		as.Equal(http.StatusOK, res.Code)
+
+		u, err = models.GetUserByID(as.DB, u.ID)
+		as.NoError(err)
+		as.True(u.Banner.Valid)
+
+		img, err := imaging.Open(filepath.Join(dir, "banners", u.Banner.UUID.String()+".jpg"))
+		as.NoError(err)
+		as.Equal(1500, img.Bounds().Dx())
+		as.Equal(500, img.Bounds().Dy())
+
+		res = as.JSON("/api/1/user/oreo").Get()
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), models.BannerImage.URI(u))
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
+
+// Test_Banner_From_Other_Medium refuses to use media the user does not own
+// or that are not stored on rmuse.
+func (as *ActionSuite) Test_Banner_From_Other_Medium() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		m := &models.Medium{
+			URI:        "https://example.com/sketch.png",
+			User:       u.ID,
+			Filetype:   "image/png",
+			Permission: "public",
+		}
+		verrs, err := m.Create(as.DB)
+		as.NoError(err)
+		as.False(verrs.HasAny())
+
+		arg := struct {
+			Medium string `json:"medium_id"`
+		}{
+			Medium: m.ID.String(),
+		}
+
+		req := as.JSON("/api/1/user/banner")
+		ts, err := u.CreateJWTToken()
+		as.NoError(err)
+		req.Headers["Authorization"] = ts
+		res := req.Put(arg)
+		as.Equal(http.StatusUnprocessableEntity, res.Code)
+		as.Contains(res.Body.String(), "is not stored on rmuse")
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
+
+// Test_Avatar_Remove clears the avatar and deletes its file.
+func (as *ActionSuite) Test_Avatar_Remove() {
+	as.withStorage(func(dir string) {
+		u := as.createOreo()
+
+		res := as.uploadImage("/api/1/user/avatar", u, testPNG(100, 100))
+		as.Equal(http.StatusOK, res.Code)
+
+		u, err := models.GetUserByID(as.DB, u.ID)
+		as.NoError(err)
+		name := filepath.Join(dir, "avatars", u.Avatar.UUID.String()+".jpg")
+
+		req := as.JSON("/api/1/user/avatar")
+		ts, err := u.CreateJWTToken()
+		as.NoError(err)
+		req.Headers["Authorization"] = ts
+		res2 := req.Delete()
+		as.Equal(http.StatusOK, res2.Code)
+		as.Contains(res2.Body.String(), `"avatar_uri":""`)
+
+		_, err = os.Stat(name)
+		as.True(os.IsNotExist(err))
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+}
@@ -0,0 +1,415 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+)
+
+// ReportCreate reports a medium, given by medium_id, or a user, given by
+// username, to the moderators.
+func ReportCreate(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to report"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if restrictedUnverified(u, actionReport) {
+		return c.Error(http.StatusForbidden, fmt.Errorf("must verify email to report"))
+	}
+
+	type argument struct {
+		MediumID string `json:"medium_id"`
+		Username string `json:"username"`
+		Category string `json:"category"`
+		Details  string `json:"details"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if (arg.MediumID == "") == (arg.Username == "") {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("report either a medium_id or a username"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	var ru *models.User
+	var m *models.Medium
+	if arg.MediumID != "" {
+		id, err := uuid.FromString(arg.MediumID)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+		}
+
+		// only media the reporter can see can be reported
+		m, err = models.GetMediumByID(tx, id, u)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("medium not found"))
+		}
+
+		ru, err = models.GetUserByID(tx, m.User)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		var err error
+		ru, err = models.GetUserByUsername(tx, arg.Username)
+		if err != nil || ru.Deactivated() {
+			return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", arg.Username))
+		}
+	}
+
+	open, err := models.HasOpenReport(tx, u, ru, m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if open {
+		return c.Error(http.StatusConflict, fmt.Errorf("you have already reported this and it is waiting for review"))
+	}
+
+	rep := models.NewReport(u, ru, m, arg.Category, arg.Details)
+	verrs, err := rep.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return c.Render(http.StatusCreated, r.JSON(reporterView(rep)))
+}
+
+// reporterView is what a reporter sees of their report. It leaves out which
+// moderators handled it and their notes.
+func reporterView(rep *models.Report) interface{} {
+	return struct {
+		ID         uuid.UUID  `json:"id"`
+		CreatedAt  time.Time  `json:"created_at"`
+		Medium     nulls.UUID `json:"medium_id"`
+		Category   string     `json:"category"`
+		Details    string     `json:"details"`
+		Status     string     `json:"status"`
+		ResolvedAt nulls.Time `json:"resolved_at"`
+	}{
+		ID:         rep.ID,
+		CreatedAt:  rep.CreatedAt,
+		Medium:     rep.Medium,
+		Category:   rep.Category,
+		Details:    rep.Details,
+		Status:     rep.Status,
+		ResolvedAt: rep.ResolvedAt,
+	}
+}
+
+// ReportList lists the current user's reports and how they were dealt with.
+func ReportList(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list reports"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	reports, err := models.GetReportsByReporter(tx, u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := []interface{}{}
+	for i := range reports {
+		res = append(res, reporterView(&reports[i]))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// ModerationQueue lists reports, oldest first. It shows open reports unless
+// status is given, and can be narrowed by category, target (medium or user),
+// username (the reported user) and assignee, which is a moderator's
+// username, "me" or "none".
+func ModerationQueue(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	page, perPage := pageParams(c)
+
+	f := models.ReportFilter{
+		Status:   c.Param("status"),
+		Category: c.Param("category"),
+		Target:   c.Param("target"),
+	}
+	if f.Status == "" {
+		f.Status = models.ReportOpen
+	}
+
+	switch a := c.Param("assignee"); a {
+	case "":
+	case "none":
+		f.Unassigned = true
+	case "me":
+		f.AssignedTo = nulls.NewUUID(c.Value("user").(*models.User).ID)
+	default:
+		mu, err := models.GetUserByUsername(tx, a)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", a))
+		}
+		f.AssignedTo = nulls.NewUUID(mu.ID)
+	}
+
+	if username := c.Param("username"); username != "" {
+		ru, err := models.GetUserByUsername(tx, username)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", username))
+		}
+		f.User = nulls.NewUUID(ru.ID)
+	}
+
+	reports, err := models.GetReports(tx, f, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(reports))
+}
+
+// moderatedReport looks up the report named in the route.
+func moderatedReport(c buffalo.Context, tx *pop.Connection) (*models.Report, error) {
+	id, err := uuid.FromString(c.Param("id"))
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("report not found"))
+	}
+
+	rep, err := models.GetReportByID(tx, id)
+	if err != nil {
+		return nil, c.Error(http.StatusNotFound, fmt.Errorf("report not found"))
+	}
+
+	return rep, nil
+}
+
+// ReportAssign assigns a report to the moderator given by username, or to
+// the current user if none is given.
+func ReportAssign(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	rep, err := moderatedReport(c, tx)
+	if err != nil {
+		return err
+	}
+
+	type argument struct {
+		Username string `json:"username"`
+	}
+
+	arg := &argument{}
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(arg); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+		}
+	}
+
+	mu := c.Value("user").(*models.User)
+	if arg.Username != "" {
+		mu, err = models.GetUserByUsername(tx, arg.Username)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", arg.Username))
+		}
+
+		can, err := mu.HasPermission(tx, models.PermissionContentModerate)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !can {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("%s is not a moderator", mu.Username))
+		}
+	}
+
+	if err := rep.Assign(tx, mu); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(rep))
+}
+
+// ReportUnassign returns a report to the unassigned queue.
+func ReportUnassign(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	rep, err := moderatedReport(c, tx)
+	if err != nil {
+		return err
+	}
+
+	if err := rep.Assign(tx, nil); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(rep))
+}
+
+// resolveReport closes the open report named in the route with action,
+// after act has dealt with the reported user and medium, which is nil for
+// reports about accounts. The action is recorded in the audit log and the
+// reporter is told the outcome.
+func resolveReport(c buffalo.Context, action string, act func(tx *pop.Connection, u *models.User, m *models.Medium, note string) error) error {
+	tx := c.Value("tx").(*pop.Connection)
+	rep, err := moderatedReport(c, tx)
+	if err != nil {
+		return err
+	}
+
+	if !rep.Open() {
+		return c.Error(http.StatusConflict, fmt.Errorf("report has already been %s", rep.Status))
+	}
+
+	type argument struct {
+		Note string `json:"note"`
+	}
+
+	arg := &argument{}
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(arg); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+		}
+	}
+
+	// checked up front, since act may already have told the reported user
+	note := strings.TrimSpace(arg.Note)
+	if utf8.RuneCountInString(note) > models.MaxReportDetailsLength {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("note must be at most %d characters", models.MaxReportDetailsLength))
+	}
+
+	ru, err := models.GetUserByID(tx, rep.User)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var m *models.Medium
+	if rep.Medium.Valid {
+		if m, err = models.GetAnyMediumByID(tx, rep.Medium.UUID); err != nil {
+			m = nil
+		}
+	}
+
+	if err := act(tx, ru, m, note); err != nil {
+		return err
+	}
+
+	mu := c.Value("user").(*models.User)
+	verrs, err := rep.Resolve(tx, mu, action, note)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	if err := models.RecordAudit(tx, mu, action, ru, m, rep.Note); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if reporter, err := models.GetUserByID(tx, rep.Reporter); err == nil {
+		e := models.Event{Type: models.NotifyModeration, Message: rep.OutcomeMessage()}
+		if err := models.Notify(tx, reporter, e); err != nil {
+			return errors.WithStack(err)
+		}
+
+		onCommit(c, func() {
+			if err := mailers.SendReportOutcome(reporter, rep); err != nil {
+				c.Logger().Errorf("could not send report outcome to %s: %v", reporter.Username, err)
+			}
+		})
+	}
+
+	return c.Render(http.StatusOK, r.JSON(rep))
+}
+
+// ReportDismiss closes a report without acting on it.
+func ReportDismiss(c buffalo.Context) error {
+	return resolveReport(c, models.AuditDismissReport, func(tx *pop.Connection, u *models.User, m *models.Medium, note string) error {
+		return nil
+	})
+}
+
+// ReportHideMedium hides the reported medium from everyone but its owner,
+// who is notified.
+func ReportHideMedium(c buffalo.Context) error {
+	return resolveReport(c, models.AuditHideMedium, func(tx *pop.Connection, u *models.User, m *models.Medium, note string) error {
+		if m == nil {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("report is not about a medium"))
+		}
+
+		if err := m.Hide(tx); err != nil {
+			return errors.WithStack(err)
+		}
+
+		e := models.Event{
+			Type:    models.NotifyModeration,
+			Medium:  m,
+			Message: "A moderator hid one of your media after a report.",
+		}
+		return errors.WithStack(models.Notify(tx, u, e))
+	})
+}
+
+// ReportSuspendUser suspends the reported user, or the owner of the reported
+// medium. Moderators and administrators can only be suspended by an
+// administrator.
+func ReportSuspendUser(c buffalo.Context) error {
+	return resolveReport(c, models.AuditSuspend, func(tx *pop.Connection, u *models.User, m *models.Medium, note string) error {
+		for _, p := range []string{models.PermissionContentModerate, models.PermissionUsersManage, models.PermissionRolesManage} {
+			can, err := u.HasPermission(tx, p)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if can {
+				return c.Error(http.StatusForbidden, fmt.Errorf("%s is a moderator and must be suspended by an administrator", u.Username))
+			}
+		}
+
+		return errors.WithStack(u.Suspend(tx, note, nulls.Time{}))
+	})
+}
+
+// ReportWarnUser notifies the reported user, or the owner of the reported
+// medium, of a warning and emails it once the report is resolved. The note
+// is the warning and is required.
+func ReportWarnUser(c buffalo.Context) error {
+	return resolveReport(c, models.AuditWarn, func(tx *pop.Connection, u *models.User, m *models.Medium, note string) error {
+		if note == "" {
+			return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("a note is required to warn a user"))
+		}
+
+		if err := models.Notify(tx, u, models.Event{Type: models.NotifyModeration, Message: note}); err != nil {
+			return errors.WithStack(err)
+		}
+
+		onCommit(c, func() {
+			if err := mailers.SendWarning(u, note); err != nil {
+				c.Logger().Errorf("could not send warning to %s: %v", u.Username, err)
+			}
+		})
+
+		return nil
+	})
+}
@@ -0,0 +1,208 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) report(u *models.User, arg map[string]string) *models.Report {
+	res := as.authJSON(u, "/api/1/reports").Post(arg)
+	as.Equal(http.StatusCreated, res.Code)
+
+	rep := &models.Report{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), rep))
+	as.Equal(models.ReportOpen, rep.Status)
+
+	return rep
+}
+
+// Test_Report_Medium reports a medium, works it through the moderation queue
+// and hides it from everyone but its owner.
+func (as *ActionSuite) Test_Report_Medium() {
+	mod := as.createModerator()
+	raja := as.createRaja()
+
+	m := &models.Medium{
+		URI:        "https://example.com/spam.png",
+		User:       raja.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(m))
+
+	arg := map[string]string{
+		"medium_id": m.ID.String(),
+		"category":  models.ReportSpam,
+	}
+	rep := as.report(mod, arg)
+
+	res := as.authJSON(mod, "/api/1/reports").Post(arg)
+	as.Equal(http.StatusConflict, res.Code)
+
+	res = as.authJSON(raja, "/api/1/reports").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "you cannot report yourself")
+
+	res = as.authJSON(raja, "/api/1/moderation/reports").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(mod, "/api/1/moderation/reports?target=medium").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	queue := []struct {
+		ID       string `json:"id"`
+		Reporter string `json:"reporter"`
+		Username string `json:"username"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &queue))
+	as.Len(queue, 1)
+	as.Equal(rep.ID.String(), queue[0].ID)
+	as.Equal("oreo", queue[0].Reporter)
+	as.Equal("raja", queue[0].Username)
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/assign").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(mod, "/api/1/moderation/reports?assignee=none").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("[]", res.Body.String())
+
+	res = as.authJSON(mod, "/api/1/moderation/reports?assignee=me").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), rep.ID.String())
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/hide").Post(map[string]string{"note": "advertising"})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), models.ReportActioned)
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/dismiss").Post(nil)
+	as.Equal(http.StatusConflict, res.Code)
+
+	res = as.JSON("/api/1/media?id=" + m.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("[]", res.Body.String())
+
+	res = as.authJSON(raja, "/api/1/media?id="+m.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "spam.png")
+
+	res = as.authJSON(mod, "/api/1/reports").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), models.ReportActioned)
+	as.NotContains(res.Body.String(), "advertising")
+
+	entries, err := models.GetAuditEntries(as.DB, raja, 1, 10)
+	as.NoError(err)
+	as.Len(entries, 1)
+	as.Equal(models.AuditHideMedium, entries[0].Action)
+	as.Equal("advertising", entries[0].Reason)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM reports")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Report_User reports an account, warns and then suspends it, and
+// dismisses a report that needs no action.
+func (as *ActionSuite) Test_Report_User() {
+	mod := as.createModerator()
+	raja := as.createRaja()
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+	moss := &models.User{
+		FirstName:    "Moss",
+		LastName:     "Hawk",
+		Email:        "moss@example.com",
+		Username:     "moss",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(moss))
+
+	res := as.authJSON(raja, "/api/1/reports").Post(map[string]string{"username": "moss", "category": "rudeness"})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(raja, "/api/1/reports").Post(map[string]string{"username": "moss", "category": models.ReportOther})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "details are required")
+
+	rep := as.report(raja, map[string]string{"username": "moss", "category": models.ReportHarassment})
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/hide").Post(nil)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "report is not about a medium")
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/warn").Post(nil)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/warn").Post(map[string]string{"note": "keep it civil"})
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), models.AuditWarn)
+
+	rep = as.report(raja, map[string]string{"username": "moss", "category": models.ReportHarassment})
+
+	res = as.authJSON(mod, "/api/1/moderation/reports?username=moss&status=actioned").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "keep it civil")
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/suspend").Post(map[string]string{"note": "repeated harassment"})
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(moss, "/api/1/user").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "account is suspended")
+
+	rep = as.report(raja, map[string]string{"username": "moss", "category": models.ReportSpam})
+
+	res = as.authJSON(mod, "/api/1/moderation/reports/"+rep.ID.String()+"/dismiss").Post(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(raja, "/api/1/reports").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	reports := []models.Report{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &reports))
+	as.Len(reports, 3)
+
+	statuses := map[string]int{}
+	for _, rp := range reports {
+		statuses[rp.Status]++
+	}
+	as.Equal(2, statuses[models.ReportActioned])
+	as.Equal(1, statuses[models.ReportDismissed])
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM reports")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Report_Suspend_Moderator leaves suspending a moderator to the
+// administrators.
+func (as *ActionSuite) Test_Report_Suspend_Moderator() {
+	mod := as.createModerator()
+	raja := as.createRaja()
+
+	rep := as.report(raja, map[string]string{"username": "oreo", "category": models.ReportHarassment})
+
+	r, err := models.GetRoleByName(as.DB, models.RoleModerator)
+	as.NoError(err)
+	as.NoError(models.GrantRole(as.DB, raja, r, nil))
+
+	res := as.authJSON(raja, "/api/1/moderation/reports/"+rep.ID.String()+"/suspend").Post(map[string]string{"note": "retaliation"})
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.authJSON(mod, "/api/1/user").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM reports")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
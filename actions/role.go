@@ -0,0 +1,243 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/slices"
+	"github.com/pkg/errors"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// RequirePermission returns middleware that lets a request through only if
+// the current user holds perm through one of their roles. Permissions are
+// never granted to API keys or OAuth tokens, so a session token is required.
+func RequirePermission(perm string) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			u, ok := c.Value("user").(*models.User)
+
+			if !ok || u == nil {
+				return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in"))
+			}
+
+			if err := requireSession(c); err != nil {
+				return err
+			}
+
+			tx := c.Value("tx").(*pop.Connection)
+			can, err := u.HasPermission(tx, perm)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if !can {
+				return c.Error(http.StatusForbidden, fmt.Errorf("permission %s required", perm))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// AdminRoleList lists the built in and custom roles.
+func AdminRoleList(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	roles, err := models.GetRoles(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(roles))
+}
+
+// AdminRoleCreate creates a custom role.
+func AdminRoleCreate(c buffalo.Context) error {
+	type argument struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	role := &models.Role{
+		Name:        arg.Name,
+		Description: arg.Description,
+		Permissions: slices.String(arg.Permissions),
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = slices.String{}
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	verrs, err := role.Create(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return c.Render(http.StatusCreated, r.JSON(role))
+}
+
+// AdminRoleUpdate changes the description and permissions of a custom role.
+func AdminRoleUpdate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	role, err := models.GetRoleByName(tx, c.Param("role"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("role %s not found", c.Param("role")))
+	}
+
+	if role.Builtin {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("built in role %s cannot be changed", role.Name))
+	}
+
+	type argument struct {
+		Description *string   `json:"description"`
+		Permissions *[]string `json:"permissions"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if arg.Description != nil {
+		role.Description = *arg.Description
+	}
+	if arg.Permissions != nil {
+		role.Permissions = slices.String(*arg.Permissions)
+	}
+
+	verrs, err := role.Update(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(role))
+}
+
+// AdminRoleDelete deletes a custom role, revoking it from everyone.
+func AdminRoleDelete(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	role, err := models.GetRoleByName(tx, c.Param("role"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("role %s not found", c.Param("role")))
+	}
+
+	if err := models.DeleteRole(tx, role); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(role))
+}
+
+// adminRoleTarget looks up the user and role named in the route.
+func adminRoleTarget(c buffalo.Context, tx *pop.Connection) (*models.User, *models.Role, error) {
+	u, err := models.GetUserByUsername(tx, c.Param("username"))
+	if err != nil {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", c.Param("username")))
+	}
+
+	role, err := models.GetRoleByName(tx, c.Param("role"))
+	if err != nil {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("role %s not found", c.Param("role")))
+	}
+
+	return u, role, nil
+}
+
+// renderUserRoles renders the roles and permissions u holds.
+func renderUserRoles(c buffalo.Context, tx *pop.Connection, u *models.User) error {
+	names, err := u.RoleNames(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	perms, err := u.PermissionSet(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(map[string]interface{}{
+		"username":    u.Username,
+		"roles":       names,
+		"permissions": perms,
+	}))
+}
+
+// AdminUserRoles lists the roles and permissions a user holds.
+func AdminUserRoles(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := models.GetUserByUsername(tx, c.Param("username"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", c.Param("username")))
+	}
+
+	return renderUserRoles(c, tx, u)
+}
+
+// AdminRoleGrant gives a user a role.
+func AdminRoleGrant(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, role, err := adminRoleTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	admin := c.Value("user").(*models.User)
+	if err := models.GrantRole(tx, u, role, admin); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := models.RecordAudit(tx, admin, models.AuditGrantRole, u, nil, role.Name); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderUserRoles(c, tx, u)
+}
+
+// AdminRoleRevoke takes a role from a user.
+func AdminRoleRevoke(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	u, role, err := adminRoleTarget(c, tx)
+	if err != nil {
+		return err
+	}
+
+	if err := models.RevokeRole(tx, u, role); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	if err := models.RecordAudit(tx, c.Value("user").(*models.User), models.AuditRevokeRole, u, nil, role.Name); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return renderUserRoles(c, tx, u)
+}
+
+// UserRoles lists the roles and permissions the current user holds.
+func UserRoles(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list roles"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	return renderUserRoles(c, tx, u)
+}
@@ -0,0 +1,150 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/markbates/willie"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) createRaja() *models.User {
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := &models.User{
+		FirstName:    "Raja",
+		LastName:     "Hawk",
+		Email:        "clutz@example.com",
+		Username:     "raja",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(u))
+
+	return u
+}
+
+func (as *ActionSuite) createAdmin() *models.User {
+	u := as.createOreo()
+
+	r, err := models.GetRoleByName(as.DB, models.RoleAdmin)
+	as.NoError(err)
+	as.NoError(models.GrantRole(as.DB, u, r, nil))
+
+	return u
+}
+
+func (as *ActionSuite) authJSON(u *models.User, path string) *willie.JSON {
+	req := as.JSON(path)
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	return req
+}
+
+// Test_Admin_Roles_Forbidden keeps users without the permission out of the
+// admin routes.
+func (as *ActionSuite) Test_Admin_Roles_Forbidden() {
+	raja := as.createRaja()
+
+	res := as.authJSON(raja, "/api/admin/roles").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "permission roles:manage required")
+
+	res = as.JSON("/api/admin/roles").Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Admin_Role_Grant creates a custom role, grants it, and revokes it.
+func (as *ActionSuite) Test_Admin_Role_Grant() {
+	admin := as.createAdmin()
+	raja := as.createRaja()
+
+	role := struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}{
+		Name:        "curator",
+		Description: "Uploads and reviews media",
+		Permissions: []string{models.PermissionMediaUpload, models.PermissionContentModerate},
+	}
+
+	res := as.authJSON(admin, "/api/admin/roles").Post(role)
+	as.Equal(http.StatusCreated, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/users/raja/roles/curator").Put(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	held := struct {
+		Roles       []string `json:"roles"`
+		Permissions []string `json:"permissions"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &held))
+	as.Equal([]string{models.RoleListener, "curator"}, held.Roles)
+	as.Equal([]string{models.PermissionMediaUpload, models.PermissionContentModerate}, held.Permissions)
+
+	can, err := raja.HasPermission(as.DB, models.PermissionMediaUpload)
+	as.NoError(err)
+	as.True(can)
+
+	res = as.authJSON(admin, "/api/admin/users/raja/roles/curator").Delete()
+	as.Equal(http.StatusOK, res.Code)
+
+	can, err = raja.HasPermission(as.DB, models.PermissionMediaUpload)
+	as.NoError(err)
+	as.False(can)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM roles")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Admin_Role_Artist grants the built in artist role, which lets the
+// user upload media.
+func (as *ActionSuite) Test_Admin_Role_Artist() {
+	admin := as.createAdmin()
+	as.createRaja()
+
+	res := as.authJSON(admin, "/api/admin/users/raja/roles/artist").Put(nil)
+	as.Equal(http.StatusOK, res.Code)
+
+	raja, err := models.GetUserByUsername(as.DB, "raja")
+	as.NoError(err)
+	as.True(raja.Artist)
+
+	can, err := raja.HasPermission(as.DB, models.PermissionMediaUpload)
+	as.NoError(err)
+	as.True(can)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Admin_Role_Builtin refuses to change or delete built in roles, or to
+// revoke the last admin.
+func (as *ActionSuite) Test_Admin_Role_Builtin() {
+	admin := as.createAdmin()
+
+	res := as.authJSON(admin, "/api/admin/roles/moderator").Put(map[string][]string{
+		"permissions": {models.PermissionRolesManage},
+	})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/roles/admin").Delete()
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/users/oreo/roles/admin").Delete()
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "cannot revoke the last admin")
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
@@ -0,0 +1,94 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// renderSuspension refuses a suspended user's login, telling them why they
+// were suspended and for how long, and giving them a token to appeal with at
+// SuspensionAppeal.
+func renderSuspension(c buffalo.Context, u *models.User) error {
+	ts, err := u.CreateSuspensionAppealToken()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		Error          string     `json:"error"`
+		Reason         string     `json:"reason"`
+		SuspendedUntil nulls.Time `json:"suspended_until"`
+		Appealed       bool       `json:"appealed"`
+		AppealToken    string     `json:"appeal_token"`
+	}{
+		Error:          "account is suspended",
+		Reason:         u.SuspensionReason,
+		SuspendedUntil: u.SuspendedUntil,
+		Appealed:       u.SuspensionAppealedAt.Valid,
+		AppealToken:    ts,
+	}
+
+	return c.Render(http.StatusForbidden, r.JSON(res))
+}
+
+// SuspensionAppeal records a suspended user's appeal against their
+// suspension. Suspended users cannot hold a session, so it takes the appeal
+// token returned when they try to log in.
+func SuspensionAppeal(c buffalo.Context) error {
+	type argument struct {
+		AppealToken string `json:"appeal_token"`
+		Appeal      string `json:"appeal"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	claims, err := models.ParseToken(arg.AppealToken, models.TokenSuspensionAppeal)
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, err)
+	}
+
+	jti, _ := claims["jti"].(string)
+	id, err := uuid.FromString(jti)
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := models.GetUserByID(tx, id)
+	if err != nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("could not identify the user"))
+	}
+
+	if !u.Suspended() {
+		return c.Error(http.StatusConflict, fmt.Errorf("account is not suspended"))
+	}
+
+	if u.SuspensionAppealedAt.Valid {
+		return c.Error(http.StatusConflict, fmt.Errorf("suspension has already been appealed"))
+	}
+
+	if err := u.Appeal(tx, arg.Appeal); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	res := struct {
+		Appealed   bool       `json:"appealed"`
+		AppealedAt nulls.Time `json:"appealed_at"`
+	}{
+		Appealed:   true,
+		AppealedAt: u.SuspensionAppealedAt,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
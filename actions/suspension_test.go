@@ -0,0 +1,246 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// Test_Suspension_Every_Route refuses a suspended user's token on every
+// route that takes one.
+func (as *ActionSuite) Test_Suspension_Every_Route() {
+	raja := as.createRaja()
+	ts, err := raja.CreateJWTToken()
+	as.NoError(err)
+	as.NoError(raja.Suspend(as.DB, "spam", nulls.Time{}))
+
+	// routes that do not take a session token, tested on their own
+	skipped := map[string]bool{
+		"POST /api/1/login":                  true,
+		"POST /api/1/login/mfa":              true,
+		"GET /api/1/login/oidc":              true,
+		"POST /api/1/login/oidc":             true,
+		"POST /api/1/user":                   true,
+		"POST /api/1/user/verify":            true,
+		"POST /api/1/user/password/reset":    true,
+		"POST /api/1/user/suspension/appeal": true,
+		"POST /api/1/user/delete/confirm":    true,
+		"GET /api/1/export/download":         true,
+		"POST /api/1/oauth/token":            true,
+	}
+
+	params := strings.NewReplacer(
+		"{username}", raja.Username,
+		"{id}", uuid.Nil.String(),
+		"{client_id}", uuid.Nil.String(),
+		"{role}", models.RoleArtist,
+		"{tag}", "music",
+		"{key:.+}", "media/"+uuid.Nil.String()+".png",
+	)
+
+	tested := 0
+	for _, route := range as.App.Routes() {
+		path := strings.TrimSuffix(route.Path, "/")
+		name := route.Method + " " + path
+		if !(strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/uploads/")) || skipped[name] {
+			continue
+		}
+
+		req := httptest.NewRequest(route.Method, params.Replace(path), strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", ts)
+		res := httptest.NewRecorder()
+		as.App.ServeHTTP(res, req)
+
+		as.Equal(http.StatusForbidden, res.Code, name)
+		as.Contains(res.Body.String(), "account is suspended", name)
+		tested++
+	}
+	as.True(tested > len(skipped))
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Suspension_Uploads withdraws a suspended user's media and profile
+// images from everyone else.
+func (as *ActionSuite) Test_Suspension_Uploads() {
+	as.withStorage(func(dir string) {
+		oreo := as.createOreo()
+		raja := as.createUploader()
+
+		res, m := as.uploadMedium(raja, patternPNG(100, false))
+		as.Equal(http.StatusOK, res.Code)
+		key, ok := storage.KeyFromURL(m.URI)
+		as.True(ok)
+
+		res = as.uploadImage("/api/1/user/avatar", raja, testPNG(100, 100))
+		as.Equal(http.StatusOK, res.Code)
+
+		raja, err := models.GetUserByID(as.DB, raja.ID)
+		as.NoError(err)
+		avatar, ok := storage.KeyFromURL(models.AvatarImage.URI(raja))
+		as.True(ok)
+
+		as.NoError(raja.Suspend(as.DB, "spam", nulls.Time{}))
+
+		for _, k := range []string{key, avatar} {
+			res = as.JSON("/uploads/" + k).Get()
+			as.Equal(http.StatusNotFound, res.Code, k)
+
+			res = as.authJSON(oreo, "/uploads/"+k).Get()
+			as.Equal(http.StatusNotFound, res.Code, k)
+		}
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM media_tags")
+}
+
+// Test_Suspension_Login_Appeal tells a suspended user why they cannot log in,
+// lets them appeal once, and lets them back in when the suspension expires.
+func (as *ActionSuite) Test_Suspension_Login_Appeal() {
+	admin := as.createAdmin()
+	raja := as.createRaja()
+
+	arg := map[string]interface{}{
+		"reason": "spam",
+		"until":  time.Now().Add(-time.Hour),
+	}
+	res := as.authJSON(admin, "/api/admin/users/raja/suspend").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	arg["until"] = time.Now().Add(24 * time.Hour)
+	res = as.authJSON(admin, "/api/admin/users/raja/suspend").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	login := map[string]string{
+		"email":    "clutz@example.com",
+		"password": "goodpassword",
+	}
+	res = as.JSON("/api/1/login").Post(login)
+	as.Equal(http.StatusForbidden, res.Code)
+
+	refusal := struct {
+		Reason         string     `json:"reason"`
+		SuspendedUntil nulls.Time `json:"suspended_until"`
+		AppealToken    string     `json:"appeal_token"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &refusal))
+	as.Equal("spam", refusal.Reason)
+	as.True(refusal.SuspendedUntil.Valid)
+	as.NotEmpty(refusal.AppealToken)
+
+	ts, err := raja.CreateJWTToken()
+	as.NoError(err)
+	res = as.JSON("/api/1/user/suspension/appeal").Post(map[string]string{"appeal_token": ts, "appeal": "it was not spam"})
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	res = as.JSON("/api/1/user/suspension/appeal").Post(map[string]string{"appeal_token": refusal.AppealToken})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	appeal := map[string]string{
+		"appeal_token": refusal.AppealToken,
+		"appeal":       "it was not spam",
+	}
+	res = as.JSON("/api/1/user/suspension/appeal").Post(appeal)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/user/suspension/appeal").Post(appeal)
+	as.Equal(http.StatusConflict, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/appeals").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "it was not spam")
+
+	// the suspension lapses by itself once it expires
+	as.NoError(as.DB.RawQuery("UPDATE users SET suspended_until = ? WHERE id = ?", time.Now().Add(-time.Minute), raja.ID).Exec())
+
+	res = as.authJSON(raja, "/api/1/user").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/login").Post(login)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.authJSON(admin, "/api/admin/appeals").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("[]", res.Body.String())
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM audit_entries")
+}
+
+// Test_Suspension_Hides_Content withdraws a suspended user's page and media,
+// and stops their follows from counting, until they are unsuspended.
+func (as *ActionSuite) Test_Suspension_Hides_Content() {
+	oreo := as.createOreo()
+	raja := as.createRaja()
+
+	public := &models.Medium{
+		URI:        "https://example.com/public.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "public",
+	}
+	as.NoError(as.DB.Create(public))
+
+	private := &models.Medium{
+		URI:        "https://example.com/private.png",
+		User:       oreo.ID,
+		Filetype:   "image/png",
+		Permission: "follower",
+	}
+	as.NoError(as.DB.Create(private))
+	as.NoError(as.DB.Create(&models.Follow{Follower: raja.ID, Followed: oreo.ID}))
+
+	as.NoError(oreo.Suspend(as.DB, "spam", nulls.Time{}))
+
+	res := as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	res = as.authJSON(raja, "/api/1/media?id="+public.ID.String()+"&id="+private.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("[]", res.Body.String())
+
+	res = as.authJSON(raja, "/api/1/user/oreo/follow").Post(nil)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	res = as.JSON("/api/1/user/raja").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"following_count":0`)
+
+	as.NoError(oreo.Unsuspend(as.DB))
+
+	res = as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"follower_count":1`)
+	as.Contains(res.Body.String(), "public.png")
+
+	res = as.authJSON(raja, "/api/1/media?id="+private.ID.String()).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "private.png")
+
+	// a suspended follower no longer counts, or sees follower media
+	as.NoError(raja.Suspend(as.DB, "spam", nulls.Time{}))
+
+	res = as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"follower_count":0`)
+
+	res = as.authJSON(raja, "/api/1/media?id="+private.ID.String()).Get()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM follows")
+}
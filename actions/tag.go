@@ -0,0 +1,57 @@
+package actions
+
+import (
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+)
+
+// notifyMentions tells each of users that author mentioned them, in comment
+// if it is not nil, or otherwise in the caption of m. Emails are sent once
+// the request commits, and one that cannot be sent is logged rather than
+// failing the request.
+func notifyMentions(c buffalo.Context, tx *pop.Connection, author *models.User, m *models.Medium, comment *models.Comment, users []*models.User) error {
+	for _, u := range users {
+		e := models.Event{Type: models.NotifyMention, Actor: author, Medium: m, Comment: comment}
+		if err := models.Notify(tx, u, e); err != nil {
+			return err
+		}
+
+		u := u
+		onCommit(c, func() {
+			if err := mailers.SendMention(u, author, m, comment); err != nil {
+				c.Logger().Errorf("could not send mention to %s: %v", u.Username, err)
+			}
+		})
+	}
+
+	return nil
+}
+
+// TagMedia lists the media tagged with a hashtag, newest first, leaving out
+// those the current user may not see.
+func TagMedia(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	viewer, cp, err := contentViewer(c, tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	page, perPage := pageParams(c)
+	media, err := models.GetMediaByTag(tx, c.Param("tag"), viewer, cp, page, perPage)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := countLikes(c, tx, media); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(media))
+}
@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// Test_Tags_Mentions indexes hashtags and links mentions in captions and
+// comments, and lists tagged media as each viewer may see them.
+func (as *ActionSuite) Test_Tags_Mentions() {
+	oreo := as.createVerifiedOreo()
+	raja := as.createRaja()
+
+	upload := func(uri string, permission string, caption string) *models.Medium {
+		arg := map[string]string{
+			"uri":        uri,
+			"type":       "audio/mpeg",
+			"permission": permission,
+			"caption":    caption,
+		}
+		res := as.authJSON(oreo, "/api/1/media").Post(arg)
+		as.Equal(http.StatusOK, res.Code)
+
+		m := &models.Medium{}
+		as.NoError(json.Unmarshal(res.Body.Bytes(), m))
+		return m
+	}
+
+	mentions := func(u *models.User, m *models.Medium) int {
+		n, err := as.DB.Where("user_id = ? AND medium_id = ? AND removed_at IS NULL", u.ID, m.ID).Count(&models.Mention{})
+		as.NoError(err)
+		return n
+	}
+
+	public := upload("https://example.com/public.mp3", "public", "New #Song with @raja, @nobody and @oreo. #song")
+	private := upload("https://example.com/private.mp3", "follower", "#song for @raja")
+
+	as.Equal(1, mentions(raja, public))
+	as.Equal(0, mentions(oreo, public))
+
+	// raja cannot see the follower-only medium, so is not mentioned in it
+	as.Equal(0, mentions(raja, private))
+
+	res := as.JSON("/api/1/tags/SONG").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "public.mp3")
+	as.NotContains(res.Body.String(), "private.mp3")
+
+	// pages hold only what the viewer may see
+	res = as.JSON("/api/1/tags/song?per_page=1").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "public.mp3")
+
+	res = as.authJSON(oreo, "/api/1/tags/song").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "private.mp3")
+
+	res = as.JSON("/api/1/tags/jazz").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal("[]", res.Body.String())
+
+	cm := as.comment(raja, public, map[string]string{"body": "thanks @oreo! #song"})
+	as.Equal(1, mentions(oreo, public))
+
+	res = as.authJSON(raja, "/api/1/comments/"+cm.ID.String()).Put(map[string]string{"body": "thanks!"})
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal(0, mentions(oreo, public))
+
+	// putting a mention back does not tell the user again
+	res = as.authJSON(raja, "/api/1/comments/"+cm.ID.String()).Put(map[string]string{"body": "thanks @oreo!"})
+	as.Equal(http.StatusOK, res.Code)
+	as.Equal(1, mentions(oreo, public))
+
+	n, err := as.DB.Where("user_id = ? AND type = ?", oreo.ID, models.NotifyMention).Count(&models.Notification{})
+	as.NoError(err)
+	as.Equal(1, n)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM comments")
+	as.DB.RawQuery("DELETE FROM media_tags")
+	as.DB.RawQuery("DELETE FROM mentions")
+	as.DB.RawQuery("DELETE FROM notifications")
+}
@@ -0,0 +1,208 @@
+package actions
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/envy"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// AttemptStore records failed login attempts against a key, either an
+// account or a client address.
+type AttemptStore interface {
+	RecordFailure(key string) error
+	Failures(key string, since time.Time) (int, time.Time, error)
+	Reset(key string) error
+}
+
+// MemoryAttemptStore keeps failed login attempts in process memory. It is
+// suitable for a single application instance.
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+}
+
+// NewMemoryAttemptStore returns an empty MemoryAttemptStore.
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: map[string][]time.Time{},
+	}
+}
+
+// RecordFailure counts a failed attempt against key.
+func (s *MemoryAttemptStore) RecordFailure(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts[key] = append(s.attempts[key], time.Now())
+	return nil
+}
+
+// Failures returns the number of attempts counted against key since the
+// given time and when the most recent of them happened. Older attempts are
+// discarded.
+func (s *MemoryAttemptStore) Failures(key string, since time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recent := s.attempts[key][:0]
+	for _, t := range s.attempts[key] {
+		if !t.Before(since) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) == 0 {
+		delete(s.attempts, key)
+		return 0, time.Time{}, nil
+	}
+
+	s.attempts[key] = recent
+	return len(recent), recent[len(recent)-1], nil
+}
+
+// Reset forgets every attempt counted against key.
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// loginThrottle slows down repeated failed logins. Once BackoffAfter
+// failures have been counted within Window, each further attempt must wait
+// twice as long as the last, starting at BackoffBase. At LockoutAfter
+// failures the key is locked out for LockoutDuration.
+type loginThrottle struct {
+	Store           AttemptStore
+	Window          time.Duration
+	BackoffAfter    int
+	BackoffBase     time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+var throttle *loginThrottle
+
+// newLoginThrottle configures a throttle from the environment.
+// LOGIN_ATTEMPT_STORE selects "memory" (the default) or "postgres" storage.
+func newLoginThrottle() *loginThrottle {
+	var store AttemptStore = NewMemoryAttemptStore()
+	if envy.Get("LOGIN_ATTEMPT_STORE", "memory") == "postgres" {
+		store = &models.PostgresAttemptStore{DB: models.DB}
+	}
+
+	return &loginThrottle{
+		Store:           store,
+		Window:          envDuration("LOGIN_FAILURE_WINDOW", "15m"),
+		BackoffAfter:    envInt("LOGIN_BACKOFF_AFTER", "3"),
+		BackoffBase:     envDuration("LOGIN_BACKOFF_BASE", "1s"),
+		LockoutAfter:    envInt("LOGIN_LOCKOUT_AFTER", "10"),
+		LockoutDuration: envDuration("LOGIN_LOCKOUT_DURATION", "15m"),
+	}
+}
+
+// retryAfter returns how long the client must wait before another login
+// attempt for any of keys will be considered.
+func (t *loginThrottle) retryAfter(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+
+	for _, key := range keys {
+		n, last, err := t.Store.Failures(key, now.Add(-t.Window))
+		if err != nil {
+			return 0, err
+		}
+
+		var until time.Time
+		switch {
+		case n >= t.LockoutAfter:
+			until = last.Add(t.LockoutDuration)
+		case n >= t.BackoffAfter:
+			backoff := time.Duration(float64(t.BackoffBase) * math.Pow(2, float64(n-t.BackoffAfter)))
+			until = last.Add(backoff)
+		default:
+			continue
+		}
+
+		if w := until.Sub(now); w > wait {
+			wait = w
+		}
+	}
+
+	return wait, nil
+}
+
+// fail counts a refused login against keys and records it for auditing.
+// Both go through models.DB rather than the request transaction so that
+// they survive the transaction being rolled back.
+func (t *loginThrottle) fail(c buffalo.Context, email string, reason string, keys ...string) {
+	for _, key := range keys {
+		if err := t.Store.RecordFailure(key); err != nil {
+			c.Logger().Errorf("could not record failed login: %v", err)
+		}
+	}
+
+	f := &models.LoginFailure{
+		Email:  strings.ToLower(email),
+		IP:     clientIP(c),
+		Reason: reason,
+	}
+
+	if err := f.Create(models.DB); err != nil {
+		c.Logger().Errorf("could not audit failed login: %v", err)
+	}
+}
+
+// refuse renders a 429 telling the client how long to wait.
+func (t *loginThrottle) refuse(c buffalo.Context, wait time.Duration) error {
+	secs := int(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(secs))
+	return c.Error(http.StatusTooManyRequests, fmt.Errorf("too many failed login attempts, try again in %d seconds", secs))
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func addressKey(c buffalo.Context) string {
+	return "ip:" + clientIP(c)
+}
+
+// clientIP returns the address the request came from.
+func clientIP(c buffalo.Context) string {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return c.Request().RemoteAddr
+	}
+
+	return host
+}
+
+func envDuration(key string, def string) time.Duration {
+	d, err := time.ParseDuration(envy.Get(key, def))
+	if err != nil {
+		d, _ = time.ParseDuration(def)
+	}
+
+	return d
+}
+
+func envInt(key string, def string) int {
+	n, err := strconv.Atoi(envy.Get(key, def))
+	if err != nil {
+		n, _ = strconv.Atoi(def)
+	}
+
+	return n
+}
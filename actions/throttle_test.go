@@ -0,0 +1,141 @@
+package actions
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) badLogin() int {
+	arg := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		Email:    "cat@example.com",
+		Password: "badpassword",
+	}
+
+	return as.JSON("/api/1/login").Post(arg).Code
+}
+
+// Test_Login_Backoff makes a client wait once it has failed too many times.
+func (as *ActionSuite) Test_Login_Backoff() {
+	throttle.BackoffAfter = 2
+	throttle.BackoffBase = time.Minute
+
+	as.Equal(http.StatusUnprocessableEntity, as.badLogin())
+	as.Equal(http.StatusUnprocessableEntity, as.badLogin())
+
+	arg := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		Email:    "cat@example.com",
+		Password: "badpassword",
+	}
+
+	res := as.JSON("/api/1/login").Post(arg)
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.Equal("60", res.Header().Get("Retry-After"))
+	as.Contains(res.Body.String(), "too many failed login attempts")
+}
+
+// Test_Login_Lockout locks an account out even when the right password is
+// given.
+func (as *ActionSuite) Test_Login_Lockout() {
+	throttle.BackoffAfter = 100
+	throttle.LockoutAfter = 3
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := models.User{
+		FirstName:    "Oreo",
+		LastName:     "Hawk",
+		Email:        "cat@example.com",
+		Username:     "oreo",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(&u))
+
+	for i := 0; i < 3; i++ {
+		as.Equal(http.StatusUnprocessableEntity, as.badLogin())
+	}
+
+	arg := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		Email:    "cat@example.com",
+		Password: "goodpassword",
+	}
+
+	res := as.JSON("/api/1/login").Post(arg)
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.NotEqual("", res.Header().Get("Retry-After"))
+
+	failures := models.LoginFailures{}
+	as.NoError(as.DB.Where("email = ?", "cat@example.com").All(&failures))
+	as.Len(failures, 4)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Login_Success_Resets forgets an account's failures once it logs in.
+func (as *ActionSuite) Test_Login_Success_Resets() {
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := models.User{
+		FirstName:    "Oreo",
+		LastName:     "Hawk",
+		Email:        "cat@example.com",
+		Username:     "oreo",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(&u))
+
+	as.Equal(http.StatusUnprocessableEntity, as.badLogin())
+
+	arg := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		Email:    "cat@example.com",
+		Password: "goodpassword",
+	}
+
+	res := as.JSON("/api/1/login").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	n, _, err := throttle.Store.Failures(accountKey("cat@example.com"), time.Now().Add(-time.Hour))
+	as.NoError(err)
+	as.Equal(0, n)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Postgres_Attempt_Store counts and resets attempts in the database.
+func (as *ActionSuite) Test_Postgres_Attempt_Store() {
+	s := &models.PostgresAttemptStore{DB: as.DB}
+
+	as.NoError(s.RecordFailure("account:cat@example.com"))
+	as.NoError(s.RecordFailure("account:cat@example.com"))
+	as.NoError(s.RecordFailure("ip:192.0.2.1"))
+
+	n, last, err := s.Failures("account:cat@example.com", time.Now().Add(-time.Minute))
+	as.NoError(err)
+	as.Equal(2, n)
+	as.False(last.IsZero())
+
+	as.NoError(s.Reset("account:cat@example.com"))
+
+	n, _, err = s.Failures("account:cat@example.com", time.Now().Add(-time.Minute))
+	as.NoError(err)
+	as.Equal(0, n)
+
+	as.DB.RawQuery("DELETE FROM login_attempts")
+}
@@ -0,0 +1,54 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/pop"
+	"github.com/pkg/errors"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// UploadServe serves a stored file to a viewer who may see it. Media files
+// follow the medium's permission and the viewer's content preference, and
+// profile images are withdrawn with a deactivated or suspended account.
+// Nothing else in storage is served.
+func UploadServe(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+	key := c.Param("key")
+
+	viewer, cp, err := contentViewer(c, tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if strings.HasPrefix(key, "media/") {
+		m, err := models.GetMediumByKey(tx, key)
+		if err == nil {
+			m, err = models.GetMediumByID(tx, m.ID, viewer)
+		}
+
+		if err != nil || len(models.FilterMedia(models.Media{*m}, viewer, cp)) == 0 {
+			return c.Error(http.StatusNotFound, fmt.Errorf("file not found"))
+		}
+	} else {
+		u, err := models.ProfileImageOwner(tx, key)
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("file not found"))
+		}
+
+		if (viewer == nil || viewer.ID != u.ID) && (u.Deactivated() || u.Suspended()) {
+			return c.Error(http.StatusNotFound, fmt.Errorf("file not found"))
+		}
+	}
+
+	if err := storage.Serve(c.Response(), c.Request(), key); err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("file not found"))
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package actions
+
+import (
+	"net/http"
+
+	"github.com/markbates/pop/nulls"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// Test_Upload_Access serves stored files only to viewers who may see them.
+func (as *ActionSuite) Test_Upload_Access() {
+	as.withStorage(func(dir string) {
+		oreo := as.createOreo()
+		raja := as.createUploader()
+
+		res, m := as.uploadMedium(raja, patternPNG(100, false))
+		as.Equal(http.StatusOK, res.Code)
+
+		key, ok := storage.KeyFromURL(m.URI)
+		as.True(ok)
+
+		res = as.JSON("/uploads/" + key).Get()
+		as.Equal(http.StatusOK, res.Code)
+
+		as.NoError(as.DB.RawQuery("UPDATE media SET permission = 'follower' WHERE id = ?", m.ID).Exec())
+
+		res = as.JSON("/uploads/" + key).Get()
+		as.Equal(http.StatusNotFound, res.Code)
+
+		res = as.authJSON(oreo, "/uploads/"+key).Get()
+		as.Equal(http.StatusNotFound, res.Code)
+
+		res = as.authJSON(raja, "/uploads/"+key).Get()
+		as.Equal(http.StatusOK, res.Code)
+
+		res = as.uploadImage("/api/1/user/avatar", raja, testPNG(100, 100))
+		as.Equal(http.StatusOK, res.Code)
+
+		raja, err := models.GetUserByID(as.DB, raja.ID)
+		as.NoError(err)
+		avatar, ok := storage.KeyFromURL(models.AvatarImage.URI(raja))
+		as.True(ok)
+
+		res = as.JSON("/uploads/" + avatar).Get()
+		as.Equal(http.StatusOK, res.Code)
+
+		raja.DeactivatedAt = nulls.NewTime(raja.CreatedAt)
+		as.NoError(as.DB.Update(raja))
+
+		res = as.JSON("/uploads/" + avatar).Get()
+		as.Equal(http.StatusNotFound, res.Code)
+
+		res = as.JSON("/uploads/exports/" + m.ID.String() + ".zip").Get()
+		as.Equal(http.StatusNotFound, res.Code)
+	})
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM user_roles")
+	as.DB.RawQuery("DELETE FROM media")
+	as.DB.RawQuery("DELETE FROM media_tags")
+}
@@ -3,13 +3,24 @@ package actions
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/derhabicht/rmuse/models"
 	"github.com/gobuffalo/buffalo"
 	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// minPasswordLength is the shortest password accepted when an account is
+// created or its password is changed.
+const minPasswordLength = 8
+
 // UserCreate default implementation.
 func UserCreate(c buffalo.Context) error {
 	type argument struct {
@@ -17,7 +28,6 @@ func UserCreate(c buffalo.Context) error {
 		LastName  string `json:"lastname"`
 		Email     string `json:"email"`
 		Username  string `json:"username"`
-		Artist    bool   `json:"artist"`
 		Password  string `json:"password"`
 	}
 
@@ -26,6 +36,10 @@ func UserCreate(c buffalo.Context) error {
 		return c.Render(http.StatusUnprocessableEntity, r.JSON("{\"error\":\"malformed argument body\"}"))
 	}
 
+	if len(arg.Password) < minPasswordLength {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("password must be at least %d characters", minPasswordLength))
+	}
+
 	ph, err := bcrypt.GenerateFromPassword([]byte(arg.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, r.JSON("{\"error\":\"cannot hash password\"}"))
@@ -36,7 +50,6 @@ func UserCreate(c buffalo.Context) error {
 		LastName:     arg.LastName,
 		Email:        arg.Email,
 		Username:     arg.Username,
-		Artist:       arg.Artist,
 		PasswordHash: string(ph),
 	}
 
@@ -51,6 +64,8 @@ func UserCreate(c buffalo.Context) error {
 		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
 	}
 
+	sendEmailVerification(c, u)
+
 	ts, err := u.CreateJWTToken()
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, r.JSON("{\"error\":\"failed to create token\"}"))
@@ -67,6 +82,9 @@ func UserCreate(c buffalo.Context) error {
 	return c.Render(http.StatusOK, r.JSON(res))
 }
 
+// UserUpdate changes the profile fields supplied in the body, leaving the
+// rest as they are. Email, username and password changes have their own
+// endpoints, and artist status is applied for.
 func UserUpdate(c buffalo.Context) error {
 	cu, ok := c.Value("user").(*models.User)
 
@@ -74,13 +92,24 @@ func UserUpdate(c buffalo.Context) error {
 		return c.Render(http.StatusUnauthorized, r.JSON("{\"error\":\"not authorized to update user\""))
 	}
 
+	if err := requireScope(c, models.ScopeProfileWrite); err != nil {
+		return err
+	}
+
 	type argument struct {
-		FirstName string `json:"firstname"`
-		LastName  string `json:"lastname"`
-		Email     string `json:"email"`
-		Username  string `json:"username"`
-		Artist    bool   `json:"artist"`
-		Password  string `json:"password"`
+		FirstName *string `json:"firstname"`
+		LastName  *string `json:"lastname"`
+		Email     *string `json:"email"`
+		Username  *string `json:"username"`
+		Artist    *bool   `json:"artist"`
+
+		DisplayName *string   `json:"display_name"`
+		Bio         *string   `json:"bio"`
+		Location    *string   `json:"location"`
+		Website     *string   `json:"website"`
+		Links       *[]string `json:"links"`
+		Pronouns    *string   `json:"pronouns"`
+		Disciplines *[]string `json:"disciplines"`
 	}
 
 	arg := &argument{}
@@ -88,39 +117,44 @@ func UserUpdate(c buffalo.Context) error {
 		return c.Render(http.StatusUnprocessableEntity, r.JSON("{\"error\":\"malformed argument body\"}"))
 	}
 
-	ph, err := bcrypt.GenerateFromPassword([]byte(arg.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, r.JSON("{\"error\":\"cannot hash password\"}"))
+	if arg.Email != nil && strings.ToLower(*arg.Email) != cu.Email {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("email must be changed with PUT /api/1/user/email"))
 	}
 
-	u := &models.User{
-		FirstName:    arg.FirstName,
-		LastName:     arg.LastName,
-		Email:        arg.Email,
-		Username:     arg.Username,
-		Artist:       arg.Artist,
-		PasswordHash: string(ph),
+	if arg.Username != nil && models.CanonicalUsername(*arg.Username) != cu.Username {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("username must be changed with PUT /api/1/user/username"))
+	}
+
+	if arg.Artist != nil && *arg.Artist != cu.Artist {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("artist status must be applied for with POST /api/1/user/artist/application"))
 	}
 
-	if cu.FirstName != u.FirstName {
-		c.Logger().Debug(cu.FirstName)
-		c.Logger().Debug(u.FirstName)
-		cu.FirstName = u.FirstName
+	if arg.FirstName != nil {
+		cu.FirstName = *arg.FirstName
+	}
+	if arg.LastName != nil {
+		cu.LastName = *arg.LastName
+	}
+	if arg.DisplayName != nil {
+		cu.DisplayName = strings.TrimSpace(*arg.DisplayName)
 	}
-	if cu.LastName != u.LastName {
-		cu.LastName = u.LastName
+	if arg.Bio != nil {
+		cu.Bio = *arg.Bio
 	}
-	if cu.Email != u.Email {
-		cu.Email = u.Email
+	if arg.Location != nil {
+		cu.Location = strings.TrimSpace(*arg.Location)
 	}
-	if cu.Username != u.Username {
-		cu.Username = u.Username
+	if arg.Website != nil {
+		cu.Website = strings.TrimSpace(*arg.Website)
 	}
-	if cu.Artist != u.Artist {
-		cu.Artist = u.Artist
+	if arg.Links != nil {
+		cu.Links = slices.String(*arg.Links)
 	}
-	if cu.PasswordHash != u.PasswordHash {
-		cu.PasswordHash = u.PasswordHash
+	if arg.Pronouns != nil {
+		cu.Pronouns = strings.TrimSpace(*arg.Pronouns)
+	}
+	if arg.Disciplines != nil {
+		cu.Disciplines = slices.String(*arg.Disciplines)
 	}
 
 	tx := c.Value("tx").(*pop.Connection)
@@ -136,32 +170,278 @@ func UserUpdate(c buffalo.Context) error {
 	return c.Render(http.StatusOK, r.JSON(cu))
 }
 
+// UserChangePassword sets a new password after checking the current one. The
+// user's other sessions are logged out, and the caller is given a new token.
+func UserChangePassword(c buffalo.Context) error {
+	cu, ok := c.Value("user").(*models.User)
+
+	if !ok {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("not authorized to update user"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(cu.PasswordHash), []byte(arg.CurrentPassword)) != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("current password is incorrect"))
+	}
+
+	if len(arg.NewPassword) < minPasswordLength {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("password must be at least %d characters", minPasswordLength))
+	}
+
+	ph, err := bcrypt.GenerateFromPassword([]byte(arg.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Error(http.StatusInternalServerError, fmt.Errorf("cannot hash password"))
+	}
+
+	cu.PasswordHash = string(ph)
+	cu.TokensValidAfter = nulls.NewTime(time.Now())
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := tx.Update(cu); err != nil {
+		return c.Error(http.StatusInternalServerError, fmt.Errorf("failed to update password %v", err))
+	}
+
+	ts, err := cu.CreateJWTToken()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := struct {
+		Token string       `json:"token"`
+		User  *models.User `json:"user"`
+	}{
+		Token: ts,
+		User:  cu,
+	}
+
+	return c.Render(http.StatusOK, r.JSON(res))
+}
+
+// UserResetPassword sets a new password using the token from a password
+// reset email, and logs the user out everywhere else.
+func UserResetPassword(c buffalo.Context) error {
+	type argument struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if len(arg.NewPassword) < minPasswordLength {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("password must be at least %d characters", minPasswordLength))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := models.ResetPassword(tx, arg.Token, arg.NewPassword)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// UserChangeEmail moves the account to a new email address after checking
+// the user's password. The new address must be verified again.
+func UserChangeEmail(c buffalo.Context) error {
+	cu, ok := c.Value("user").(*models.User)
+
+	if !ok {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("not authorized to update user"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(cu.PasswordHash), []byte(arg.Password)) != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("password is incorrect"))
+	}
+
+	if !cu.SetEmail(arg.Email) {
+		return c.Render(http.StatusOK, r.JSON(cu))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	verrs, err := cu.Update(tx)
+	if err != nil {
+		return c.Error(http.StatusInternalServerError, fmt.Errorf("failed to update email %v", err))
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	sendEmailVerification(c, cu)
+
+	return c.Render(http.StatusOK, r.JSON(cu))
+}
+
+// UserChangeUsername gives the current user a new username.
+func UserChangeUsername(c buffalo.Context) error {
+	cu, ok := c.Value("user").(*models.User)
+
+	if !ok {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("not authorized to update user"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	type argument struct {
+		Username string `json:"username"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	if models.CanonicalUsername(arg.Username) == cu.Username {
+		return c.Render(http.StatusOK, r.JSON(cu))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+
+	ok, next, err := models.CanChangeUsername(tx, cu)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !ok {
+		if !next.IsZero() {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(time.Until(next).Seconds())+1))
+		}
+		return c.Error(http.StatusTooManyRequests, fmt.Errorf("username has been changed too many times, try again later"))
+	}
+
+	old := cu.Username
+	cu.Username = arg.Username
+
+	verrs, err := cu.Update(tx)
+	if err != nil {
+		return c.Error(http.StatusInternalServerError, fmt.Errorf("failed to update username %v", err))
+	}
+
+	if verrs.HasAny() {
+		return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+	}
+
+	if err := models.RecordUsernameChange(tx, cu, old); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(cu))
+}
+
+// UserUsernameHistory lists the usernames the current user has given up.
+func UserUsernameHistory(c buffalo.Context) error {
+	cu, ok := c.Value("user").(*models.User)
+
+	if !ok || cu == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to list username history"))
+	}
+
+	if err := requireScope(c, models.ScopeProfileRead); err != nil {
+		return err
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	h, err := models.GetUsernameHistory(tx, cu)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(h))
+}
+
 func UserRead(c buffalo.Context) error {
+	if err := requireScope(c, models.ScopeProfileRead); err != nil {
+		return err
+	}
+
 	return c.Render(http.StatusOK, r.JSON(c.Value("user")))
 }
 
+// UserPageFetch returns the public profile of the user named in the path,
+// along with their media.
 func UserPageFetch(c buffalo.Context) error {
 	username := c.Param("username")
 	tx := c.Value("tx").(*pop.Connection)
 
+	pu, err := models.ResolveUsername(tx, username)
+
+	if err != nil || pu.Deactivated() || pu.Suspended() {
+		return c.Error(http.StatusNotFound, fmt.Errorf("user %s not found", username))
+	}
+
+	// old usernames lead to the user's current page
+	if pu.Username != username {
+		return c.Redirect(http.StatusMovedPermanently, "/api/1/user/"+url.PathEscape(pu.Username))
+	}
+
+	p, err := models.GetProfile(tx, pu)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
 	m, err := models.GetMediaByUsername(tx, username)
 
 	if err != nil {
 		return c.Error(http.StatusInternalServerError, fmt.Errorf("fetch of models failed %v", err))
 	}
 
+	viewer, cp, err := contentViewer(c, tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	*m = models.FilterMedia(*m, viewer, cp)
+	if err := countLikes(c, tx, *m); err != nil {
+		return errors.WithStack(err)
+	}
+
 	u, ok := c.Value("user").(*models.User)
 
-	if !ok {
+	if !ok || !hasScope(c, models.ScopeFollowsRead) {
 		u = nil
 	}
 
-	res := struct{
-		Following bool `json:following`
-		Media     *models.Media `json:images`
+	res := struct {
+		Profile   *models.Profile `json:"profile"`
+		Following bool            `json:"following"`
+		Media     *models.Media   `json:"images"`
 	}{
-		Following: u.Follows(tx, username),
-		Media: m,
+		Profile:   p,
+		Following: u != nil && u.Follows(tx, username),
+		Media:     m,
 	}
 
 	return c.Render(http.StatusOK, r.JSON(res))
@@ -175,11 +455,19 @@ func UserFollow(c buffalo.Context) error {
 		return c.Render(http.StatusUnauthorized, r.JSON("{\"error\":\"must be logged in to follow\"}"))
 	}
 
+	if err := requireScope(c, models.ScopeFollowsWrite); err != nil {
+		return err
+	}
+
+	if restrictedUnverified(u, actionFollow) {
+		return c.Error(http.StatusForbidden, fmt.Errorf("must verify email to follow users"))
+	}
+
 	username := c.Param("username")
 	fu, err := models.GetUserByUsername(tx, username)
 
-	if err != nil {
-		emsg := struct{
+	if err != nil || fu.Deactivated() || fu.Suspended() {
+		emsg := struct {
 			Error string `json:"error"`
 		}{
 			Error: fmt.Sprintf("user %s does not exist", username),
@@ -195,7 +483,7 @@ func UserFollow(c buffalo.Context) error {
 	_, err = f.Create(tx)
 
 	if err != nil {
-		emsg := struct{
+		emsg := struct {
 			Error string `json:"error"`
 		}{
 			Error: fmt.Sprintf("unable to follow user %s", username),
@@ -203,6 +491,10 @@ func UserFollow(c buffalo.Context) error {
 		return c.Render(http.StatusInternalServerError, r.JSON(emsg))
 	}
 
+	if err := models.Notify(tx, fu, models.Event{Type: models.NotifyFollow, Actor: u}); err != nil {
+		return errors.WithStack(err)
+	}
+
 	return c.Render(http.StatusOK, r.JSON(""))
 }
 
@@ -214,6 +506,10 @@ func UserUnfollow(c buffalo.Context) error {
 		return c.Render(http.StatusUnauthorized, r.JSON("{\"error\":\"must be logged in to unfollow\"}"))
 	}
 
+	if err := requireScope(c, models.ScopeFollowsWrite); err != nil {
+		return err
+	}
+
 	username := c.Param("username")
 	fu, err := models.GetUserByUsername(tx, username)
 
@@ -1,10 +1,15 @@
 package actions
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/derhabicht/rmuse/models"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/willie"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -37,6 +42,26 @@ func (as *ActionSuite) Test_User_Create() {
 	as.DB.RawQuery("DELETE FROM users")
 }
 
+// Test_User_Create_Short_Password refuses a password shorter than the
+// minimum.
+func (as *ActionSuite) Test_User_Create_Short_Password() {
+	arg := map[string]string{
+		"firstname": "Oreo",
+		"lastname":  "Hawk",
+		"email":     "cat@example.com",
+		"username":  "oreo",
+		"password":  "short",
+	}
+
+	res := as.JSON("/api/1/user").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "password must be at least 8 characters")
+
+	count, err := as.DB.Count(&models.User{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
 func (as *ActionSuite) Test_User_Empty_Username_Create() {
 	arg := struct {
 		FirstName string `json:"firstname"`
@@ -130,12 +155,13 @@ func (as *ActionSuite) Test_User_Duplicate_Username_Create() {
 	as.NoError(err)
 
 	u := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&u)
@@ -169,12 +195,13 @@ func (as *ActionSuite) Test_User_Duplicate_Email_Create() {
 	as.NoError(err)
 
 	u := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&u)
@@ -208,12 +235,13 @@ func (as *ActionSuite) Test_User_Page_Fetch() {
 	as.NoError(err)
 
 	user := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&user)
@@ -268,12 +296,13 @@ func (as *ActionSuite) Test_User_Update() {
 	as.NoError(err)
 
 	u := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&u)
@@ -314,12 +343,13 @@ func (as *ActionSuite) Test_User_Update_Bad_Password() {
 	as.NoError(err)
 
 	u := models.User{
-		FirstName:    "Oreo",
-		LastName:     "Hawk",
-		Email:        "cat@example.com",
-		Username:     "oreo",
-		PasswordHash: string(ph),
-		UserType:     "artist",
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
 	}
 
 	err = as.DB.Create(&u)
@@ -345,3 +375,458 @@ func (as *ActionSuite) Test_User_Update_Bad_Password() {
 
 	as.DB.RawQuery("DELETE FROM users")
 }
+
+func (as *ActionSuite) createOreo() *models.User {
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := &models.User{
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		PasswordHash:     string(ph),
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
+	}
+
+	err = as.DB.Create(u)
+	as.NoError(err)
+
+	return u
+}
+
+// Test_User_Update_Partial changes only the fields given in the body.
+func (as *ActionSuite) Test_User_Update_Partial() {
+	u := as.createOreo()
+
+	arg := struct {
+		FirstName string `json:"firstname"`
+	}{
+		FirstName: "Raja",
+	}
+
+	req := as.JSON("/api/1/user")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusOK, res.Code)
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.Equal("Raja", u.FirstName)
+	as.Equal("Hawk", u.LastName)
+	as.Equal("cat@example.com", u.Email)
+	as.True(u.Artist)
+	as.NoError(bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("goodpassword")))
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Update_Legacy_Username lets a user whose username predates the
+// current rules update the rest of their profile.
+func (as *ActionSuite) Test_User_Update_Legacy_Username() {
+	u := as.createOreo()
+	as.NoError(as.DB.RawQuery("UPDATE users SET username = ? WHERE id = ?", "admin", u.ID).Exec())
+
+	u, err := models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+
+	arg := struct {
+		FirstName string `json:"firstname"`
+	}{
+		FirstName: "Raja",
+	}
+
+	req := as.JSON("/api/1/user")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusOK, res.Code)
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.Equal("Raja", u.FirstName)
+	as.Equal("admin", u.Username)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Update_Email_Rejected refuses to change the email address
+// outside of its own endpoint.
+func (as *ActionSuite) Test_User_Update_Email_Rejected() {
+	u := as.createOreo()
+
+	arg := struct {
+		Email string `json:"email"`
+	}{
+		Email: "raja@example.com",
+	}
+
+	req := as.JSON("/api/1/user")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "PUT /api/1/user/email")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Change_Password sets a new password given the current one.
+func (as *ActionSuite) Test_User_Change_Password() {
+	u := as.createOreo()
+
+	arg := struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}{
+		CurrentPassword: "goodpassword",
+		NewPassword:     "betterpassword",
+	}
+
+	req := as.JSON("/api/1/user/password")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+
+	// tokens are revoked by the second they were issued in
+	time.Sleep(time.Second)
+	res := req.Put(arg)
+
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), "password")
+
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.NoError(bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("betterpassword")))
+
+	req = as.JSON("/api/1/user")
+	req.Headers["Authorization"] = ts
+	as.Equal(http.StatusUnauthorized, req.Get().Code)
+
+	req = as.JSON("/api/1/user")
+	req.Headers["Authorization"] = body.Token
+	as.Equal(http.StatusOK, req.Get().Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Change_Password_Bad_Current refuses a password change without
+// the right current password.
+func (as *ActionSuite) Test_User_Change_Password_Bad_Current() {
+	u := as.createOreo()
+
+	arg := struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}{
+		CurrentPassword: "badpassword",
+		NewPassword:     "betterpassword",
+	}
+
+	req := as.JSON("/api/1/user/password")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "current password is incorrect")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Change_Email moves the account to a new, unverified address.
+func (as *ActionSuite) Test_User_Change_Email() {
+	u := as.createOreo()
+
+	arg := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{
+		Email:    "Raja@example.com",
+		Password: "goodpassword",
+	}
+
+	req := as.JSON("/api/1/user/email")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusOK, res.Code)
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.Equal("raja@example.com", u.Email)
+	as.False(u.EmailVerified())
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Change_Username_Taken refuses a username that is in use.
+func (as *ActionSuite) Test_User_Change_Username_Taken() {
+	u := as.createOreo()
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	raja := models.User{
+		FirstName:    "Raja",
+		LastName:     "Hawk",
+		Email:        "clutz@example.com",
+		Username:     "raja",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(&raja))
+
+	arg := struct {
+		Username string `json:"username"`
+	}{
+		Username: "raja",
+	}
+
+	req := as.JSON("/api/1/user/username")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "username raja is already in use")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Profile edits the profile and reads it back from the user's
+// public page.
+func (as *ActionSuite) Test_User_Profile() {
+	u := as.createOreo()
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	raja := models.User{
+		FirstName:    "Raja",
+		LastName:     "Hawk",
+		Email:        "clutz@example.com",
+		Username:     "raja",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(&raja))
+	as.NoError(as.DB.Create(&models.Follow{Follower: raja.ID, Followed: u.ID}))
+
+	arg := struct {
+		DisplayName string   `json:"display_name"`
+		Bio         string   `json:"bio"`
+		Location    string   `json:"location"`
+		Website     string   `json:"website"`
+		Links       []string `json:"links"`
+		Pronouns    string   `json:"pronouns"`
+		Disciplines []string `json:"disciplines"`
+	}{
+		DisplayName: "Oreo the Hawk",
+		Bio:         "Paints birds.",
+		Location:    "Provo, UT",
+		Website:     "https://oreo.example.com",
+		Links:       []string{"https://social.example.com/@oreo"},
+		Pronouns:    "she/her",
+		Disciplines: []string{"painting", "illustration"},
+	}
+
+	req := as.JSON("/api/1/user")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	page := struct {
+		Profile models.Profile `json:"profile"`
+	}{}
+	as.NoError(json.Unmarshal(res.Body.Bytes(), &page))
+	as.Equal("Oreo the Hawk", page.Profile.DisplayName)
+	as.Equal("Paints birds.", page.Profile.Bio)
+	as.Equal("https://oreo.example.com", page.Profile.Website)
+	as.Equal([]string{"https://social.example.com/@oreo"}, page.Profile.Links)
+	as.Equal("she/her", page.Profile.Pronouns)
+	as.Equal([]string{"painting", "illustration"}, page.Profile.Disciplines)
+	as.Equal(1, page.Profile.FollowerCount)
+	as.Equal(0, page.Profile.FollowingCount)
+	as.True(page.Profile.Artist)
+	as.False(page.Profile.VerifiedArtist)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM follows")
+}
+
+// Test_User_Profile_Bad_Website refuses a website that is not a web
+// address.
+func (as *ActionSuite) Test_User_Profile_Bad_Website() {
+	u := as.createOreo()
+
+	arg := struct {
+		Website string `json:"website"`
+	}{
+		Website: "javascript:alert(1)",
+	}
+
+	req := as.JSON("/api/1/user")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	res := req.Put(arg)
+
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "is not an http or https address")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_User_Page_Fetch_Unknown returns 404 for a user that does not exist.
+func (as *ActionSuite) Test_User_Page_Fetch_Unknown() {
+	res := as.JSON("/api/1/user/nobody").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) changeUsername(u *models.User, username string) *willie.Response {
+	arg := struct {
+		Username string `json:"username"`
+	}{
+		Username: username,
+	}
+
+	req := as.JSON("/api/1/user/username")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+	return req.Put(arg)
+}
+
+// Test_User_Change_Username_Redirect sends the old username's page to the
+// new one, and holds the old name for its owner.
+func (as *ActionSuite) Test_User_Change_Username_Redirect() {
+	u := as.createOreo()
+
+	res := as.changeUsername(u, "cookie")
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.JSON("/api/1/user/oreo").Get()
+	as.Equal(http.StatusMovedPermanently, res.Code)
+	as.Equal("/api/1/user/cookie", res.Header().Get("Location"))
+
+	// only the page redirects; other lookups need the current name
+	res = as.JSON("/api/1/user/oreo/likes").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	raja := &models.User{
+		FirstName:    "Raja",
+		LastName:     "Hawk",
+		Email:        "clutz@example.com",
+		Username:     "raja",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(raja))
+
+	res = as.changeUsername(raja, "oreo")
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "username oreo was recently given up")
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	res = as.changeUsername(u, "oreo")
+	as.Equal(http.StatusOK, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM username_changes")
+}
+
+// Test_User_Change_Username_Limit refuses changes past the limit for the
+// window.
+func (as *ActionSuite) Test_User_Change_Username_Limit() {
+	u := as.createOreo()
+
+	prev := envy.Get("USERNAME_CHANGE_LIMIT", "3")
+	envy.Set("USERNAME_CHANGE_LIMIT", "1")
+	defer envy.Set("USERNAME_CHANGE_LIMIT", prev)
+
+	res := as.changeUsername(u, "cookie")
+	as.Equal(http.StatusOK, res.Code)
+
+	u, err := models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	res = as.changeUsername(u, "biscuit")
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.NotEmpty(res.Header().Get("Retry-After"))
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM username_changes")
+}
+
+// Test_User_Change_Username_Policy refuses usernames that are taken in
+// another case or in look-alike characters, break the character policy, or
+// are reserved.
+func (as *ActionSuite) Test_User_Change_Username_Policy() {
+	as.createOreo()
+
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	raja := &models.User{
+		FirstName:    "Raja",
+		LastName:     "Hawk",
+		Email:        "clutz@example.com",
+		Username:     "raja",
+		PasswordHash: string(ph),
+	}
+	as.NoError(as.DB.Create(raja))
+
+	prev := envy.Get("RESERVED_USERNAMES", "")
+	envy.Set("RESERVED_USERNAMES", "admin, gallery")
+	defer envy.Set("RESERVED_USERNAMES", prev)
+
+	refused := map[string]string{
+		"OREO":      "username oreo is already in use",
+		"\u043ereo": "username \u043ereo is too similar to one already in use",
+		"0reo":      "username 0reo is too similar to one already in use",
+		"ra":        "username must be 3-30 characters long",
+		"raja/hawk": "username raja/hawk may only contain",
+		"raja_":     "username raja_ may only contain",
+		"Gallery":   "username gallery is reserved",
+		"adm1n":     "username adm1n is reserved",
+	}
+
+	for name, msg := range refused {
+		res := as.changeUsername(raja, name)
+		as.Equal(http.StatusUnprocessableEntity, res.Code, name)
+		as.Contains(res.Body.String(), msg, name)
+	}
+
+	res := as.changeUsername(raja, "Raja.Hawk")
+	as.Equal(http.StatusOK, res.Code)
+
+	u, err := models.GetUserByUsername(as.DB, "RAJA.HAWK")
+	as.NoError(err)
+	as.Equal("raja.hawk", u.Username)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM username_changes")
+}
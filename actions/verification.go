@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+
+	"github.com/derhabicht/rmuse/mailers"
+	"github.com/derhabicht/rmuse/models"
+)
+
+// Actions that can be withheld from accounts whose email address has not been
+// verified.
+const (
+	actionUpload  = "upload"
+	actionFollow  = "follow"
+	actionReport  = "report"
+	actionComment = "comment"
+)
+
+// restrictedUnverified reports whether u must verify their email address
+// before performing action. The restricted actions are listed, comma
+// separated, in UNVERIFIED_RESTRICTIONS; set it to an empty string to let
+// unverified accounts do everything.
+func restrictedUnverified(u *models.User, action string) bool {
+	if u.EmailVerified() {
+		return false
+	}
+
+	for _, a := range strings.Split(envy.Get("UNVERIFIED_RESTRICTIONS", "upload,follow"), ",") {
+		if strings.TrimSpace(a) == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sendEmailVerification mails u a verification link. Failures are logged
+// rather than returned so that an unreachable mail server does not block
+// signups; the user can ask for another link later.
+func sendEmailVerification(c buffalo.Context, u *models.User) {
+	ts, err := u.CreateEmailVerificationToken()
+	if err != nil {
+		c.Logger().Errorf("could not create verification token for %s: %v", u.Username, err)
+		return
+	}
+
+	if err := mailers.SendEmailVerification(u, ts); err != nil {
+		c.Logger().Errorf("could not send verification email to %s: %v", u.Username, err)
+	}
+}
+
+// UserVerifyEmail confirms the email address named in a verification token.
+func UserVerifyEmail(c buffalo.Context) error {
+	type argument struct {
+		Token string `json:"token"`
+	}
+
+	arg := &argument{}
+	if err := c.Bind(arg); err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("malformed argument body"))
+	}
+
+	tx := c.Value("tx").(*pop.Connection)
+	u, err := models.VerifyEmail(tx, arg.Token)
+	if err != nil {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid verification token"))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(u))
+}
+
+// UserResendVerification sends the current user a new verification link.
+func UserResendVerification(c buffalo.Context) error {
+	u, ok := c.Value("user").(*models.User)
+
+	if !ok || u == nil {
+		return c.Error(http.StatusUnauthorized, fmt.Errorf("must be logged in to verify email"))
+	}
+
+	if err := requireSession(c); err != nil {
+		return err
+	}
+
+	if u.EmailVerified() {
+		return c.Error(http.StatusUnprocessableEntity, fmt.Errorf("email is already verified"))
+	}
+
+	sendEmailVerification(c, u)
+
+	return c.Render(http.StatusOK, r.JSON(""))
+}
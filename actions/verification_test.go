@@ -0,0 +1,147 @@
+package actions
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop/nulls"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+func (as *ActionSuite) createUnverifiedArtist() *models.User {
+	ph, err := bcrypt.GenerateFromPassword([]byte("goodpassword"), bcrypt.DefaultCost)
+	as.NoError(err)
+
+	u := &models.User{
+		FirstName:        "Oreo",
+		LastName:         "Hawk",
+		Email:            "cat@example.com",
+		Username:         "oreo",
+		Artist:           true,
+		ArtistVerifiedAt: nulls.NewTime(time.Now()),
+		PasswordHash:     string(ph),
+	}
+
+	err = as.DB.Create(u)
+	as.NoError(err)
+
+	return u
+}
+
+// Test_Verify_Email confirms an email address with a valid token.
+func (as *ActionSuite) Test_Verify_Email() {
+	u := as.createUnverifiedArtist()
+
+	ts, err := u.CreateEmailVerificationToken()
+	as.NoError(err)
+
+	arg := struct {
+		Token string `json:"token"`
+	}{
+		Token: ts,
+	}
+
+	res := as.JSON("/api/1/user/verify").Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "email_verified_at")
+
+	u, err = models.GetUserByID(as.DB, u.ID)
+	as.NoError(err)
+	as.True(u.EmailVerified())
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Verify_Email_Changed rejects a token issued for a previous address.
+func (as *ActionSuite) Test_Verify_Email_Changed() {
+	u := as.createUnverifiedArtist()
+
+	ts, err := u.CreateEmailVerificationToken()
+	as.NoError(err)
+
+	u.SetEmail("raja@example.com")
+	as.NoError(as.DB.Update(u))
+
+	arg := struct {
+		Token string `json:"token"`
+	}{
+		Token: ts,
+	}
+
+	res := as.JSON("/api/1/user/verify").Post(arg)
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+	as.Contains(res.Body.String(), "invalid verification token")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Verify_Token_Not_Session makes sure a verification token cannot be
+// used to log in.
+func (as *ActionSuite) Test_Verify_Token_Not_Session() {
+	u := as.createUnverifiedArtist()
+
+	req := as.JSON("/api/1/user")
+	ts, err := u.CreateEmailVerificationToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+
+	res := req.Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Unverified_Upload attempts to upload media before verifying email.
+func (as *ActionSuite) Test_Unverified_Upload() {
+	u := as.createUnverifiedArtist()
+
+	req := as.JSON("/api/1/media")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+
+	arg := struct {
+		URI      string `json:"uri"`
+		FileType string `json:"type"`
+	}{
+		URI:      "someplace",
+		FileType: "image/png",
+	}
+
+	res := req.Post(arg)
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Body.String(), "must verify email to upload media")
+
+	as.DB.RawQuery("DELETE FROM users")
+}
+
+// Test_Unverified_Upload_Allowed uploads media when the policy does not
+// restrict unverified accounts.
+func (as *ActionSuite) Test_Unverified_Upload_Allowed() {
+	envy.Set("UNVERIFIED_RESTRICTIONS", "")
+	defer envy.Set("UNVERIFIED_RESTRICTIONS", "upload,follow")
+
+	u := as.createUnverifiedArtist()
+
+	req := as.JSON("/api/1/media")
+	ts, err := u.CreateJWTToken()
+	as.NoError(err)
+	req.Headers["Authorization"] = ts
+
+	arg := struct {
+		URI      string `json:"uri"`
+		FileType string `json:"type"`
+	}{
+		URI:      "someplace",
+		FileType: "image/png",
+	}
+
+	res := req.Post(arg)
+	as.Equal(http.StatusOK, res.Code)
+
+	as.DB.RawQuery("DELETE FROM users")
+	as.DB.RawQuery("DELETE FROM media")
+}
@@ -0,0 +1,35 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/markbates/grift/grift"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+var _ = grift.Namespace("exports", func() {
+
+	grift.Desc("purge", "Removes data exports that can no longer be downloaded")
+	grift.Add("purge", func(c *grift.Context) error {
+		exports, err := models.GetExpiredExports(models.DB)
+		if err != nil {
+			return err
+		}
+
+		for i := range exports {
+			if err := storage.Delete(exports[i].Key()); err != nil {
+				return err
+			}
+
+			if err := models.DB.Destroy(&exports[i]); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("removed %d exports\n", len(exports))
+		return nil
+	})
+
+})
@@ -0,0 +1,62 @@
+package grifts
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+
+	"github.com/markbates/grift/grift"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+var _ = grift.Namespace("media", func() {
+
+	grift.Desc("import", "Imports media for a user from a ZIP archive: media:import <username> <archive.zip> [manifest.json]")
+	grift.Add("import", func(c *grift.Context) error {
+		if len(c.Args) < 2 || len(c.Args) > 3 {
+			return fmt.Errorf("usage: media:import <username> <archive.zip> [manifest.json]")
+		}
+
+		u, err := models.GetUserByUsername(models.DB, c.Args[0])
+		if err != nil {
+			return err
+		}
+
+		z, err := zip.OpenReader(c.Args[1])
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+
+		var manifest []models.ImportItem
+		if len(c.Args) == 3 {
+			f, err := os.Open(c.Args[2])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if manifest, err = models.ParseManifest(f); err != nil {
+				return err
+			}
+		}
+
+		report, err := models.ImportMedia(models.DB, u, &z.Reader, manifest)
+		if err != nil {
+			return err
+		}
+
+		for _, res := range report.Results {
+			if res.Error != "" {
+				fmt.Printf("FAIL %s: %s\n", res.File, res.Error)
+			} else {
+				fmt.Printf("ok   %s\n", res.File)
+			}
+		}
+		fmt.Printf("imported %d, failed %d\n", report.Imported, report.Failed)
+
+		return nil
+	})
+
+})
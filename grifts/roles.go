@@ -0,0 +1,62 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/markbates/grift/grift"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// roleTarget looks up the user and role named in a grift's arguments.
+func roleTarget(c *grift.Context, usage string) (*models.User, *models.Role, error) {
+	if len(c.Args) != 2 {
+		return nil, nil, fmt.Errorf("usage: %s", usage)
+	}
+
+	u, err := models.GetUserByUsername(models.DB, c.Args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := models.GetRoleByName(models.DB, c.Args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, r, nil
+}
+
+var _ = grift.Namespace("roles", func() {
+
+	grift.Desc("grant", "Gives a user a role, for example to appoint the first admin: roles:grant <username> <role>")
+	grift.Add("grant", func(c *grift.Context) error {
+		u, r, err := roleTarget(c, "roles:grant <username> <role>")
+		if err != nil {
+			return err
+		}
+
+		if err := models.GrantRole(models.DB, u, r, nil); err != nil {
+			return err
+		}
+
+		fmt.Printf("granted %s to %s\n", r.Name, u.Username)
+		return nil
+	})
+
+	grift.Desc("revoke", "Takes a role from a user: roles:revoke <username> <role>")
+	grift.Add("revoke", func(c *grift.Context) error {
+		u, r, err := roleTarget(c, "roles:revoke <username> <role>")
+		if err != nil {
+			return err
+		}
+
+		if err := models.RevokeRole(models.DB, u, r); err != nil {
+			return err
+		}
+
+		fmt.Printf("revoked %s from %s\n", r.Name, u.Username)
+		return nil
+	})
+
+})
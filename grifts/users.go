@@ -0,0 +1,84 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/markbates/grift/grift"
+	"github.com/markbates/pop"
+
+	"github.com/derhabicht/rmuse/models"
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// deleteUser deletes u's account and files immediately.
+func deleteUser(u *models.User) error {
+	var keys []string
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		var err error
+		keys, err = models.DeleteUser(tx, u.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := storage.Delete(key); err != nil {
+			fmt.Printf("could not delete %s: %v\n", key, err)
+		}
+	}
+
+	return nil
+}
+
+var _ = grift.Namespace("users", func() {
+
+	grift.Desc("delete", "Permanently deletes the account with the given username, skipping the grace period and confirmation")
+	grift.Add("delete", func(c *grift.Context) error {
+		if len(c.Args) != 1 {
+			return fmt.Errorf("usage: users:delete <username>")
+		}
+
+		u, err := models.GetUserByUsername(models.DB, c.Args[0])
+		if err != nil {
+			return err
+		}
+
+		if err := deleteUser(u); err != nil {
+			return err
+		}
+
+		fmt.Printf("deleted %s\n", u.Username)
+		return nil
+	})
+
+	grift.Desc("purge", "Deletes accounts confirmed for deletion or deactivated past the grace period")
+	grift.Add("purge", func(c *grift.Context) error {
+		users, err := models.GetUsersDueForDeletion(models.DB)
+		if err != nil {
+			return err
+		}
+
+		for i := range users {
+			if err := deleteUser(&users[i]); err != nil {
+				return err
+			}
+
+			fmt.Printf("deleted %s\n", users[i].Username)
+		}
+
+		return nil
+	})
+
+	grift.Desc("skeletons", "Recomputes the look-alike skeletons used to keep usernames distinct")
+	grift.Add("skeletons", func(c *grift.Context) error {
+		n, err := models.RefreshUsernameSkeletons(models.DB)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("updated %d users\n", n)
+		return nil
+	})
+
+})
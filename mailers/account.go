@@ -0,0 +1,27 @@
+package mailers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendAccountDeletion sends u a link that confirms they want their account
+// deleted.
+func SendAccountDeletion(u *models.User, token string) error {
+	link := fmt.Sprintf("%s/account/delete?token=%s", appURL, url.QueryEscape(token))
+	body := fmt.Sprintf(`Hi %s,
+
+We received a request to permanently delete your rmuse account, along with
+your media and follows. To go ahead, follow the link below within the next
+hour:
+
+%s
+
+This cannot be undone. If you did not ask to delete your account, you can
+ignore this message, but you may want to change your password.
+`, u.Username, link)
+
+	return smtp.Send(newMessage(u.Email, "Confirm deletion of your rmuse account", body))
+}
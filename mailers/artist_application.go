@@ -0,0 +1,33 @@
+package mailers
+
+import (
+	"fmt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendArtistApplicationDecision tells u how their application to become an
+// artist was decided.
+func SendArtistApplicationDecision(u *models.User, a *models.ArtistApplication) error {
+	if a.Status == models.ApplicationApproved {
+		body := fmt.Sprintf(`Hi %s,
+
+Your application to become an artist on rmuse has been approved. You can now
+upload media, and your profile shows you as a verified artist.
+`, u.Username)
+
+		return smtp.Send(newMessage(u.Email, "Your rmuse artist application was approved", body))
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+Your application to become an artist on rmuse was not approved, for this
+reason:
+
+%s
+
+You are welcome to apply again once you have addressed it.
+`, u.Username, a.Reason)
+
+	return smtp.Send(newMessage(u.Email, "Your rmuse artist application was not approved", body))
+}
@@ -0,0 +1,26 @@
+package mailers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendExportReady sends u a link to download the export they asked for.
+func SendExportReady(u *models.User, token string, expires time.Time) error {
+	link := fmt.Sprintf("%s/export?token=%s", appURL, url.QueryEscape(token))
+	body := fmt.Sprintf(`Hi %s,
+
+The archive of your rmuse account is ready. It holds your profile, your
+follow lists, and your media along with their original files. You can
+download it from the link below until %s:
+
+%s
+
+If you did not ask for this archive, you may want to change your password.
+`, u.Username, expires.Format("January 2, 2006 15:04 MST"), link)
+
+	return smtp.Send(newMessage(u.Email, "Your rmuse data export is ready", body))
+}
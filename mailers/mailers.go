@@ -0,0 +1,43 @@
+package mailers
+
+import (
+	"log"
+
+	"github.com/gobuffalo/buffalo/mail"
+	"github.com/gobuffalo/envy"
+)
+
+var smtp mail.Sender
+
+// from is the sender address on every message rmuse sends.
+var from = envy.Get("MAIL_FROM", "rmuse <no-reply@rmuse.local>")
+
+// appURL is the address of the rmuse front end that links in messages point
+// to.
+var appURL = envy.Get("APP_URL", "http://127.0.0.1:3000")
+
+func init() {
+	port := envy.Get("SMTP_PORT", "1025")
+	host := envy.Get("SMTP_HOST", "localhost")
+	user := envy.Get("SMTP_USER", "")
+	password := envy.Get("SMTP_PASSWORD", "")
+
+	var err error
+	smtp, err = mail.NewSMTPSender(host, port, user, password)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newMessage(to string, subject string, body string) mail.Message {
+	m := mail.NewMessage()
+	m.From = from
+	m.To = []string{to}
+	m.Subject = subject
+	m.Bodies = append(m.Bodies, mail.Body{
+		Content:     body,
+		ContentType: "text/plain",
+	})
+
+	return m
+}
@@ -0,0 +1,25 @@
+package mailers
+
+import (
+	"fmt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendMention tells u that author mentioned them, in comment if it is not
+// nil, or otherwise in the caption of m.
+func SendMention(u *models.User, author *models.User, m *models.Medium, comment *models.Comment) error {
+	where, text := "the caption of a medium", m.Caption
+	if comment != nil {
+		where, text = "a comment", comment.Body
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+%s mentioned you in %s on rmuse:
+
+%s
+`, u.Username, author.Username, where, text)
+
+	return smtp.Send(newMessage(u.Email, fmt.Sprintf("%s mentioned you on rmuse", author.Username), body))
+}
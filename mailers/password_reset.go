@@ -0,0 +1,26 @@
+package mailers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendPasswordReset sends u a link to choose a new password, after an
+// administrator has required them to.
+func SendPasswordReset(u *models.User, token string) error {
+	link := fmt.Sprintf("%s/password/reset?token=%s", appURL, url.QueryEscape(token))
+	body := fmt.Sprintf(`Hi %s,
+
+An rmuse administrator has asked you to choose a new password, and you have
+been logged out everywhere. To set one, follow the link below within the next
+24 hours:
+
+%s
+
+Until you do, you will not be able to log in.
+`, u.Username, link)
+
+	return smtp.Send(newMessage(u.Email, "Choose a new rmuse password", body))
+}
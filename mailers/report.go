@@ -0,0 +1,45 @@
+package mailers
+
+import (
+	"fmt"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendReportOutcome tells the reporter u how their report was dealt with. It
+// does not say what was done to the reported account, only whether anything
+// was.
+func SendReportOutcome(u *models.User, r *models.Report) error {
+	if r.Status == models.ReportActioned {
+		body := fmt.Sprintf(`Hi %s,
+
+Thank you for your report. A moderator has reviewed it and taken action.
+`, u.Username)
+
+		return smtp.Send(newMessage(u.Email, "Your rmuse report has been reviewed", body))
+	}
+
+	body := fmt.Sprintf(`Hi %s,
+
+Thank you for your report. A moderator has reviewed it and found that it does
+not break the rmuse rules, so no action was taken.
+`, u.Username)
+
+	return smtp.Send(newMessage(u.Email, "Your rmuse report has been reviewed", body))
+}
+
+// SendWarning warns u that a moderator found something they posted breaks
+// the rmuse rules.
+func SendWarning(u *models.User, note string) error {
+	body := fmt.Sprintf(`Hi %s,
+
+A moderator has reviewed a report about your account and found that it breaks
+the rmuse rules:
+
+%s
+
+Further reports may lead to your account being suspended.
+`, u.Username, note)
+
+	return smtp.Send(newMessage(u.Email, "A warning about your rmuse account", body))
+}
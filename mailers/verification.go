@@ -0,0 +1,24 @@
+package mailers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/derhabicht/rmuse/models"
+)
+
+// SendEmailVerification sends u a link that confirms they own their email
+// address.
+func SendEmailVerification(u *models.User, token string) error {
+	link := fmt.Sprintf("%s/verify?token=%s", appURL, url.QueryEscape(token))
+	body := fmt.Sprintf(`Hi %s,
+
+Please confirm that this is your email address by following the link below:
+
+%s
+
+If you did not sign up for rmuse you can ignore this message.
+`, u.Username, link)
+
+	return smtp.Send(newMessage(u.Email, "Verify your rmuse email address", body))
+}
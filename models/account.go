@@ -0,0 +1,224 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+)
+
+// DeactivationGrace returns how long a deactivated account can be
+// reactivated before it is deleted for good. It is set by
+// ACCOUNT_DEACTIVATION_GRACE and defaults to 30 days.
+func DeactivationGrace() time.Duration {
+	d, err := time.ParseDuration(envy.Get("ACCOUNT_DEACTIVATION_GRACE", "720h"))
+	if err != nil {
+		return 720 * time.Hour
+	}
+
+	return d
+}
+
+// Deactivated reports whether the user has deactivated their account. The
+// profile and media of a deactivated account are hidden from everyone else.
+func (u *User) Deactivated() bool {
+	return u.DeactivatedAt.Valid
+}
+
+// DeletionRequested reports whether the user has confirmed that their
+// account should be deleted.
+func (u *User) DeletionRequested() bool {
+	return u.DeletionRequestedAt.Valid
+}
+
+// CanReactivate reports whether a deactivated account is still within its
+// grace period and has not been confirmed for deletion.
+func (u *User) CanReactivate() bool {
+	return u.Deactivated() && !u.DeletionRequested() &&
+		time.Now().Before(u.DeactivatedAt.Time.Add(DeactivationGrace()))
+}
+
+// DueForDeletion reports whether the account should now be deleted, either
+// because the user confirmed its deletion or because it has been deactivated
+// for longer than the grace period.
+func (u *User) DueForDeletion() bool {
+	return u.DeletionRequested() ||
+		(u.Deactivated() && !time.Now().Before(u.DeactivatedAt.Time.Add(DeactivationGrace())))
+}
+
+// Deactivate hides the user's account until they reactivate it.
+func (u *User) Deactivate(tx *pop.Connection) error {
+	if u.Deactivated() {
+		return nil
+	}
+
+	u.DeactivatedAt = nulls.NewTime(time.Now())
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not deactivate account %v", err)
+	}
+
+	return nil
+}
+
+// Reactivate restores a deactivated account.
+func (u *User) Reactivate(tx *pop.Connection) error {
+	if !u.CanReactivate() {
+		return fmt.Errorf("account can no longer be reactivated")
+	}
+
+	u.DeactivatedAt = nulls.Time{}
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not reactivate account %v", err)
+	}
+
+	return nil
+}
+
+// CreateAccountDeletionToken returns a token that confirms the user wants
+// their account deleted.
+func (u *User) CreateAccountDeletionToken() (string, error) {
+	exp, _ := time.ParseDuration("1h")
+	claims := jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(exp).Unix(),
+		Id:        u.ID.String(),
+		Subject:   TokenAccountDeletion,
+	}
+
+	return signToken(claims)
+}
+
+// ConfirmAccountDeletion marks the account named by a deletion token for
+// deletion. The account is hidden straight away.
+func ConfirmAccountDeletion(tx *pop.Connection, token string) (*User, error) {
+	claims, err := ParseToken(token, TokenAccountDeletion)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := claims["jti"].(string)
+	id, err := uuid.FromString(jti)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify the user")
+	}
+
+	u, err := GetUserByID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !u.Deactivated() {
+		u.DeactivatedAt = nulls.NewTime(now)
+	}
+	if !u.DeletionRequested() {
+		u.DeletionRequestedAt = nulls.NewTime(now)
+	}
+
+	if err := tx.Update(u); err != nil {
+		return nil, fmt.Errorf("could not confirm deletion %v", err)
+	}
+
+	return u, nil
+}
+
+// GetUsersDueForDeletion returns the accounts that have been confirmed for
+// deletion or deactivated for longer than the grace period.
+func GetUsersDueForDeletion(tx *pop.Connection) (Users, error) {
+	u := Users{}
+	q := tx.Where("deletion_requested_at IS NOT NULL OR deactivated_at < ?", time.Now().Add(-DeactivationGrace()))
+
+	if err := q.All(&u); err != nil {
+		return nil, fmt.Errorf("could not find accounts due for deletion %v", err)
+	}
+
+	return u, nil
+}
+
+// DeleteUser permanently removes the user with the given id, their follows in
+// both directions, their media and everything else they own. It returns the
+// storage keys of the user's files, which the caller should delete once the
+// transaction has committed. The audit log and reports about the user are
+// kept; open reports are closed.
+func DeleteUser(tx *pop.Connection, id uuid.UUID) ([]string, error) {
+	u, err := GetUserByID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	media := Media{}
+	if err := tx.Where("user_id = ?", u.ID).All(&media); err != nil {
+		return nil, fmt.Errorf("could not find media %v", err)
+	}
+
+	keys := []string{}
+	for _, m := range media {
+		if m.StorageKey != "" {
+			keys = append(keys, m.StorageKey)
+		}
+	}
+	if u.Avatar.Valid {
+		keys = append(keys, AvatarImage.key(u.Avatar.UUID))
+	}
+	if u.Banner.Valid {
+		keys = append(keys, BannerImage.key(u.Banner.UUID))
+	}
+
+	exports := Exports{}
+	if err := tx.Where("user_id = ?", u.ID).All(&exports); err != nil {
+		return nil, fmt.Errorf("could not find exports %v", err)
+	}
+
+	for _, e := range exports {
+		keys = append(keys, e.Key())
+	}
+
+	deletes := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM follows WHERE follower = ? OR followed = ?", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM likes WHERE user_id = ? OR medium_id IN (SELECT id FROM media WHERE user_id = ?)", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM like_notices WHERE user_id = ? OR medium_id IN (SELECT id FROM media WHERE user_id = ?)", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM mentions WHERE user_id = ? OR author_id = ? OR medium_id IN (SELECT id FROM media WHERE user_id = ?) OR comment_id IN (SELECT id FROM comments WHERE parent_id IN (SELECT id FROM comments WHERE user_id = ?))", []interface{}{u.ID, u.ID, u.ID, u.ID}},
+		{"UPDATE notifications SET comment_id = NULL WHERE comment_id IN (SELECT id FROM comments WHERE user_id = ? OR parent_id IN (SELECT id FROM comments WHERE user_id = ?))", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM comments WHERE user_id = ? OR medium_id IN (SELECT id FROM media WHERE user_id = ?) OR parent_id IN (SELECT id FROM comments WHERE user_id = ?)", []interface{}{u.ID, u.ID, u.ID}},
+		{"DELETE FROM media_tags WHERE medium_id IN (SELECT id FROM media WHERE user_id = ?)", []interface{}{u.ID}},
+		{"DELETE FROM notifications WHERE user_id = ? OR medium_id IN (SELECT id FROM media WHERE user_id = ?)", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM notification_preferences WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM media WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM exports WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM recovery_codes WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM api_keys WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM identities WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM username_changes WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM user_roles WHERE user_id = ?", []interface{}{u.ID}},
+		{"UPDATE user_roles SET granted_by = NULL WHERE granted_by = ?", []interface{}{u.ID}},
+		{"DELETE FROM artist_applications WHERE user_id = ?", []interface{}{u.ID}},
+		{"UPDATE artist_applications SET reviewed_by = NULL WHERE reviewed_by = ?", []interface{}{u.ID}},
+		{"DELETE FROM reports WHERE reporter_id = ?", []interface{}{u.ID}},
+		{"UPDATE reports SET status = ?, action = ?, note = ?, resolved_at = ? WHERE user_id = ? AND status = ?", []interface{}{ReportDismissed, AuditDismissReport, "account deleted", time.Now(), u.ID, ReportOpen}},
+		{"UPDATE reports SET assigned_to = NULL WHERE assigned_to = ?", []interface{}{u.ID}},
+		{"UPDATE reports SET resolved_by = NULL WHERE resolved_by = ?", []interface{}{u.ID}},
+		{"DELETE FROM content_preferences WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM oauth_codes WHERE user_id = ? OR client_id IN (SELECT id FROM oauth_clients WHERE user_id = ?)", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM oauth_grants WHERE user_id = ? OR client_id IN (SELECT id FROM oauth_clients WHERE user_id = ?)", []interface{}{u.ID, u.ID}},
+		{"DELETE FROM oauth_clients WHERE user_id = ?", []interface{}{u.ID}},
+		{"DELETE FROM login_failures WHERE email = ?", []interface{}{u.Email}},
+	}
+
+	for _, d := range deletes {
+		if err := tx.RawQuery(d.query, d.args...).Exec(); err != nil {
+			return nil, fmt.Errorf("could not delete account data %v", err)
+		}
+	}
+
+	if err := tx.Destroy(u); err != nil {
+		return nil, fmt.Errorf("could not delete user %v", err)
+	}
+
+	return keys, nil
+}
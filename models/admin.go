@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/markbates/pop"
+	"github.com/satori/go.uuid"
+)
+
+// SearchUsers returns a page of the users whose username, email or display
+// name contains q, newest first. An empty q matches everyone.
+func SearchUsers(tx *pop.Connection, q string, page int, perPage int) (Users, error) {
+	query := tx.Order("created_at desc").Paginate(page, perPage)
+
+	if q = strings.TrimSpace(q); q != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(q)) + "%"
+		query = query.Where("username LIKE ? OR email LIKE ? OR lower(display_name) LIKE ?", like, like, like)
+	}
+
+	users := Users{}
+	if err := query.All(&users); err != nil {
+		return nil, fmt.Errorf("could not search users %v", err)
+	}
+
+	return users, nil
+}
+
+// GetAllMediaByUser returns every medium u has, whatever its permission.
+func GetAllMediaByUser(tx *pop.Connection, u *User) (Media, error) {
+	media := Media{}
+	if err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&media); err != nil {
+		return nil, fmt.Errorf("could not find media %v", err)
+	}
+
+	return media, nil
+}
+
+// GetAnyMediumByID returns the medium with the given id regardless of who
+// may see it.
+func GetAnyMediumByID(tx *pop.Connection, id uuid.UUID) (*Medium, error) {
+	m := Medium{}
+	if err := tx.Find(&m, id); err != nil {
+		return nil, fmt.Errorf("could not find media %v", err)
+	}
+
+	return &m, nil
+}
+
+// RemoveMedium deletes m and returns the storage key of its file, if it is
+// stored on rmuse, so that the file can be removed once the deletion is
+// committed.
+func RemoveMedium(tx *pop.Connection, m *Medium) (string, error) {
+	if err := tx.RawQuery("DELETE FROM likes WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return "", fmt.Errorf("could not remove likes %v", err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM like_notices WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return "", fmt.Errorf("could not remove likes %v", err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM comments WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return "", fmt.Errorf("could not remove comments %v", err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM mentions WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return "", fmt.Errorf("could not remove mentions %v", err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM media_tags WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return "", fmt.Errorf("could not remove tags %v", err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM notifications WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return "", fmt.Errorf("could not remove notifications %v", err)
+	}
+
+	if err := tx.Destroy(m); err != nil {
+		return "", fmt.Errorf("could not remove medium %v", err)
+	}
+
+	return m.StorageKey, nil
+}
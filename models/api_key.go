@@ -0,0 +1,229 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// APIKeyPrefix starts every API key so that keys can be told apart from
+// session tokens, and spotted if they leak.
+const APIKeyPrefix = "rmk_"
+
+// Scopes an API key can be granted.
+const (
+	ScopeMediaRead          = "media:read"
+	ScopeMediaWrite         = "media:write"
+	ScopeFollowsRead        = "follows:read"
+	ScopeFollowsWrite       = "follows:write"
+	ScopeProfileRead        = "profile:read"
+	ScopeProfileWrite       = "profile:write"
+	ScopeLikesRead          = "likes:read"
+	ScopeLikesWrite         = "likes:write"
+	ScopeCommentsRead       = "comments:read"
+	ScopeCommentsWrite      = "comments:write"
+	ScopeNotificationsRead  = "notifications:read"
+	ScopeNotificationsWrite = "notifications:write"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []string{
+	ScopeMediaRead,
+	ScopeMediaWrite,
+	ScopeFollowsRead,
+	ScopeFollowsWrite,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeLikesRead,
+	ScopeLikesWrite,
+	ScopeCommentsRead,
+	ScopeCommentsWrite,
+	ScopeNotificationsRead,
+	ScopeNotificationsWrite,
+}
+
+// APIKey is a long-lived credential a user creates for scripts and
+// integrations. Only a hash of the key is stored; Prefix identifies it.
+type APIKey struct {
+	ID         uuid.UUID     `json:"id"           db:"id"`
+	CreatedAt  time.Time     `json:"created_at"   db:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"   db:"updated_at"`
+	User       uuid.UUID     `json:"-"            db:"user_id"`
+	Name       string        `json:"name"         db:"name"`
+	Prefix     string        `json:"prefix"       db:"prefix"`
+	KeyHash    string        `json:"-"            db:"key_hash"`
+	Scopes     slices.String `json:"scopes"       db:"scopes"`
+	ExpiresAt  nulls.Time    `json:"expires_at"   db:"expires_at"`
+	LastUsedAt nulls.Time    `json:"last_used_at" db:"last_used_at"`
+}
+
+// NewAPIKey generates a key for u and returns it along with the plaintext
+// key, which is not stored.
+func NewAPIKey(u *User, name string, scopes []string, expiresAt nulls.Time) (*APIKey, string, error) {
+	prefix := make([]byte, 4)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, "", fmt.Errorf("could not generate api key %v", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("could not generate api key %v", err)
+	}
+
+	k := &APIKey{
+		User:      u.ID,
+		Name:      name,
+		Prefix:    APIKeyPrefix + hex.EncodeToString(prefix),
+		Scopes:    slices.String(scopes),
+		ExpiresAt: expiresAt,
+	}
+
+	key := k.Prefix + "_" + hex.EncodeToString(secret)
+	k.KeyHash = hashSecret(key)
+
+	return k, key, nil
+}
+
+func (k *APIKey) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(k)
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AuthenticateAPIKey looks up the key and the user it belongs to, recording
+// that it was used.
+func AuthenticateAPIKey(tx *pop.Connection, key string) (*APIKey, *User, error) {
+	i := strings.LastIndex(key, "_")
+	if !strings.HasPrefix(key, APIKeyPrefix) || i <= len(APIKeyPrefix) {
+		return nil, nil, fmt.Errorf("malformed api key")
+	}
+
+	k := APIKey{}
+	err := tx.Where("prefix = ?", key[:i]).First(&k)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not find api key %v", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hashSecret(key))) != 1 {
+		return nil, nil, fmt.Errorf("could not find api key")
+	}
+
+	if k.ExpiresAt.Valid && k.ExpiresAt.Time.Before(time.Now()) {
+		return nil, nil, fmt.Errorf("api key has expired")
+	}
+
+	u, err := GetUserByID(tx, k.User)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k.LastUsedAt = nulls.NewTime(time.Now())
+	if err := tx.Update(&k); err != nil {
+		return nil, nil, fmt.Errorf("could not update api key %v", err)
+	}
+
+	return &k, u, nil
+}
+
+// GetAPIKeysByUser returns every key belonging to u.
+func GetAPIKeysByUser(tx *pop.Connection, u *User) (*APIKeys, error) {
+	k := APIKeys{}
+	err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&k)
+	if err != nil {
+		return nil, fmt.Errorf("could not find api keys %v", err)
+	}
+
+	return &k, nil
+}
+
+// RevokeAPIKey deletes u's key with the given id.
+func RevokeAPIKey(tx *pop.Connection, u *User, id uuid.UUID) error {
+	k := APIKey{}
+	err := tx.Where("id = ? AND user_id = ?", id, u.ID).First(&k)
+	if err != nil {
+		return fmt.Errorf("could not find api key %v", err)
+	}
+
+	return tx.Destroy(&k)
+}
+
+// String is not required by pop and may be deleted
+func (k APIKey) String() string {
+	jk, _ := json.Marshal(k)
+	return string(jk)
+}
+
+// APIKeys is not required by pop and may be deleted
+type APIKeys []APIKey
+
+// String is not required by pop and may be deleted
+func (k APIKeys) String() string {
+	jk, _ := json.Marshal(k)
+	return string(jk)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (k *APIKey) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   k.Name,
+			Name:    "Name",
+			Message: "name is empty",
+			Fn: func() bool {
+				return k.Name != ""
+			},
+		},
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "Scopes",
+			Message: "at least one scope is required",
+			Fn: func() bool {
+				return len(k.Scopes) > 0
+			},
+		},
+		&validators.FuncValidator{
+			Field:   strings.Join(k.Scopes, ", "),
+			Name:    "Scopes",
+			Message: "unknown scope in %s",
+			Fn: func() bool {
+				for _, s := range k.Scopes {
+					if !ValidScope(s) {
+						return false
+					}
+				}
+				return true
+			},
+		},
+	), nil
+}
+
+// ValidScope reports whether scope is one of the known scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// Limits on what goes into an artist application.
+const (
+	MaxStatementLength       = 2000
+	MaxApplicationLinks      = 10
+	MaxRejectionReasonLength = 1000
+)
+
+// States an artist application can be in.
+const (
+	ApplicationPending  = "pending"
+	ApplicationApproved = "approved"
+	ApplicationRejected = "rejected"
+)
+
+// ArtistApplication is a user's request to be made an artist, with links to
+// their work and a statement, waiting for or bearing a reviewer's decision.
+type ArtistApplication struct {
+	ID         uuid.UUID     `json:"id"          db:"id"`
+	CreatedAt  time.Time     `json:"created_at"  db:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"  db:"updated_at"`
+	User       uuid.UUID     `json:"-"           db:"user_id"`
+	Links      slices.String `json:"links"       db:"links"`
+	Statement  string        `json:"statement"   db:"statement"`
+	Status     string        `json:"status"      db:"status"`
+	Reason     string        `json:"reason"      db:"reason"`
+	ReviewedBy nulls.UUID    `json:"-"           db:"reviewed_by"`
+	ReviewedAt nulls.Time    `json:"reviewed_at" db:"reviewed_at"`
+}
+
+// NewArtistApplication starts an application for u.
+func NewArtistApplication(u *User, links []string, statement string) *ArtistApplication {
+	l := slices.String{}
+	for _, link := range links {
+		if link = strings.TrimSpace(link); link != "" {
+			l = append(l, link)
+		}
+	}
+
+	return &ArtistApplication{
+		User:      u.ID,
+		Links:     l,
+		Statement: strings.TrimSpace(statement),
+		Status:    ApplicationPending,
+	}
+}
+
+func (a *ArtistApplication) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(a)
+}
+
+// Pending reports whether the application is waiting for review.
+func (a *ArtistApplication) Pending() bool {
+	return a.Status == ApplicationPending
+}
+
+// Approve accepts the application and makes its applicant an artist.
+func (a *ArtistApplication) Approve(tx *pop.Connection, reviewer *User) error {
+	if !a.Pending() {
+		return fmt.Errorf("application has already been %s", a.Status)
+	}
+
+	u, err := GetUserByID(tx, a.User)
+	if err != nil {
+		return err
+	}
+
+	a.Status = ApplicationApproved
+	a.ReviewedBy = nulls.NewUUID(reviewer.ID)
+	a.ReviewedAt = nulls.NewTime(time.Now())
+	if err := tx.Update(a); err != nil {
+		return fmt.Errorf("could not approve application %v", err)
+	}
+
+	return GrantRole(tx, u, builtinRole(RoleArtist), reviewer)
+}
+
+// DecisionMessage tells the applicant how their application was decided.
+func (a *ArtistApplication) DecisionMessage() string {
+	if a.Status == ApplicationApproved {
+		return "Your application to become an artist has been approved."
+	}
+
+	return "Your application to become an artist was not approved: " + a.Reason
+}
+
+// Reject turns the application down for the given reason.
+func (a *ArtistApplication) Reject(tx *pop.Connection, reviewer *User, reason string) (*validate.Errors, error) {
+	if !a.Pending() {
+		return nil, fmt.Errorf("application has already been %s", a.Status)
+	}
+
+	a.Status = ApplicationRejected
+	a.Reason = strings.TrimSpace(reason)
+	a.ReviewedBy = nulls.NewUUID(reviewer.ID)
+	a.ReviewedAt = nulls.NewTime(time.Now())
+
+	return tx.ValidateAndUpdate(a)
+}
+
+// GetArtistApplicationByID returns the application with the given id.
+func GetArtistApplicationByID(tx *pop.Connection, id uuid.UUID) (*ArtistApplication, error) {
+	a := ArtistApplication{}
+	err := tx.Find(&a, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find application %v", err)
+	}
+
+	return &a, nil
+}
+
+// GetArtistApplicationsByUser returns u's applications, newest first.
+func GetArtistApplicationsByUser(tx *pop.Connection, u *User) (ArtistApplications, error) {
+	a := ArtistApplications{}
+	err := tx.Where("user_id = ?", u.ID).Order("created_at desc").All(&a)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find applications %v", err)
+	}
+
+	return a, nil
+}
+
+// HasPendingArtistApplication reports whether u has an application waiting
+// for review.
+func HasPendingArtistApplication(tx *pop.Connection, u *User) (bool, error) {
+	q := tx.Where("user_id = ? AND status = ?", u.ID, ApplicationPending)
+	return q.Exists(&ArtistApplication{})
+}
+
+// GetPendingArtistApplications returns the review queue, oldest first.
+func GetPendingArtistApplications(tx *pop.Connection) (ArtistApplications, error) {
+	a := ArtistApplications{}
+	err := tx.Where("status = ?", ApplicationPending).Order("created_at").All(&a)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find applications %v", err)
+	}
+
+	return a, nil
+}
+
+// String is not required by pop and may be deleted
+func (a ArtistApplication) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// ArtistApplications is not required by pop and may be deleted
+type ArtistApplications []ArtistApplication
+
+// String is not required by pop and may be deleted
+func (a ArtistApplications) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (a *ArtistApplication) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "Statement",
+			Message: "statement is empty",
+			Fn: func() bool {
+				return a.Statement != ""
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxStatementLength),
+			Name:    "Statement",
+			Message: "statement must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(a.Statement) <= MaxStatementLength
+			},
+		},
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "Links",
+			Message: "at least one portfolio link is required",
+			Fn: func() bool {
+				return len(a.Links) > 0
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxApplicationLinks),
+			Name:    "Links",
+			Message: "at most %s portfolio links are allowed",
+			Fn: func() bool {
+				return len(a.Links) <= MaxApplicationLinks
+			},
+		},
+		&validators.FuncValidator{
+			Field:   strings.Join(a.Links, ", "),
+			Name:    "Links",
+			Message: "portfolio links %s must all be http or https addresses",
+			Fn: func() bool {
+				for _, l := range a.Links {
+					if !validWebURL(l) || strings.Contains(l, ",") {
+						return false
+					}
+				}
+				return true
+			},
+		},
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "Reason",
+			Message: "a reason is required to reject an application",
+			Fn: func() bool {
+				return a.Status != ApplicationRejected || a.Reason != ""
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxRejectionReasonLength),
+			Name:    "Reason",
+			Message: "reason must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(a.Reason) <= MaxRejectionReasonLength
+			},
+		},
+	), nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditSuspend           = "suspend"
+	AuditUnsuspend         = "unsuspend"
+	AuditPasswordReset     = "password_reset"
+	AuditRemoveMedium      = "remove_medium"
+	AuditImpersonate       = "impersonate"
+	AuditGrantRole         = "grant_role"
+	AuditRevokeRole        = "revoke_role"
+	AuditDismissReport     = "dismiss_report"
+	AuditHideMedium        = "hide_medium"
+	AuditWarn              = "warn"
+	AuditBlockHash         = "block_hash"
+	AuditUnblockHash       = "unblock_hash"
+	AuditBlockedUpload     = "blocked_upload"
+	AuditQuarantinedUpload = "quarantined_upload"
+	AuditReleaseUpload     = "release_upload"
+	AuditRejectUpload      = "reject_upload"
+)
+
+// AuditEntry records something an administrator or moderator did to an
+// account or a medium, and why. Uploads stopped by the blocklist are
+// recorded with the uploader as the actor.
+type AuditEntry struct {
+	ID        uuid.UUID  `json:"id"         db:"id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"-"          db:"updated_at"`
+	Actor     uuid.UUID  `json:"actor_id"   db:"actor_id"`
+	Action    string     `json:"action"     db:"action"`
+	User      nulls.UUID `json:"user_id"    db:"user_id"`
+	Medium    nulls.UUID `json:"medium_id"  db:"medium_id"`
+	Reason    string     `json:"reason"     db:"reason"`
+}
+
+// RecordAudit notes that actor took action against u or m, either of which
+// may be nil.
+func RecordAudit(tx *pop.Connection, actor *User, action string, u *User, m *Medium, reason string) error {
+	e := &AuditEntry{
+		Actor:  actor.ID,
+		Action: action,
+		Reason: reason,
+	}
+	if u != nil {
+		e.User = nulls.NewUUID(u.ID)
+	}
+	if m != nil {
+		e.Medium = nulls.NewUUID(m.ID)
+	}
+
+	if err := tx.Create(e); err != nil {
+		return fmt.Errorf("could not record audit entry %v", err)
+	}
+
+	return nil
+}
+
+// GetAuditEntries returns a page of the audit log, newest first, limited to
+// entries about u if it is not nil.
+func GetAuditEntries(tx *pop.Connection, u *User, page int, perPage int) (AuditEntries, error) {
+	q := tx.Order("created_at desc").Paginate(page, perPage)
+	if u != nil {
+		q = q.Where("user_id = ? OR actor_id = ?", u.ID, u.ID)
+	}
+
+	e := AuditEntries{}
+	if err := q.All(&e); err != nil {
+		return nil, fmt.Errorf("could not find audit entries %v", err)
+	}
+
+	return e, nil
+}
+
+// String is not required by pop and may be deleted
+func (e AuditEntry) String() string {
+	je, _ := json.Marshal(e)
+	return string(je)
+}
+
+// AuditEntries is not required by pop and may be deleted
+type AuditEntries []AuditEntry
+
+// String is not required by pop and may be deleted
+func (e AuditEntries) String() string {
+	je, _ := json.Marshal(e)
+	return string(je)
+}
@@ -0,0 +1,331 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// MaxBlockReasonLength limits the reason a moderator gives for blocking an
+// image.
+const MaxBlockReasonLength = 2000
+
+// What happens to an upload that matches a blocked image.
+const (
+	HashReject     = "reject"
+	HashQuarantine = "quarantine"
+)
+
+// HashMatchDistance is how many bits an image's perceptual hash may differ
+// from a blocked hash and still match it.
+func HashMatchDistance() int {
+	n, err := strconv.Atoi(envy.Get("HASH_MATCH_DISTANCE", "8"))
+	if err != nil || n < 0 {
+		return 8
+	}
+
+	return n
+}
+
+// HashMatchAction is what happens to uploads that match a blocked image:
+// they are either rejected outright, or quarantined by being created hidden
+// for the moderators to review.
+func HashMatchAction() string {
+	if envy.Get("HASH_MATCH_ACTION", HashReject) == HashQuarantine {
+		return HashQuarantine
+	}
+
+	return HashReject
+}
+
+// ImageHash is a 64 bit perceptual hash of an image. Similar images have
+// hashes that differ in few bits. It is written as 16 hex digits.
+type ImageHash int64
+
+// PerceptualHash computes the difference hash of img, which survives
+// rescaling, recompression and small edits.
+func PerceptualHash(img image.Image) ImageHash {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+
+	var h uint64
+	for y := 0; y < 8; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if row[x*4] < row[(x+1)*4] {
+				h |= 1
+			}
+		}
+	}
+
+	return ImageHash(h)
+}
+
+// ParseImageHash reads a hash written as 16 hex digits.
+func ParseImageHash(s string) (ImageHash, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("hash must be 16 hex digits")
+	}
+
+	h, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("hash must be 16 hex digits")
+	}
+
+	return ImageHash(h), nil
+}
+
+// Distance is the number of bits in which h and o differ.
+func (h ImageHash) Distance(o ImageHash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+func (h ImageHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// MarshalText writes the hash as hex.
+func (h ImageHash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText reads a hash written as hex.
+func (h *ImageHash) UnmarshalText(text []byte) error {
+	p, err := ParseImageHash(string(text))
+	if err != nil {
+		return err
+	}
+
+	*h = p
+	return nil
+}
+
+// BlockedHash is the perceptual hash of an image that may not be uploaded,
+// usually taken from content the moderators have removed.
+type BlockedHash struct {
+	ID        uuid.UUID  `json:"id"         db:"id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"-"          db:"updated_at"`
+	Hash      ImageHash  `json:"hash"       db:"hash"`
+	AddedBy   uuid.UUID  `json:"added_by"   db:"added_by"`
+	Medium    nulls.UUID `json:"medium_id"  db:"medium_id"`
+	Reason    string     `json:"reason"     db:"reason"`
+}
+
+// String is not required by pop and may be deleted
+func (b BlockedHash) String() string {
+	jb, _ := json.Marshal(b)
+	return string(jb)
+}
+
+// BlockedHashes is not required by pop and may be deleted
+type BlockedHashes []BlockedHash
+
+// String is not required by pop and may be deleted
+func (b BlockedHashes) String() string {
+	jb, _ := json.Marshal(b)
+	return string(jb)
+}
+
+// NewBlockedHash blocks images like the one hashed as h. The medium it was
+// taken from, if any, is kept for reference.
+func NewBlockedHash(mod *User, h ImageHash, m *Medium, reason string) *BlockedHash {
+	b := &BlockedHash{
+		Hash:    h,
+		AddedBy: mod.ID,
+		Reason:  strings.TrimSpace(reason),
+	}
+	if m != nil {
+		b.Medium = nulls.NewUUID(m.ID)
+	}
+
+	return b
+}
+
+func (b *BlockedHash) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(b)
+}
+
+// Delete takes the hash off the blocklist.
+func (b *BlockedHash) Delete(tx *pop.Connection) error {
+	if err := tx.Destroy(b); err != nil {
+		return fmt.Errorf("could not delete blocked hash %v", err)
+	}
+
+	return nil
+}
+
+func GetBlockedHashByID(tx *pop.Connection, id uuid.UUID) (*BlockedHash, error) {
+	b := BlockedHash{}
+	err := tx.Find(&b, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find blocked hash %v", err)
+	}
+
+	return &b, nil
+}
+
+// GetBlockedHashes returns a page of the blocklist, newest first.
+func GetBlockedHashes(tx *pop.Connection, page int, perPage int) (BlockedHashes, error) {
+	b := BlockedHashes{}
+	if err := tx.Order("created_at desc").Paginate(page, perPage).All(&b); err != nil {
+		return nil, fmt.Errorf("could not find blocked hashes %v", err)
+	}
+
+	return b, nil
+}
+
+// HashMatch is an upload found to be like a blocked image.
+type HashMatch struct {
+	Blocked  *BlockedHash
+	Distance int
+}
+
+// MatchBlockedHash returns the blocked hash closest to h, if one is within
+// HashMatchDistance, or nil.
+func MatchBlockedHash(tx *pop.Connection, h ImageHash) (*HashMatch, error) {
+	blocked := BlockedHashes{}
+	if err := tx.All(&blocked); err != nil {
+		return nil, fmt.Errorf("could not find blocked hashes %v", err)
+	}
+
+	var match *HashMatch
+	for i, b := range blocked {
+		d := h.Distance(b.Hash)
+		if d <= HashMatchDistance() && (match == nil || d < match.Distance) {
+			match = &HashMatch{Blocked: &blocked[i], Distance: d}
+		}
+	}
+
+	return match, nil
+}
+
+// Record notes the match in the audit log against the uploader u, and m if
+// the upload was kept.
+func (hm *HashMatch) Record(tx *pop.Connection, u *User, m *Medium) error {
+	action := AuditBlockedUpload
+	if m != nil {
+		action = AuditQuarantinedUpload
+	}
+
+	reason := fmt.Sprintf("matched blocked hash %s at distance %d", hm.Blocked.ID, hm.Distance)
+	return RecordAudit(tx, u, action, u, m, reason)
+}
+
+// GetHashMatches returns a page of the uploads the blocklist has stopped,
+// newest first.
+func GetHashMatches(tx *pop.Connection, page int, perPage int) (AuditEntries, error) {
+	q := tx.Where("action IN (?, ?)", AuditBlockedUpload, AuditQuarantinedUpload)
+
+	e := AuditEntries{}
+	if err := q.Order("created_at desc").Paginate(page, perPage).All(&e); err != nil {
+		return nil, fmt.Errorf("could not find hash matches %v", err)
+	}
+
+	return e, nil
+}
+
+// Quarantined reports whether m is hidden because its upload was
+// quarantined, and has not been released since.
+func (m *Medium) Quarantined(tx *pop.Connection) (bool, error) {
+	if !m.Hidden() {
+		return false, nil
+	}
+
+	q := tx.Where("medium_id = ? AND action IN (?, ?)", m.ID, AuditQuarantinedUpload, AuditReleaseUpload)
+
+	e := AuditEntries{}
+	if err := q.Order("created_at desc").Limit(1).All(&e); err != nil {
+		return false, fmt.Errorf("could not find quarantine %v", err)
+	}
+
+	return len(e) > 0 && e[0].Action == AuditQuarantinedUpload, nil
+}
+
+// Release lets everyone who may see a quarantined medium see it again.
+func (m *Medium) Release(tx *pop.Connection) error {
+	m.HiddenAt = nulls.Time{}
+	if err := tx.Update(m); err != nil {
+		return fmt.Errorf("could not release medium %v", err)
+	}
+
+	return nil
+}
+
+// StoredImage decodes the medium's file, if it is an image stored on rmuse.
+func (m *Medium) StoredImage() (image.Image, error) {
+	if !strings.HasPrefix(m.Filetype, "image/") {
+		return nil, fmt.Errorf("medium is not an image")
+	}
+
+	if m.StorageKey == "" {
+		return nil, fmt.Errorf("medium is not stored on rmuse")
+	}
+
+	f, err := storage.Open(m.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("medium is not stored on rmuse")
+	}
+	defer f.Close()
+
+	img, err := imaging.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("medium is not a supported image")
+	}
+
+	return img, nil
+}
+
+// Screen hashes the medium's image and checks it against the blocklist,
+// returning the match if there is one. Media that are not images stored on
+// rmuse cannot be hashed and always pass.
+func (m *Medium) Screen(tx *pop.Connection) (*HashMatch, error) {
+	img, err := m.StoredImage()
+	if err != nil {
+		return nil, nil
+	}
+
+	h := PerceptualHash(img)
+	m.PerceptualHash = nulls.NewInt64(int64(h))
+
+	return MatchBlockedHash(tx, h)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (b *BlockedHash) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{
+			Name:    "Hash",
+			Message: "hash is already blocked",
+			Fn: func() bool {
+				n, err := tx.Where("hash = ?", b.Hash).Count(&BlockedHash{})
+				return err == nil && n == 0
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxBlockReasonLength),
+			Name:    "Reason",
+			Message: "reason must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(b.Reason) <= MaxBlockReasonLength
+			},
+		},
+	), nil
+}
@@ -0,0 +1,223 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// MaxCommentLength limits the length of a comment.
+const MaxCommentLength = 2000
+
+// Comment is a remark on a medium, or a reply to one. Replies cannot be
+// replied to, so threads are one level deep.
+type Comment struct {
+	ID         uuid.UUID  `json:"id"          db:"id"`
+	CreatedAt  time.Time  `json:"created_at"  db:"created_at"`
+	UpdatedAt  time.Time  `json:"-"           db:"updated_at"`
+	Medium     uuid.UUID  `json:"medium_id"   db:"medium_id"`
+	User       uuid.UUID  `json:"-"           db:"user_id"`
+	Parent     nulls.UUID `json:"parent_id"   db:"parent_id"`
+	Body       string     `json:"body"        db:"body"`
+	EditedAt   nulls.Time `json:"edited_at"   db:"edited_at"`
+	Username   string     `json:"username"    db:"-"`
+	ReplyCount int        `json:"reply_count" db:"-"`
+}
+
+// String is not required by pop and may be deleted
+func (c Comment) String() string {
+	jc, _ := json.Marshal(c)
+	return string(jc)
+}
+
+// Comments is not required by pop and may be deleted
+type Comments []Comment
+
+// String is not required by pop and may be deleted
+func (c Comments) String() string {
+	jc, _ := json.Marshal(c)
+	return string(jc)
+}
+
+// CommentPage is a page of comments, and the cursor to fetch the next page
+// with if there is one.
+type CommentPage struct {
+	Comments   Comments `json:"comments"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// NewComment is u's comment on m, or their reply to parent if it is not nil.
+func NewComment(u *User, m *Medium, parent *Comment, body string) *Comment {
+	c := &Comment{
+		Medium:   m.ID,
+		User:     u.ID,
+		Body:     strings.TrimSpace(body),
+		Username: u.Username,
+	}
+	if parent != nil {
+		c.Parent = nulls.NewUUID(parent.ID)
+	}
+
+	return c
+}
+
+func (c *Comment) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(c)
+}
+
+// Edit replaces the comment's body and marks it as edited.
+func (c *Comment) Edit(tx *pop.Connection, body string) (*validate.Errors, error) {
+	c.Body = strings.TrimSpace(body)
+	c.EditedAt = nulls.NewTime(time.Now())
+
+	return tx.ValidateAndUpdate(c)
+}
+
+// Delete removes the comment along with any replies to it and their
+// mentions.
+func (c *Comment) Delete(tx *pop.Connection) error {
+	if err := tx.RawQuery("DELETE FROM mentions WHERE comment_id IN (SELECT id FROM comments WHERE id = ? OR parent_id = ?)", c.ID, c.ID).Exec(); err != nil {
+		return fmt.Errorf("could not delete mentions %v", err)
+	}
+
+	if err := tx.RawQuery("UPDATE notifications SET comment_id = NULL WHERE comment_id IN (SELECT id FROM comments WHERE id = ? OR parent_id = ?)", c.ID, c.ID).Exec(); err != nil {
+		return fmt.Errorf("could not update notifications %v", err)
+	}
+
+	if err := tx.RawQuery("DELETE FROM comments WHERE id = ? OR parent_id = ?", c.ID, c.ID).Exec(); err != nil {
+		return fmt.Errorf("could not delete comment %v", err)
+	}
+
+	return nil
+}
+
+// Reply reports whether the comment is a reply to another.
+func (c *Comment) Reply() bool {
+	return c.Parent.Valid
+}
+
+func GetCommentByID(tx *pop.Connection, id uuid.UUID) (*Comment, error) {
+	c := Comment{}
+	err := tx.Find(&c, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find comment %v", err)
+	}
+
+	if u, err := GetUserByID(tx, c.User); err == nil {
+		c.Username = u.Username
+	}
+
+	return &c, nil
+}
+
+// GetComments returns a page of the comments on m, oldest first, or of the
+// replies to parent if it is not nil. The page starts after cursor, if it is
+// not nil. Comments by deactivated or suspended accounts are left out.
+func GetComments(tx *pop.Connection, m *Medium, parent *Comment, cursor *Cursor, limit int) (*CommentPage, error) {
+	now := time.Now()
+
+	// replies are counted for the whole medium at once, and joined in
+	query := "SELECT c.*, u.username, COALESCE(r.count, 0) AS reply_count FROM comments c " +
+		"JOIN users u ON u.id = c.user_id " +
+		"LEFT JOIN (SELECT parent_id, COUNT(*) AS count FROM comments WHERE medium_id = ? AND parent_id IS NOT NULL AND user_id NOT IN (" + hiddenAuthors + ") GROUP BY parent_id) r ON r.parent_id = c.id " +
+		"WHERE c.medium_id = ? AND c.user_id NOT IN (" + hiddenAuthors + ")"
+	args := []interface{}{m.ID, now, m.ID, now}
+
+	if parent == nil {
+		query += " AND c.parent_id IS NULL"
+	} else {
+		query += " AND c.parent_id = ?"
+		args = append(args, parent.ID)
+	}
+	if cursor != nil {
+		query += " AND (c.created_at, c.id) > (?, ?)"
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	}
+
+	// one more than the page, to tell whether there is another
+	query += " ORDER BY c.created_at, c.id LIMIT ?"
+	args = append(args, limit+1)
+
+	rows := []struct {
+		Comment
+		Username   string `db:"username"`
+		ReplyCount int    `db:"reply_count"`
+	}{}
+	if err := tx.RawQuery(query, args...).All(&rows); err != nil {
+		return nil, fmt.Errorf("could not find comments %v", err)
+	}
+
+	comments := Comments{}
+	for _, row := range rows {
+		c := row.Comment
+		c.Username = row.Username
+		c.ReplyCount = row.ReplyCount
+		comments = append(comments, c)
+	}
+
+	page := &CommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		last := page.Comments[limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+	}
+
+	return page, nil
+}
+
+// SetCommentsDisabled stops or allows new comments on the medium.
+func (m *Medium) SetCommentsDisabled(tx *pop.Connection, disabled bool) error {
+	m.CommentsDisabled = disabled
+	if err := tx.Update(m); err != nil {
+		return fmt.Errorf("could not change comment settings %v", err)
+	}
+
+	return nil
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (c *Comment) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{
+			Name:    "Body",
+			Message: "comment cannot be empty",
+			Fn: func() bool {
+				return c.Body != ""
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxCommentLength),
+			Name:    "Body",
+			Message: "comments must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(c.Body) <= MaxCommentLength
+			},
+		},
+		&validators.FuncValidator{
+			Name:    "Parent",
+			Message: "replies must be to a comment on the same medium that is not itself a reply",
+			Fn: func() bool {
+				if !c.Parent.Valid {
+					return true
+				}
+
+				p := Comment{}
+				if err := tx.Find(&p, c.Parent.UUID); err != nil {
+					return false
+				}
+
+				return p.Medium == c.Medium && !p.Reply()
+			},
+		},
+	), nil
+}
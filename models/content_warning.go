@@ -0,0 +1,251 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// MaxContentWarningTextLength limits the custom warning an artist can give.
+const MaxContentWarningTextLength = 200
+
+// Content warnings an artist can put on a medium. WarningCustom is not set
+// directly; a medium carries it when it has a custom warning text.
+const (
+	WarningNudity         = "nudity"
+	WarningViolence       = "violence"
+	WarningFlashingLights = "flashing_lights"
+	WarningCustom         = "custom"
+)
+
+// ContentWarnings lists the warnings an artist can choose from.
+var ContentWarnings = []string{
+	WarningNudity,
+	WarningViolence,
+	WarningFlashingLights,
+}
+
+// matureWarning reports whether media carrying warning are kept from viewers
+// who have not confirmed their age, whatever their preferences.
+func matureWarning(warning string) bool {
+	return warning == WarningNudity || warning == WarningViolence
+}
+
+// How a viewer wants media with a content warning shown, from least to most
+// strict.
+const (
+	ContentShow = "show"
+	ContentBlur = "blur"
+	ContentHide = "hide"
+)
+
+// contentModes ranks the modes by strictness.
+var contentModes = map[string]int{
+	ContentShow: 0,
+	ContentBlur: 1,
+	ContentHide: 2,
+}
+
+// ContentPreference is how a viewer wants media shown for each content
+// warning. Users who have not set one get DefaultContentPreference.
+type ContentPreference struct {
+	ID             uuid.UUID `json:"-"               db:"id"`
+	CreatedAt      time.Time `json:"-"               db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"      db:"updated_at"`
+	User           uuid.UUID `json:"-"               db:"user_id"`
+	Nudity         string    `json:"nudity"          db:"nudity"`
+	Violence       string    `json:"violence"        db:"violence"`
+	FlashingLights string    `json:"flashing_lights" db:"flashing_lights"`
+	Custom         string    `json:"custom"          db:"custom"`
+}
+
+// DefaultContentPreference blurs media with any content warning.
+func DefaultContentPreference() *ContentPreference {
+	return &ContentPreference{
+		Nudity:         ContentBlur,
+		Violence:       ContentBlur,
+		FlashingLights: ContentBlur,
+		Custom:         ContentBlur,
+	}
+}
+
+// GetContentPreference returns u's content preference, or the default if u
+// is nil or has not set one.
+func GetContentPreference(tx *pop.Connection, u *User) (*ContentPreference, error) {
+	p := DefaultContentPreference()
+	if u == nil {
+		return p, nil
+	}
+
+	set := []ContentPreference{}
+	if err := tx.Where("user_id = ?", u.ID).All(&set); err != nil {
+		return nil, fmt.Errorf("could not find content preference %v", err)
+	}
+
+	if len(set) > 0 {
+		return &set[0], nil
+	}
+
+	p.User = u.ID
+	return p, nil
+}
+
+// Save creates or updates the preference.
+func (p *ContentPreference) Save(tx *pop.Connection) (*validate.Errors, error) {
+	if p.ID == uuid.Nil {
+		return tx.ValidateAndCreate(p)
+	}
+
+	return tx.ValidateAndUpdate(p)
+}
+
+// mode returns how the viewer wants media carrying warning shown.
+func (p *ContentPreference) mode(warning string) string {
+	switch warning {
+	case WarningNudity:
+		return p.Nudity
+	case WarningViolence:
+		return p.Violence
+	case WarningFlashingLights:
+		return p.FlashingLights
+	}
+
+	return p.Custom
+}
+
+// hiddenWarnings returns the warnings that keep a medium from viewer, who may
+// be nil, outright.
+func (p *ContentPreference) hiddenWarnings(viewer *User) []string {
+	hidden := []string{}
+	for _, w := range append(ContentWarnings, WarningCustom) {
+		if (matureWarning(w) && (viewer == nil || !viewer.AgeConfirmed())) || p.mode(w) == ContentHide {
+			hidden = append(hidden, w)
+		}
+	}
+
+	return hidden
+}
+
+// AgeConfirmed reports whether the user has confirmed they are old enough to
+// see mature media.
+func (u *User) AgeConfirmed() bool {
+	return u.AgeConfirmedAt.Valid
+}
+
+// ConfirmAge records that u has confirmed they are old enough to see mature
+// media, or withdraws the confirmation.
+func (u *User) ConfirmAge(tx *pop.Connection, confirmed bool) error {
+	u.AgeConfirmedAt = nulls.Time{}
+	if confirmed {
+		u.AgeConfirmedAt = nulls.NewTime(time.Now())
+	}
+
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not confirm age %v", err)
+	}
+
+	return nil
+}
+
+// Warnings returns the content warnings the medium carries, including
+// WarningCustom if it has a custom warning text.
+func (m *Medium) Warnings() []string {
+	w := append([]string{}, m.ContentWarnings...)
+	if m.ContentWarningText != "" {
+		w = append(w, WarningCustom)
+	}
+
+	return w
+}
+
+// viewMode returns how the medium is shown to viewer, who may be nil, given
+// their content preference. Owners always see their own media.
+func (m *Medium) viewMode(viewer *User, p *ContentPreference) string {
+	if viewer != nil && viewer.ID == m.User {
+		return ContentShow
+	}
+
+	mode := ContentShow
+	for _, w := range m.Warnings() {
+		if matureWarning(w) && (viewer == nil || !viewer.AgeConfirmed()) {
+			return ContentHide
+		}
+
+		if pm := p.mode(w); contentModes[pm] > contentModes[mode] {
+			mode = pm
+		}
+	}
+
+	return mode
+}
+
+// FilterMedia applies viewer's content preference to media. Media they want
+// hidden are left out, and media they want blurred are marked Blurred. The
+// viewer may be nil for someone who is not logged in.
+func FilterMedia(media Media, viewer *User, p *ContentPreference) Media {
+	filtered := Media{}
+	for _, m := range media {
+		switch m.viewMode(viewer, p) {
+		case ContentHide:
+			continue
+		case ContentBlur:
+			m.Blurred = true
+		}
+		filtered = append(filtered, m)
+	}
+
+	return filtered
+}
+
+// validContentWarnings reports whether every warning is one of
+// ContentWarnings.
+func validContentWarnings(warnings []string) bool {
+	for _, w := range warnings {
+		known := false
+		for _, cw := range ContentWarnings {
+			if w == cw {
+				known = true
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String is not required by pop and may be deleted
+func (p ContentPreference) String() string {
+	jp, _ := json.Marshal(p)
+	return string(jp)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (p *ContentPreference) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	modes := []*string{&p.Nudity, &p.Violence, &p.FlashingLights, &p.Custom}
+
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   strings.Join([]string{ContentShow, ContentBlur, ContentHide}, ", "),
+			Name:    "Mode",
+			Message: "preferences must each be one of %s",
+			Fn: func() bool {
+				for _, m := range modes {
+					if _, ok := contentModes[*m]; !ok {
+						return false
+					}
+				}
+				return true
+			},
+		},
+	), nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Cursor marks a place in a list ordered by creation time, so that the next
+// page can start after it even as items are added. Clients treat it as an
+// opaque string.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// ParseCursor reads a cursor written by Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(b), "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &Cursor{CreatedAt: at, ID: id}, nil
+}
+
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.Format(time.RFC3339Nano) + "/" + c.ID.String()))
+}
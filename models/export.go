@@ -0,0 +1,294 @@
+package models
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// States an export moves through.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Export is an archive of everything a user has put on rmuse, built in the
+// background at their request.
+type Export struct {
+	ID        uuid.UUID  `json:"id"         db:"id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	User      uuid.UUID  `json:"-"          db:"user_id"`
+	Status    string     `json:"status"     db:"status"`
+	ExpiresAt nulls.Time `json:"expires_at" db:"expires_at"`
+}
+
+// ExportTTL returns how long a finished export can be downloaded. It is set
+// by EXPORT_TTL and defaults to a week.
+func ExportTTL() time.Duration {
+	d, err := time.ParseDuration(envy.Get("EXPORT_TTL", "168h"))
+	if err != nil {
+		return 168 * time.Hour
+	}
+
+	return d
+}
+
+// Key returns the storage key of the export's archive. It is private, so the
+// archive can only be fetched through a download token.
+func (e *Export) Key() string {
+	return storage.PrivatePrefix + "exports/" + e.ID.String() + ".zip"
+}
+
+// Expired reports whether the export can no longer be downloaded.
+func (e *Export) Expired() bool {
+	return e.ExpiresAt.Valid && !time.Now().Before(e.ExpiresAt.Time)
+}
+
+// CreateDownloadToken returns a token that allows the export to be
+// downloaded until it expires.
+func (e *Export) CreateDownloadToken() (string, error) {
+	claims := jwt.StandardClaims{
+		ExpiresAt: e.ExpiresAt.Time.Unix(),
+		Id:        e.ID.String(),
+		Subject:   TokenExportDownload,
+	}
+
+	return signToken(claims)
+}
+
+// GetExportByDownloadToken returns the finished, unexpired export a download
+// token was issued for.
+func GetExportByDownloadToken(tx *pop.Connection, token string) (*Export, error) {
+	claims, err := ParseToken(token, TokenExportDownload)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := claims["jti"].(string)
+	id, err := uuid.FromString(jti)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify the export")
+	}
+
+	e, err := GetExportByID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Status != ExportReady || e.Expired() {
+		return nil, fmt.Errorf("export is not available")
+	}
+
+	return e, nil
+}
+
+// GetExportByID returns the export with the given id.
+func GetExportByID(tx *pop.Connection, id uuid.UUID) (*Export, error) {
+	e := Export{}
+	err := tx.Find(&e, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find export %v", err)
+	}
+
+	return &e, nil
+}
+
+// GetExportsByUser returns u's exports, newest first.
+func GetExportsByUser(tx *pop.Connection, u *User) (Exports, error) {
+	e := Exports{}
+	err := tx.Where("user_id = ?", u.ID).Order("created_at desc").All(&e)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find exports %v", err)
+	}
+
+	return e, nil
+}
+
+// ExportTimeout returns how long an export may stay pending before its job is
+// taken to have been lost. It is set by EXPORT_TIMEOUT and defaults to an
+// hour.
+func ExportTimeout() time.Duration {
+	d, err := time.ParseDuration(envy.Get("EXPORT_TIMEOUT", "1h"))
+	if err != nil {
+		return time.Hour
+	}
+
+	return d
+}
+
+// HasPendingExport reports whether an export for u is still being built.
+// Exports pending for longer than ExportTimeout are marked failed first, so
+// that a lost job does not stop u from asking for another.
+func HasPendingExport(tx *pop.Connection, u *User) (bool, error) {
+	now := time.Now()
+	q := "UPDATE exports SET status = ?, updated_at = ? WHERE user_id = ? AND status = ? AND created_at < ?"
+	if err := tx.RawQuery(q, ExportFailed, now, u.ID, ExportPending, now.Add(-ExportTimeout())).Exec(); err != nil {
+		return false, fmt.Errorf("could not expire exports %v", err)
+	}
+
+	return tx.Where("user_id = ? AND status = ?", u.ID, ExportPending).Exists(&Export{})
+}
+
+// GetExpiredExports returns the exports whose archives can be removed.
+func GetExpiredExports(tx *pop.Connection) (Exports, error) {
+	e := Exports{}
+	err := tx.Where("expires_at < ?", time.Now()).All(&e)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find exports %v", err)
+	}
+
+	return e, nil
+}
+
+// ExportedMedium is an entry in the media list of an export. File is the
+// path of the medium's original within the archive, if it is stored on
+// rmuse.
+type ExportedMedium struct {
+	Medium
+	File string `json:"file,omitempty"`
+}
+
+// WriteExport writes a ZIP archive of u's profile, follows, likes, comments,
+// media and the original files of their media to w.
+func WriteExport(tx *pop.Connection, u *User, w io.Writer) error {
+	z := zip.NewWriter(w)
+
+	p, err := GetProfile(tx, u)
+	if err != nil {
+		return err
+	}
+
+	profile := struct {
+		User    *User    `json:"user"`
+		Profile *Profile `json:"profile"`
+	}{
+		User:    u,
+		Profile: p,
+	}
+	if err := writeJSON(z, "profile.json", profile); err != nil {
+		return err
+	}
+
+	followers, err := GetFollowers(tx, u)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(z, "followers.json", followers); err != nil {
+		return err
+	}
+
+	following, err := GetFollowing(tx, u)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(z, "following.json", following); err != nil {
+		return err
+	}
+
+	likes := Likes{}
+	if err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&likes); err != nil {
+		return fmt.Errorf("could not list likes %v", err)
+	}
+	if err := writeJSON(z, "likes.json", likes); err != nil {
+		return err
+	}
+
+	comments := Comments{}
+	if err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&comments); err != nil {
+		return fmt.Errorf("could not list comments %v", err)
+	}
+	if err := writeJSON(z, "comments.json", comments); err != nil {
+		return err
+	}
+
+	media := Media{}
+	if err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&media); err != nil {
+		return fmt.Errorf("could not list media %v", err)
+	}
+
+	exported := []ExportedMedium{}
+	for _, m := range media {
+		em := ExportedMedium{Medium: m}
+
+		if m.StorageKey != "" {
+			name := "media/" + m.ID.String() + path.Ext(m.StorageKey)
+			added, err := copyStored(z, name, m.StorageKey)
+			if err != nil {
+				return err
+			}
+			if added {
+				em.File = name
+			}
+		}
+
+		exported = append(exported, em)
+	}
+	if err := writeJSON(z, "media.json", exported); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+func writeJSON(z *zip.Writer, name string, v interface{}) error {
+	f, err := z.Create(name)
+	if err != nil {
+		return fmt.Errorf("could not add %s to export %v", name, err)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// copyStored adds the stored file key to the archive as name. Files that are
+// missing from storage are left out.
+func copyStored(z *zip.Writer, name string, key string) (bool, error) {
+	r, err := storage.Open(key)
+	if err != nil {
+		return false, nil
+	}
+	defer r.Close()
+
+	f, err := z.Create(name)
+	if err != nil {
+		return false, fmt.Errorf("could not add %s to export %v", name, err)
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		return false, fmt.Errorf("could not add %s to export %v", name, err)
+	}
+
+	return true, nil
+}
+
+// String is not required by pop and may be deleted
+func (e Export) String() string {
+	je, _ := json.Marshal(e)
+	return string(je)
+}
+
+// Exports is not required by pop and may be deleted
+type Exports []Export
+
+// String is not required by pop and may be deleted
+func (e Exports) String() string {
+	je, _ := json.Marshal(e)
+	return string(je)
+}
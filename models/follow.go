@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/markbates/pop"
@@ -25,6 +26,34 @@ func (f *Follow) Delete(tx *pop.Connection) error {
 	return tx.Destroy(f)
 }
 
+// FollowEntry is an entry in a list of followers or follows.
+type FollowEntry struct {
+	Username string    `json:"username" db:"username"`
+	Since    time.Time `json:"since"    db:"created_at"`
+}
+
+// GetFollowers returns the users following u, oldest follow first.
+func GetFollowers(tx *pop.Connection, u *User) ([]FollowEntry, error) {
+	followers := []FollowEntry{}
+	q := "SELECT u.username, f.created_at FROM follows f JOIN users u ON u.id = f.follower WHERE f.followed = ? ORDER BY f.created_at"
+	if err := tx.RawQuery(q, u.ID).All(&followers); err != nil {
+		return nil, fmt.Errorf("could not list followers %v", err)
+	}
+
+	return followers, nil
+}
+
+// GetFollowing returns the users u follows, oldest follow first.
+func GetFollowing(tx *pop.Connection, u *User) ([]FollowEntry, error) {
+	following := []FollowEntry{}
+	q := "SELECT u.username, f.created_at FROM follows f JOIN users u ON u.id = f.followed WHERE f.follower = ? ORDER BY f.created_at"
+	if err := tx.RawQuery(q, u.ID).All(&following); err != nil {
+		return nil, fmt.Errorf("could not list follows %v", err)
+	}
+
+	return following, nil
+}
+
 // String is not required by pop and may be deleted
 func (f Follow) String() string {
 	jf, _ := json.Marshal(f)
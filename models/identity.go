@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/satori/go.uuid"
+)
+
+// Identity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's issuer and its subject for the
+// account.
+type Identity struct {
+	ID        uuid.UUID `json:"id"         db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	User      uuid.UUID `json:"-"          db:"user_id"`
+	Issuer    string    `json:"issuer"     db:"issuer"`
+	Subject   string    `json:"subject"    db:"subject"`
+	Email     string    `json:"email"      db:"email"`
+}
+
+func (i *Identity) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(i)
+}
+
+// GetUserByIdentity returns the user linked to the given external account.
+func GetUserByIdentity(tx *pop.Connection, issuer string, subject string) (*User, error) {
+	i := Identity{}
+	err := tx.Where("issuer = ? AND subject = ?", issuer, subject).First(&i)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find identity %v", err)
+	}
+
+	return GetUserByID(tx, i.User)
+}
+
+// String is not required by pop and may be deleted
+func (i Identity) String() string {
+	ji, _ := json.Marshal(i)
+	return string(ji)
+}
+
+// Identities is not required by pop and may be deleted
+type Identities []Identity
+
+// String is not required by pop and may be deleted
+func (i Identities) String() string {
+	ji, _ := json.Marshal(i)
+	return string(ji)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (i *Identity) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gobuffalo/envy"
+)
+
+// ImpersonationTTL returns how long an impersonation token lasts. It is set
+// by IMPERSONATION_TTL and defaults to one hour.
+func ImpersonationTTL() time.Duration {
+	d, err := time.ParseDuration(envy.Get("IMPERSONATION_TTL", "1h"))
+	if err != nil {
+		return time.Hour
+	}
+
+	return d
+}
+
+// CreateImpersonationToken returns a session token for u that admin can use
+// to see rmuse as u does. The token names admin so that every request made
+// with it can be traced back to them.
+func (u *User) CreateImpersonationToken(admin *User) (string, error) {
+	claims := jwt.MapClaims{
+		"exp": time.Now().Add(ImpersonationTTL()).Unix(),
+		"iat": time.Now().Unix(),
+		"jti": u.ID.String(),
+		"imp": admin.ID.String(),
+	}
+
+	return signToken(claims)
+}
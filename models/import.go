@@ -0,0 +1,316 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// maxImportFileSize is the largest file that will be imported from an
+// archive, in bytes.
+const maxImportFileSize = 100 << 20
+
+// ImportItem describes one file to import from an archive. Manifests are
+// JSON lists of items, and the media.json of an rmuse export is a valid
+// manifest.
+type ImportItem struct {
+	File               string   `json:"file"`
+	Caption            string   `json:"caption"`
+	Permission         string   `json:"permission"`
+	PosX               int      `json:"col"`
+	PosY               int      `json:"row"`
+	ContentWarnings    []string `json:"content_warnings"`
+	ContentWarningText string   `json:"content_warning_text"`
+}
+
+// ImportResult is the outcome of importing one item. Mentioned are the users
+// the medium's caption mentions, so that they can be emailed once the import
+// is done.
+type ImportResult struct {
+	File      string  `json:"file"`
+	Medium    *Medium `json:"medium,omitempty"`
+	Mentioned []*User `json:"-"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ImportReport is the outcome of importing an archive.
+type ImportReport struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+// importBatchSize returns how many media are created in each transaction.
+func importBatchSize() int {
+	n, err := strconv.Atoi(envy.Get("IMPORT_BATCH_SIZE", "50"))
+	if err != nil || n < 1 {
+		return 50
+	}
+
+	return n
+}
+
+// ParseManifest reads a JSON manifest.
+func ParseManifest(r io.Reader) ([]ImportItem, error) {
+	items := []ImportItem{}
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("malformed manifest %v", err)
+	}
+
+	return items, nil
+}
+
+// archiveManifest returns the manifest to import z with: its manifest.json,
+// or the media.json of an rmuse export, or else every file in the archive.
+func archiveManifest(files map[string]*zip.File) ([]ImportItem, error) {
+	for _, name := range []string{"manifest.json", "media.json"} {
+		if f, ok := files[name]; ok {
+			r, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("could not read %s %v", name, err)
+			}
+			defer r.Close()
+
+			return ParseManifest(r)
+		}
+	}
+
+	items := []ImportItem{}
+	for name := range files {
+		if strings.HasSuffix(name, ".json") || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		items = append(items, ImportItem{File: name})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].File < items[j].File })
+
+	return items, nil
+}
+
+// ImportMedia creates media for u from the files in an archive. Items are
+// described by manifest, or by the archive's own manifest if it is nil. Media
+// are created in batches, each in its own transaction; a batch that fails is
+// rolled back and its items reported as failed, but earlier batches are kept.
+// Users mentioned in captions are notified in the app; emailing them is left
+// to the caller.
+func ImportMedia(db *pop.Connection, u *User, z *zip.Reader, manifest []ImportItem) (*ImportReport, error) {
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		if !f.FileInfo().IsDir() {
+			files[f.Name] = f
+		}
+	}
+
+	if manifest == nil {
+		var err error
+		manifest, err = archiveManifest(files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ImportReport{Results: []ImportResult{}}
+	size := importBatchSize()
+
+	for start := 0; start < len(manifest); start += size {
+		end := start + size
+		if end > len(manifest) {
+			end = len(manifest)
+		}
+
+		report.add(importBatch(db, u, files, manifest[start:end]))
+	}
+
+	return report, nil
+}
+
+func (r *ImportReport) add(results []ImportResult) {
+	for _, res := range results {
+		if res.Error == "" {
+			r.Imported++
+		} else {
+			r.Failed++
+		}
+		r.Results = append(r.Results, res)
+	}
+}
+
+// importBatch imports items in one transaction.
+func importBatch(db *pop.Connection, u *User, files map[string]*zip.File, items []ImportItem) []ImportResult {
+	results := make([]ImportResult, len(items))
+	stored := []string{}
+
+	err := db.Transaction(func(tx *pop.Connection) error {
+		for i, item := range items {
+			results[i] = ImportResult{File: item.File}
+
+			m, mentioned, key, err := importItem(tx, u, files, item)
+			if err != nil {
+				if key != "" {
+					storage.Delete(key)
+				}
+				if _, ok := err.(importError); !ok {
+					return err
+				}
+				results[i].Error = err.Error()
+				continue
+			}
+
+			stored = append(stored, key)
+			results[i].Medium = m
+			results[i].Mentioned = mentioned
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		for _, key := range stored {
+			storage.Delete(key)
+		}
+
+		for i := range results {
+			results[i].Medium = nil
+			results[i].Mentioned = nil
+			results[i].Error = fmt.Sprintf("batch failed: %v", err)
+		}
+	}
+
+	return results
+}
+
+// importError is a problem with a single item, which does not spoil the
+// rest of its batch.
+type importError string
+
+func (e importError) Error() string {
+	return string(e)
+}
+
+// importItem stores one file from the archive and creates its medium. It
+// returns the users its caption mentions, who have been notified in the
+// app, and the key of the stored file so that it can be removed if the batch
+// fails.
+func importItem(tx *pop.Connection, u *User, files map[string]*zip.File, item ImportItem) (*Medium, []*User, string, error) {
+	f, ok := files[item.File]
+	if !ok {
+		return nil, nil, "", importError("file not found in archive")
+	}
+
+	if f.UncompressedSize64 > maxImportFileSize {
+		return nil, nil, "", importError("file is too large")
+	}
+
+	if item.Permission == "" {
+		item.Permission = "public"
+	}
+
+	if item.Permission != "public" && item.Permission != "follower" {
+		return nil, nil, "", importError(fmt.Sprintf("unknown permission %s", item.Permission))
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return nil, nil, "", importError(fmt.Sprintf("could not read file %v", err))
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxImportFileSize+1))
+	if err != nil {
+		return nil, nil, "", importError(fmt.Sprintf("could not read file %v", err))
+	}
+
+	if len(data) > maxImportFileSize {
+		return nil, nil, "", importError("file is too large")
+	}
+
+	filetype := http.DetectContentType(data)
+	if i := strings.Index(filetype, ";"); i >= 0 {
+		filetype = filetype[:i]
+	}
+
+	if !strings.HasPrefix(filetype, "image/") && !strings.HasPrefix(filetype, "audio/") && !strings.HasPrefix(filetype, "video/") {
+		return nil, nil, "", importError(fmt.Sprintf("unsupported file type %s", filetype))
+	}
+
+	key, err := NewMediumKey(item.File)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if err := storage.Put(key, bytes.NewReader(data)); err != nil {
+		return nil, nil, "", err
+	}
+
+	m := &Medium{
+		URI:                storage.URL(key),
+		StorageKey:         key,
+		User:               u.ID,
+		Filetype:           filetype,
+		Permission:         item.Permission,
+		PosX:               item.PosX,
+		PosY:               item.PosY,
+		Caption:            item.Caption,
+		ContentWarnings:    slices.String(item.ContentWarnings),
+		ContentWarningText: item.ContentWarningText,
+	}
+
+	match, err := m.Screen(tx)
+	if err != nil {
+		return nil, nil, key, err
+	}
+
+	if match != nil {
+		if HashMatchAction() == HashReject {
+			if err := match.Record(tx, u, nil); err != nil {
+				return nil, nil, key, err
+			}
+			return nil, nil, key, importError("file matches a blocked image")
+		}
+		m.HiddenAt = nulls.NewTime(time.Now())
+	}
+
+	verrs, err := m.Create(tx)
+	if err != nil {
+		return nil, nil, key, err
+	}
+
+	if verrs.HasAny() {
+		return nil, nil, key, importError(verrs.Error())
+	}
+
+	mentioned, err := m.IndexCaption(tx)
+	if err != nil {
+		return nil, nil, key, err
+	}
+
+	for _, mu := range mentioned {
+		if err := Notify(tx, mu, Event{Type: NotifyMention, Actor: u, Medium: m}); err != nil {
+			return nil, nil, key, err
+		}
+	}
+
+	if match != nil {
+		if err := match.Record(tx, u, m); err != nil {
+			return nil, nil, key, err
+		}
+	}
+
+	return m, mentioned, key, nil
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/satori/go.uuid"
+)
+
+// Like records that a user likes a medium.
+type Like struct {
+	ID        uuid.UUID `json:"id"         db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"-"          db:"updated_at"`
+	User      uuid.UUID `json:"user_id"    db:"user_id"`
+	Medium    uuid.UUID `json:"medium_id"  db:"medium_id"`
+}
+
+// String is not required by pop and may be deleted
+func (l Like) String() string {
+	jl, _ := json.Marshal(l)
+	return string(jl)
+}
+
+// Likes is not required by pop and may be deleted
+type Likes []Like
+
+// String is not required by pop and may be deleted
+func (l Likes) String() string {
+	jl, _ := json.Marshal(l)
+	return string(jl)
+}
+
+// LikeEntry is an entry in a list of the users who like a medium.
+type LikeEntry struct {
+	Username string    `json:"username" db:"username"`
+	Since    time.Time `json:"since"    db:"since"`
+}
+
+// Like records that u likes m, and reports whether they did not already.
+// Liking a medium again has no effect, even when two likes race.
+func (u *User) Like(tx *pop.Connection, m *Medium) (bool, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return false, fmt.Errorf("could not like medium %v", err)
+	}
+
+	now := time.Now()
+	q := tx.RawQuery("INSERT INTO likes (id, created_at, updated_at, user_id, medium_id) VALUES (?, ?, ?, ?, ?) ON CONFLICT (user_id, medium_id) DO NOTHING RETURNING id", id, now, now, u.ID, m.ID)
+
+	created := []struct {
+		ID uuid.UUID `db:"id"`
+	}{}
+	if err := q.All(&created); err != nil {
+		return false, fmt.Errorf("could not like medium %v", err)
+	}
+
+	return len(created) > 0, nil
+}
+
+// FirstLike records that u has liked m and reports whether they had never
+// liked it before, so that unliking and liking again does not notify the
+// owner a second time.
+func (u *User) FirstLike(tx *pop.Connection, m *Medium) (bool, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return false, fmt.Errorf("could not record like %v", err)
+	}
+
+	now := time.Now()
+	q := tx.RawQuery("INSERT INTO like_notices (id, created_at, updated_at, user_id, medium_id) VALUES (?, ?, ?, ?, ?) ON CONFLICT (user_id, medium_id) DO NOTHING RETURNING id", id, now, now, u.ID, m.ID)
+
+	created := []struct {
+		ID uuid.UUID `db:"id"`
+	}{}
+	if err := q.All(&created); err != nil {
+		return false, fmt.Errorf("could not record like %v", err)
+	}
+
+	return len(created) > 0, nil
+}
+
+// Unlike withdraws u's like of m, if they like it.
+func (u *User) Unlike(tx *pop.Connection, m *Medium) error {
+	if err := tx.RawQuery("DELETE FROM likes WHERE user_id = ? AND medium_id = ?", u.ID, m.ID).Exec(); err != nil {
+		return fmt.Errorf("could not unlike medium %v", err)
+	}
+
+	return nil
+}
+
+// CountLikes sets LikeCount on each of media, and LikedByMe if viewer, who
+// may be nil, likes it. Likes by deactivated or suspended accounts are not
+// counted.
+func CountLikes(tx *pop.Connection, media Media, viewer *User) error {
+	if len(media) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(media))
+	for i := range media {
+		ids[i] = media[i].ID
+	}
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+
+	counts := []struct {
+		Medium uuid.UUID `db:"medium_id"`
+		Count  int       `db:"count"`
+	}{}
+	q := tx.RawQuery("SELECT medium_id, COUNT(*) AS count FROM likes WHERE medium_id IN "+in+" AND user_id NOT IN ("+hiddenAuthors+") GROUP BY medium_id", append(ids, time.Now())...)
+	if err := q.All(&counts); err != nil {
+		return fmt.Errorf("could not count likes %v", err)
+	}
+
+	byMedium := map[uuid.UUID]int{}
+	for _, n := range counts {
+		byMedium[n.Medium] = n.Count
+	}
+
+	liked := map[uuid.UUID]bool{}
+	if viewer != nil {
+		likes := Likes{}
+		if err := tx.Where("user_id = ? AND medium_id IN "+in, append([]interface{}{viewer.ID}, ids...)...).All(&likes); err != nil {
+			return fmt.Errorf("could not find likes %v", err)
+		}
+
+		for _, l := range likes {
+			liked[l.Medium] = true
+		}
+	}
+
+	for i := range media {
+		media[i].LikeCount = byMedium[media[i].ID]
+		media[i].LikedByMe = liked[media[i].ID]
+	}
+
+	return nil
+}
+
+// GetLikedMedia returns a page of the media u likes, most recently liked
+// first, leaving out those viewer may not see or hides with their content
+// preference p, and marking those they blur.
+func GetLikedMedia(tx *pop.Connection, u *User, viewer *User, p *ContentPreference, page int, perPage int) (Media, error) {
+	cond, args := visibleMedia(viewer, p)
+	args = append([]interface{}{u.ID}, args...)
+	args = append(args, perPage, (page-1)*perPage)
+
+	media := Media{}
+	q := tx.RawQuery("SELECT media.* FROM media JOIN likes ON likes.medium_id = media.id WHERE likes.user_id = ? AND "+cond+" ORDER BY likes.created_at DESC LIMIT ? OFFSET ?", args...)
+	if err := q.All(&media); err != nil {
+		return nil, fmt.Errorf("could not find liked media %v", err)
+	}
+
+	return FilterMedia(media, viewer, p), nil
+}
+
+// GetLikers returns a page of the users who like m, most recent first.
+// Deactivated and suspended accounts are left out.
+func GetLikers(tx *pop.Connection, m *Medium, page int, perPage int) ([]LikeEntry, error) {
+	likers := []LikeEntry{}
+	q := tx.RawQuery("SELECT u.username, l.created_at AS since FROM likes l JOIN users u ON u.id = l.user_id WHERE l.medium_id = ? AND l.user_id NOT IN ("+hiddenAuthors+") ORDER BY l.created_at DESC LIMIT ? OFFSET ?", m.ID, time.Now(), perPage, (page-1)*perPage)
+	if err := q.All(&likers); err != nil {
+		return nil, fmt.Errorf("could not find likes %v", err)
+	}
+
+	return likers, nil
+}
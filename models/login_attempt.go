@@ -0,0 +1,119 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/validate"
+	"github.com/satori/go.uuid"
+)
+
+// Reasons a login attempt was refused.
+const (
+	LoginUnknownEmail = "unknown_email"
+	LoginBadPassword  = "bad_password"
+	LoginBadCode      = "bad_mfa_code"
+	LoginLockedOut    = "locked_out"
+	LoginBadOIDC      = "bad_oidc"
+)
+
+// LoginAttempt is a failed login counted against an account or address. Rows
+// are discarded when the account next logs in successfully.
+type LoginAttempt struct {
+	ID         uuid.UUID `json:"id"         db:"id"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	AttemptKey string    `json:"key"        db:"attempt_key"`
+}
+
+// LoginFailure is the permanent audit record of a refused login.
+type LoginFailure struct {
+	ID        uuid.UUID `json:"id"         db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Email     string    `json:"email"      db:"email"`
+	IP        string    `json:"ip"         db:"ip"`
+	Reason    string    `json:"reason"     db:"reason"`
+}
+
+func (f *LoginFailure) Create(tx *pop.Connection) error {
+	return tx.Create(f)
+}
+
+// PostgresAttemptStore keeps failed login attempts in the login_attempts
+// table so that they are shared between application instances.
+type PostgresAttemptStore struct {
+	DB *pop.Connection
+}
+
+// RecordFailure counts a failed attempt against key.
+func (s *PostgresAttemptStore) RecordFailure(key string) error {
+	a := &LoginAttempt{
+		AttemptKey: key,
+	}
+
+	if err := s.DB.Create(a); err != nil {
+		return fmt.Errorf("could not record login attempt %v", err)
+	}
+
+	return nil
+}
+
+// Failures returns the number of attempts counted against key since the
+// given time and when the most recent of them happened.
+func (s *PostgresAttemptStore) Failures(key string, since time.Time) (int, time.Time, error) {
+	as := LoginAttempts{}
+	err := s.DB.Where("attempt_key = ? AND created_at >= ?", key, since).Order("created_at desc").All(&as)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("could not count login attempts %v", err)
+	}
+
+	if len(as) == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	return len(as), as[0].CreatedAt, nil
+}
+
+// Reset forgets every attempt counted against key.
+func (s *PostgresAttemptStore) Reset(key string) error {
+	return s.DB.RawQuery("DELETE FROM login_attempts WHERE attempt_key = ?", key).Exec()
+}
+
+// String is not required by pop and may be deleted
+func (a LoginAttempt) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// LoginAttempts is not required by pop and may be deleted
+type LoginAttempts []LoginAttempt
+
+// String is not required by pop and may be deleted
+func (a LoginAttempts) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// String is not required by pop and may be deleted
+func (f LoginFailure) String() string {
+	jf, _ := json.Marshal(f)
+	return string(jf)
+}
+
+// LoginFailures is not required by pop and may be deleted
+type LoginFailures []LoginFailure
+
+// String is not required by pop and may be deleted
+func (f LoginFailures) String() string {
+	jf, _ := json.Marshal(f)
+	return string(jf)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (f *LoginFailure) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
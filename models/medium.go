@@ -3,30 +3,60 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
 	"github.com/markbates/validate"
 	"github.com/markbates/validate/validators"
 	"github.com/satori/go.uuid"
 )
 
 type Medium struct {
-	ID         uuid.UUID `json:"id" db:"id"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
-	URI        string    `json:"uri"        db:"uri"`
-	User       uuid.UUID `json:"userid"     db:"user_id"`
-	Filetype   string    `json:"type"       db:"filetype"`
-	Permission string    `json:"permission" db:"permission"`
-	PosX       int       `json:"col"        db:"posx"`
-	PosY       int       `json:"row"        db:"posy"`
+	ID                 uuid.UUID     `json:"id"                   db:"id"`
+	CreatedAt          time.Time     `json:"created_at"           db:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at"           db:"updated_at"`
+	URI                string        `json:"uri"                  db:"uri"`
+	StorageKey         string        `json:"-"                    db:"storage_key"`
+	User               uuid.UUID     `json:"userid"               db:"user_id"`
+	Filetype           string        `json:"type"                 db:"filetype"`
+	Permission         string        `json:"permission"           db:"permission"`
+	PosX               int           `json:"col"                  db:"posx"`
+	PosY               int           `json:"row"                  db:"posy"`
+	Caption            string        `json:"caption"              db:"caption"`
+	HiddenAt           nulls.Time    `json:"hidden_at"            db:"hidden_at"`
+	ContentWarnings    slices.String `json:"content_warnings"     db:"content_warnings"`
+	ContentWarningText string        `json:"content_warning_text" db:"content_warning_text"`
+	PerceptualHash     nulls.Int64   `json:"-"                    db:"perceptual_hash"`
+	CommentsDisabled   bool          `json:"comments_disabled"    db:"comments_disabled"`
+	Blurred            bool          `json:"blurred"              db:"-"`
+	LikeCount          int           `json:"like_count"           db:"-"`
+	LikedByMe          bool          `json:"liked_by_me"          db:"-"`
 }
 
 func (m *Medium) Create(tx *pop.Connection) (*validate.Errors, error) {
+	if m.ContentWarnings == nil {
+		m.ContentWarnings = slices.String{}
+	}
+
 	return tx.ValidateAndCreate(m)
 }
 
+// NewMediumKey returns a fresh storage key for a medium uploaded as a file
+// named name, keeping its extension.
+func NewMediumKey(name string) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("could not generate id %v", err)
+	}
+
+	return "media/" + id.String() + strings.ToLower(path.Ext(name)), nil
+}
+
 func GetMediumByID(tx *pop.Connection, id uuid.UUID, u *User) (*Medium, error) {
 	m := Medium{}
 	err := tx.Find(&m, id)
@@ -35,6 +65,17 @@ func GetMediumByID(tx *pop.Connection, id uuid.UUID, u *User) (*Medium, error) {
 		return nil, fmt.Errorf("could not find media %v", err)
 	}
 
+	if u == nil || u.ID != m.User {
+		if m.Hidden() {
+			return nil, fmt.Errorf("could not find media")
+		}
+
+		owner, err := GetUserByID(tx, m.User)
+		if err != nil || owner.Deactivated() || owner.Suspended() {
+			return nil, fmt.Errorf("could not find media")
+		}
+	}
+
 	if u == nil && m.Permission != "public" {
 		return nil, fmt.Errorf("user is not authorized for media")
 	}
@@ -58,6 +99,58 @@ func GetMediumByID(tx *pop.Connection, id uuid.UUID, u *User) (*Medium, error) {
 	return &m, nil
 }
 
+// visibleMedia returns a condition on the media table, with its arguments,
+// that holds for the media viewer, who may be nil, may see and does not hide
+// with their content preference p. It agrees with GetMediumByID and
+// FilterMedia, so that lists can be filtered before they are paginated.
+func visibleMedia(viewer *User, p *ContentPreference) (string, []interface{}) {
+	cond := "media.hidden_at IS NULL AND media.user_id NOT IN (" + hiddenAuthors + ")"
+	args := []interface{}{time.Now()}
+
+	if viewer == nil {
+		cond += " AND media.permission = 'public'"
+	} else {
+		cond += " AND (media.permission != 'follower' OR EXISTS (SELECT 1 FROM follows WHERE follows.follower = ? AND follows.followed = media.user_id))"
+		args = append(args, viewer.ID)
+	}
+
+	warned := []string{}
+	for _, w := range p.hiddenWarnings(viewer) {
+		if w == WarningCustom {
+			warned = append(warned, "media.content_warning_text != ''")
+		} else {
+			warned = append(warned, "? = ANY(media.content_warnings)")
+			args = append(args, w)
+		}
+	}
+	if len(warned) > 0 {
+		cond += " AND NOT (" + strings.Join(warned, " OR ") + ")"
+	}
+
+	// owners always see their own media
+	if viewer != nil {
+		cond = "(media.user_id = ? OR (" + cond + "))"
+		args = append([]interface{}{viewer.ID}, args...)
+	}
+
+	return cond, args
+}
+
+// GetMediumByKey returns the medium whose file rmuse stored under key,
+// regardless of who may see it.
+func GetMediumByKey(tx *pop.Connection, key string) (*Medium, error) {
+	m := Media{}
+	if err := tx.Where("storage_key = ?", key).All(&m); err != nil {
+		return nil, fmt.Errorf("could not find media %v", err)
+	}
+
+	if len(m) == 0 {
+		return nil, fmt.Errorf("could not find media")
+	}
+
+	return &m[0], nil
+}
+
 func GetMediumIDByURI(tx *pop.Connection, uri string) (uuid.UUID, error) {
 	m := Medium{}
 	query := tx.Where("uri = ?", uri)
@@ -78,7 +171,12 @@ func GetMediaByUsername(tx *pop.Connection, username string) (*Media, error) {
 		return nil, err
 	}
 
-	query := tx.Where("user_id = ?", u.ID)
+	// a suspended user's media are withdrawn with the rest of their page
+	if u.Suspended() {
+		return &m, nil
+	}
+
+	query := tx.Where("user_id = ? AND hidden_at IS NULL", u.ID)
 	err = query.All(&m)
 	if err != nil {
 		return nil, err
@@ -87,6 +185,26 @@ func GetMediaByUsername(tx *pop.Connection, username string) (*Media, error) {
 	return &m, nil
 }
 
+// Hidden reports whether a moderator has hidden the medium. Only its owner
+// can still see it.
+func (m *Medium) Hidden() bool {
+	return m.HiddenAt.Valid
+}
+
+// Hide takes the medium out of view of everyone but its owner.
+func (m *Medium) Hide(tx *pop.Connection) error {
+	if m.Hidden() {
+		return nil
+	}
+
+	m.HiddenAt = nulls.NewTime(time.Now())
+	if err := tx.Update(m); err != nil {
+		return fmt.Errorf("could not hide medium %v", err)
+	}
+
+	return nil
+}
+
 // String is not required by pop and may be deleted
 func (m Medium) String() string {
 	jm, _ := json.Marshal(m)
@@ -105,7 +223,24 @@ func (m Media) String() string {
 // Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
 // This method is not required and may be deleted.
 func (m *Medium) Validate(tx *pop.Connection) (*validate.Errors, error) {
-	return validate.NewErrors(), nil
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   strings.Join(ContentWarnings, ", "),
+			Name:    "ContentWarnings",
+			Message: "content warnings must each be one of %s",
+			Fn: func() bool {
+				return validContentWarnings(m.ContentWarnings)
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxContentWarningTextLength),
+			Name:    "ContentWarningText",
+			Message: "content warning text must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(m.ContentWarningText) <= MaxContentWarningTextLength
+			},
+		},
+	), nil
 }
 
 // ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
@@ -0,0 +1,381 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+	"github.com/markbates/validate"
+	"github.com/satori/go.uuid"
+)
+
+// Types of notification. Follow requests are for accounts that approve their
+// followers, which rmuse does not have yet; the type is reserved so that
+// preferences for it can already be set.
+const (
+	NotifyFollow        = "follow"
+	NotifyFollowRequest = "follow_request"
+	NotifyLike          = "like"
+	NotifyComment       = "comment"
+	NotifyMention       = "mention"
+	NotifyModeration    = "moderation"
+)
+
+// NotificationTypes lists every type of notification.
+var NotificationTypes = []string{
+	NotifyFollow,
+	NotifyFollowRequest,
+	NotifyLike,
+	NotifyComment,
+	NotifyMention,
+	NotifyModeration,
+}
+
+// maxGroupedActors is how many of the users behind a group of notifications
+// are named in it; the rest are only counted.
+const maxGroupedActors = 3
+
+// Notification tells a user that something happened that concerns them.
+// Unread notifications of the same type about the same medium, or about no
+// medium, are grouped into one, so that twenty likes make one notification
+// with a count of twenty. Moderation notifications are never grouped.
+type Notification struct {
+	ID        uuid.UUID     `json:"id"         db:"id"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at" db:"updated_at"`
+	User      uuid.UUID     `json:"-"          db:"user_id"`
+	Type      string        `json:"type"       db:"type"`
+	ActorIDs  slices.String `json:"-"          db:"actor_ids"`
+	Actors    []string      `json:"actors"     db:"-"`
+	Count     int           `json:"count"      db:"count"`
+	Medium    nulls.UUID    `json:"medium_id"  db:"medium_id"`
+	Comment   nulls.UUID    `json:"comment_id" db:"comment_id"`
+	Message   string        `json:"message"    db:"message"`
+	ReadAt    nulls.Time    `json:"read_at"    db:"read_at"`
+}
+
+// String is not required by pop and may be deleted
+func (n Notification) String() string {
+	jn, _ := json.Marshal(n)
+	return string(jn)
+}
+
+// Notifications is not required by pop and may be deleted
+type Notifications []Notification
+
+// String is not required by pop and may be deleted
+func (n Notifications) String() string {
+	jn, _ := json.Marshal(n)
+	return string(jn)
+}
+
+// Event is something that happened that a user may be notified of. Actor,
+// Medium and Comment are set if they apply; Message explains moderation
+// outcomes.
+type Event struct {
+	Type    string
+	Actor   *User
+	Medium  *Medium
+	Comment *Comment
+	Message string
+}
+
+// Notify tells u about e, unless they caused it themselves or have turned
+// off notifications of its type.
+func Notify(tx *pop.Connection, u *User, e Event) error {
+	if e.Actor != nil && e.Actor.ID == u.ID {
+		return nil
+	}
+
+	p, err := GetNotificationPreference(tx, u)
+	if err != nil {
+		return err
+	}
+
+	if !p.Enabled(e.Type) {
+		return nil
+	}
+
+	n, err := notificationGroup(tx, u, e)
+	if err != nil {
+		return err
+	}
+
+	if n == nil {
+		n = &Notification{
+			User:     u.ID,
+			Type:     e.Type,
+			ActorIDs: slices.String{},
+			Message:  e.Message,
+		}
+		if e.Medium != nil {
+			n.Medium = nulls.NewUUID(e.Medium.ID)
+		}
+	}
+
+	if e.Comment != nil {
+		n.Comment = nulls.NewUUID(e.Comment.ID)
+	}
+
+	// the same user acting again moves to the front but is not counted twice
+	actors := slices.String{}
+	repeated := false
+	if e.Actor != nil {
+		actors = append(actors, e.Actor.ID.String())
+	}
+	for _, id := range n.ActorIDs {
+		if e.Actor != nil && id == e.Actor.ID.String() {
+			repeated = true
+			continue
+		}
+		if len(actors) < maxGroupedActors {
+			actors = append(actors, id)
+		}
+	}
+	n.ActorIDs = actors
+
+	if !repeated {
+		n.Count++
+	}
+
+	if n.ID == uuid.Nil {
+		err = tx.Create(n)
+	} else {
+		err = tx.Update(n)
+	}
+	if err != nil {
+		return fmt.Errorf("could not notify %v", err)
+	}
+
+	return nil
+}
+
+// notificationGroup returns the unread notification e should be grouped
+// into, or nil if there is none.
+func notificationGroup(tx *pop.Connection, u *User, e Event) (*Notification, error) {
+	if e.Type == NotifyModeration {
+		return nil, nil
+	}
+
+	q := tx.Where("user_id = ? AND type = ? AND read_at IS NULL", u.ID, e.Type)
+	if e.Medium != nil {
+		q = q.Where("medium_id = ?", e.Medium.ID)
+	} else {
+		q = q.Where("medium_id IS NULL")
+	}
+
+	group := Notifications{}
+	if err := q.Order("updated_at desc").Limit(1).All(&group); err != nil {
+		return nil, fmt.Errorf("could not find notifications %v", err)
+	}
+
+	if len(group) == 0 {
+		return nil, nil
+	}
+
+	return &group[0], nil
+}
+
+// shownCount is the count of the notification n without the actors named in
+// it whose accounts have since been deleted, deactivated or suspended.
+// Notifications left with a count of zero are not shown.
+const shownCount = "n.count - cardinality(n.actor_ids) + (SELECT COUNT(*) FROM users WHERE users.id::text = ANY(n.actor_ids) AND users.id NOT IN (" + hiddenAuthors + "))"
+
+// GetNotifications returns a page of u's notifications, most recently
+// updated first, and only the unread ones if unread is set. Actors who have
+// since been deleted, deactivated or suspended are left out and no longer
+// counted, and notifications with no one left behind them are dropped.
+func GetNotifications(tx *pop.Connection, u *User, unread bool, page int, perPage int) (Notifications, error) {
+	now := time.Now()
+	query := "SELECT n.*, " + shownCount + " AS shown_count FROM notifications n WHERE n.user_id = ? AND " + shownCount + " > 0"
+	args := []interface{}{now, u.ID, now}
+	if unread {
+		query += " AND n.read_at IS NULL"
+	}
+	query += " ORDER BY n.updated_at DESC LIMIT ? OFFSET ?"
+	args = append(args, perPage, (page-1)*perPage)
+
+	rows := []struct {
+		Notification
+		ShownCount int `db:"shown_count"`
+	}{}
+	if err := tx.RawQuery(query, args...).All(&rows); err != nil {
+		return nil, fmt.Errorf("could not find notifications %v", err)
+	}
+
+	// the actors of the whole page are looked up at once
+	ids := []interface{}{}
+	for _, row := range rows {
+		for _, id := range row.ActorIDs {
+			ids = append(ids, id)
+		}
+	}
+
+	usernames := map[string]string{}
+	if len(ids) > 0 {
+		in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+		actors := []struct {
+			ID       uuid.UUID `db:"id"`
+			Username string    `db:"username"`
+		}{}
+		q := tx.RawQuery("SELECT id, username FROM users WHERE id::text IN "+in+" AND id NOT IN ("+hiddenAuthors+")", append(ids, now)...)
+		if err := q.All(&actors); err != nil {
+			return nil, fmt.Errorf("could not find notification actors %v", err)
+		}
+
+		for _, a := range actors {
+			usernames[a.ID.String()] = a.Username
+		}
+	}
+
+	notifications := Notifications{}
+	for _, row := range rows {
+		n := row.Notification
+		n.Count = row.ShownCount
+		n.Actors = []string{}
+		for _, id := range n.ActorIDs {
+			if username, ok := usernames[id]; ok {
+				n.Actors = append(n.Actors, username)
+			}
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, nil
+}
+
+// UnreadNotificationCount counts u's unread notifications, leaving out those
+// GetNotifications drops.
+func UnreadNotificationCount(tx *pop.Connection, u *User) (int, error) {
+	counts := []struct {
+		Count int `db:"count"`
+	}{}
+	q := tx.RawQuery("SELECT COUNT(*) AS count FROM notifications n WHERE n.user_id = ? AND n.read_at IS NULL AND "+shownCount+" > 0", u.ID, time.Now())
+	if err := q.All(&counts); err != nil {
+		return 0, fmt.Errorf("could not count notifications %v", err)
+	}
+
+	if len(counts) == 0 {
+		return 0, nil
+	}
+
+	return counts[0].Count, nil
+}
+
+func GetNotificationByID(tx *pop.Connection, id uuid.UUID) (*Notification, error) {
+	n := Notification{}
+	err := tx.Find(&n, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find notification %v", err)
+	}
+
+	return &n, nil
+}
+
+// MarkRead marks the notification as read. Later events of its kind start a
+// new notification.
+func (n *Notification) MarkRead(tx *pop.Connection) error {
+	if n.ReadAt.Valid {
+		return nil
+	}
+
+	n.ReadAt = nulls.NewTime(time.Now())
+	if err := tx.Update(n); err != nil {
+		return fmt.Errorf("could not mark notification read %v", err)
+	}
+
+	return nil
+}
+
+// MarkAllNotificationsRead marks all of u's notifications as read.
+func MarkAllNotificationsRead(tx *pop.Connection, u *User) error {
+	if err := tx.RawQuery("UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL", time.Now(), u.ID).Exec(); err != nil {
+		return fmt.Errorf("could not mark notifications read %v", err)
+	}
+
+	return nil
+}
+
+// NotificationPreference is which types of notification a user wants. Users
+// who have not set one get DefaultNotificationPreference.
+type NotificationPreference struct {
+	ID            uuid.UUID `json:"-"              db:"id"`
+	CreatedAt     time.Time `json:"-"              db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"     db:"updated_at"`
+	User          uuid.UUID `json:"-"              db:"user_id"`
+	Follow        bool      `json:"follow"         db:"follows"`
+	FollowRequest bool      `json:"follow_request" db:"follow_requests"`
+	Like          bool      `json:"like"           db:"likes"`
+	Comment       bool      `json:"comment"        db:"comments"`
+	Mention       bool      `json:"mention"        db:"mentions"`
+	Moderation    bool      `json:"moderation"     db:"moderation"`
+}
+
+// String is not required by pop and may be deleted
+func (p NotificationPreference) String() string {
+	jp, _ := json.Marshal(p)
+	return string(jp)
+}
+
+// DefaultNotificationPreference turns on every type of notification.
+func DefaultNotificationPreference() *NotificationPreference {
+	return &NotificationPreference{
+		Follow:        true,
+		FollowRequest: true,
+		Like:          true,
+		Comment:       true,
+		Mention:       true,
+		Moderation:    true,
+	}
+}
+
+// GetNotificationPreference returns u's notification preference, or the
+// default if they have not set one.
+func GetNotificationPreference(tx *pop.Connection, u *User) (*NotificationPreference, error) {
+	set := []NotificationPreference{}
+	if err := tx.Where("user_id = ?", u.ID).All(&set); err != nil {
+		return nil, fmt.Errorf("could not find notification preference %v", err)
+	}
+
+	if len(set) > 0 {
+		return &set[0], nil
+	}
+
+	p := DefaultNotificationPreference()
+	p.User = u.ID
+	return p, nil
+}
+
+// Save creates or updates the preference.
+func (p *NotificationPreference) Save(tx *pop.Connection) (*validate.Errors, error) {
+	if p.ID == uuid.Nil {
+		return tx.ValidateAndCreate(p)
+	}
+
+	return tx.ValidateAndUpdate(p)
+}
+
+// Enabled reports whether the user wants notifications of type t.
+func (p *NotificationPreference) Enabled(t string) bool {
+	switch t {
+	case NotifyFollow:
+		return p.Follow
+	case NotifyFollowRequest:
+		return p.FollowRequest
+	case NotifyLike:
+		return p.Like
+	case NotifyComment:
+		return p.Comment
+	case NotifyMention:
+		return p.Mention
+	case NotifyModeration:
+		return p.Moderation
+	}
+
+	return false
+}
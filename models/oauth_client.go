@@ -0,0 +1,150 @@
+package models
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/slices"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// OAuthClient is a third-party application registered to act on behalf of
+// rmuse users. Public clients, such as native apps, have no secret.
+type OAuthClient struct {
+	ID           uuid.UUID     `json:"client_id"     db:"id"`
+	CreatedAt    time.Time     `json:"created_at"    db:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"    db:"updated_at"`
+	User         uuid.UUID     `json:"-"             db:"user_id"`
+	Name         string        `json:"name"          db:"name"`
+	SecretHash   string        `json:"-"             db:"secret_hash"`
+	RedirectURIs slices.String `json:"redirect_uris" db:"redirect_uris"`
+}
+
+// NewOAuthClient registers a client owned by u. Confidential clients are
+// given a secret, which is returned and not stored.
+func NewOAuthClient(u *User, name string, redirectURIs []string, confidential bool) (*OAuthClient, string, error) {
+	cl := &OAuthClient{
+		User:         u.ID,
+		Name:         name,
+		RedirectURIs: slices.String(redirectURIs),
+	}
+
+	if !confidential {
+		return cl, "", nil
+	}
+
+	secret, err := newSecret(32)
+	if err != nil {
+		return nil, "", err
+	}
+	cl.SecretHash = hashSecret(secret)
+
+	return cl, secret, nil
+}
+
+func (cl *OAuthClient) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(cl)
+}
+
+// Confidential reports whether the client must authenticate with a secret.
+func (cl *OAuthClient) Confidential() bool {
+	return cl.SecretHash != ""
+}
+
+// ValidSecret reports whether secret is the client's secret.
+func (cl *OAuthClient) ValidSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(cl.SecretHash), []byte(hashSecret(secret))) == 1
+}
+
+// AllowsRedirect reports whether uri is one of the client's registered
+// redirect URIs. URIs must match exactly.
+func (cl *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, r := range cl.RedirectURIs {
+		if r == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+func GetOAuthClientByID(tx *pop.Connection, id uuid.UUID) (*OAuthClient, error) {
+	cl := OAuthClient{}
+	err := tx.Find(&cl, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find oauth client %v", err)
+	}
+
+	return &cl, nil
+}
+
+// GetOAuthClientsByUser returns the clients u has registered.
+func GetOAuthClientsByUser(tx *pop.Connection, u *User) (*OAuthClients, error) {
+	cl := OAuthClients{}
+	err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&cl)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find oauth clients %v", err)
+	}
+
+	return &cl, nil
+}
+
+// String is not required by pop and may be deleted
+func (cl OAuthClient) String() string {
+	jc, _ := json.Marshal(cl)
+	return string(jc)
+}
+
+// OAuthClients is not required by pop and may be deleted
+type OAuthClients []OAuthClient
+
+// String is not required by pop and may be deleted
+func (cl OAuthClients) String() string {
+	jc, _ := json.Marshal(cl)
+	return string(jc)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (cl *OAuthClient) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   cl.Name,
+			Name:    "Name",
+			Message: "name is empty",
+			Fn: func() bool {
+				return cl.Name != ""
+			},
+		},
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "RedirectURIs",
+			Message: "at least one redirect uri is required",
+			Fn: func() bool {
+				return len(cl.RedirectURIs) > 0
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(cl.RedirectURIs),
+			Name:    "RedirectURIs",
+			Message: "redirect uris must be absolute urls without fragments: %s",
+			Fn: func() bool {
+				for _, r := range cl.RedirectURIs {
+					u, err := url.Parse(r)
+					if err != nil || !u.IsAbs() || u.Fragment != "" {
+						return false
+					}
+				}
+				return true
+			},
+		},
+	), nil
+}
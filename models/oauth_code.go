@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/slices"
+	"github.com/satori/go.uuid"
+)
+
+// oauthCodeTTL is how long an authorization code can be exchanged for a
+// token.
+const oauthCodeTTL = 10 * time.Minute
+
+// OAuthCode is an authorization code issued when a user consents to a
+// client. It is bound to the PKCE challenge the client started with and can
+// be exchanged once.
+type OAuthCode struct {
+	ID            uuid.UUID     `json:"id"             db:"id"`
+	CreatedAt     time.Time     `json:"created_at"     db:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"     db:"updated_at"`
+	CodeHash      string        `json:"-"              db:"code_hash"`
+	Client        uuid.UUID     `json:"client_id"      db:"client_id"`
+	User          uuid.UUID     `json:"-"              db:"user_id"`
+	RedirectURI   string        `json:"redirect_uri"   db:"redirect_uri"`
+	Scopes        slices.String `json:"scopes"         db:"scopes"`
+	CodeChallenge string        `json:"-"              db:"code_challenge"`
+	ExpiresAt     time.Time     `json:"expires_at"     db:"expires_at"`
+}
+
+// CreateOAuthCode stores a new authorization code and returns its plaintext.
+func CreateOAuthCode(tx *pop.Connection, cl *OAuthClient, u *User, redirectURI string, scopes []string, challenge string) (string, error) {
+	code, err := newSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	oc := &OAuthCode{
+		CodeHash:      hashSecret(code),
+		Client:        cl.ID,
+		User:          u.ID,
+		RedirectURI:   redirectURI,
+		Scopes:        slices.String(scopes),
+		CodeChallenge: challenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	}
+
+	if err := tx.Create(oc); err != nil {
+		return "", fmt.Errorf("could not store authorization code %v", err)
+	}
+
+	return code, nil
+}
+
+// RedeemOAuthCode exchanges an authorization code issued to cl for redirectURI
+// once the client proves it holds the PKCE verifier. A code can only be
+// exchanged once.
+func RedeemOAuthCode(tx *pop.Connection, cl *OAuthClient, code string, redirectURI string, verifier string) (*OAuthCode, error) {
+	oc := OAuthCode{}
+	err := tx.Where("code_hash = ?", hashSecret(code)).First(&oc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization code")
+	}
+
+	if err := tx.Destroy(&oc); err != nil {
+		return nil, fmt.Errorf("could not consume authorization code %v", err)
+	}
+
+	if oc.Client != cl.ID || oc.RedirectURI != redirectURI || oc.ExpiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("invalid authorization code")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(oc.CodeChallenge), []byte(PKCEChallenge(verifier))) != 1 {
+		return nil, fmt.Errorf("invalid code verifier")
+	}
+
+	return &oc, nil
+}
+
+// PKCEChallenge returns the S256 code challenge for verifier.
+func PKCEChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// String is not required by pop and may be deleted
+func (oc OAuthCode) String() string {
+	jc, _ := json.Marshal(oc)
+	return string(jc)
+}
+
+// OAuthCodes is not required by pop and may be deleted
+type OAuthCodes []OAuthCode
+
+// String is not required by pop and may be deleted
+func (oc OAuthCodes) String() string {
+	jc, _ := json.Marshal(oc)
+	return string(jc)
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/slices"
+	"github.com/satori/go.uuid"
+)
+
+// OAuthGrant records that a user has authorized a client. Tokens issued to
+// the client are only honoured while the grant exists.
+type OAuthGrant struct {
+	ID         uuid.UUID     `json:"id"         db:"id"`
+	CreatedAt  time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" db:"updated_at"`
+	Client     uuid.UUID     `json:"client_id"  db:"client_id"`
+	User       uuid.UUID     `json:"-"          db:"user_id"`
+	Scopes     slices.String `json:"scopes"     db:"scopes"`
+	ClientName string        `json:"name"       db:"-"`
+}
+
+// Grant records that u has authorized cl for scopes, adding to any scopes
+// granted before.
+func Grant(tx *pop.Connection, u *User, cl *OAuthClient, scopes []string) error {
+	g := OAuthGrant{}
+	err := tx.Where("user_id = ? AND client_id = ?", u.ID, cl.ID).First(&g)
+	if err != nil {
+		g = OAuthGrant{
+			Client: cl.ID,
+			User:   u.ID,
+			Scopes: slices.String(scopes),
+		}
+
+		return tx.Create(&g)
+	}
+
+	for _, s := range scopes {
+		if !g.HasScope(s) {
+			g.Scopes = append(g.Scopes, s)
+		}
+	}
+
+	return tx.Update(&g)
+}
+
+// HasScope reports whether the grant includes scope.
+func (g *OAuthGrant) HasScope(scope string) bool {
+	for _, s := range g.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetOAuthGrant returns u's grant to the client with the given id.
+func GetOAuthGrant(tx *pop.Connection, u *User, client uuid.UUID) (*OAuthGrant, error) {
+	g := OAuthGrant{}
+	err := tx.Where("user_id = ? AND client_id = ?", u.ID, client).First(&g)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find oauth grant %v", err)
+	}
+
+	return &g, nil
+}
+
+// GetOAuthGrantsByUser returns every client u has authorized, with the
+// clients' names filled in.
+func GetOAuthGrantsByUser(tx *pop.Connection, u *User) (*OAuthGrants, error) {
+	g := OAuthGrants{}
+	err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&g)
+	if err != nil {
+		return nil, fmt.Errorf("could not find oauth grants %v", err)
+	}
+
+	for i := range g {
+		if cl, err := GetOAuthClientByID(tx, g[i].Client); err == nil {
+			g[i].ClientName = cl.Name
+		}
+	}
+
+	return &g, nil
+}
+
+// RevokeOAuthGrant withdraws u's authorization of a client, along with any
+// codes issued to it that have not been exchanged.
+func RevokeOAuthGrant(tx *pop.Connection, u *User, client uuid.UUID) error {
+	g, err := GetOAuthGrant(tx, u, client)
+	if err != nil {
+		return err
+	}
+
+	err = tx.RawQuery("DELETE FROM oauth_codes WHERE user_id = ? AND client_id = ?", u.ID, client).Exec()
+	if err != nil {
+		return fmt.Errorf("could not remove authorization codes %v", err)
+	}
+
+	return tx.Destroy(g)
+}
+
+// String is not required by pop and may be deleted
+func (g OAuthGrant) String() string {
+	jg, _ := json.Marshal(g)
+	return string(jg)
+}
+
+// OAuthGrants is not required by pop and may be deleted
+type OAuthGrants []OAuthGrant
+
+// String is not required by pop and may be deleted
+func (g OAuthGrants) String() string {
+	jg, _ := json.Marshal(g)
+	return string(jg)
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForcePasswordReset makes u choose a new password before they can log in
+// again, and revokes every session, API key and OAuth grant issued so far.
+func (u *User) ForcePasswordReset(tx *pop.Connection) error {
+	u.PasswordResetRequired = true
+	u.TokensValidAfter = nulls.NewTime(time.Now())
+
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not force password reset %v", err)
+	}
+
+	for _, q := range []string{
+		"DELETE FROM api_keys WHERE user_id = ?",
+		"DELETE FROM oauth_codes WHERE user_id = ?",
+		"DELETE FROM oauth_grants WHERE user_id = ?",
+	} {
+		if err := tx.RawQuery(q, u.ID).Exec(); err != nil {
+			return fmt.Errorf("could not revoke credentials %v", err)
+		}
+	}
+
+	return nil
+}
+
+// TokenRevoked reports whether a session token issued at iat, in seconds
+// since the epoch, has since been revoked. Tokens issued before iat was
+// recorded count as issued at the epoch.
+func (u *User) TokenRevoked(iat int64) bool {
+	return u.TokensValidAfter.Valid && iat < u.TokensValidAfter.Time.Unix()
+}
+
+// CreatePasswordResetToken returns a token that lets u set a new password
+// without the old one. It stops working once the password is changed.
+func (u *User) CreatePasswordResetToken() (string, error) {
+	exp, _ := time.ParseDuration("24h")
+	claims := jwt.MapClaims{
+		"exp": time.Now().Add(exp).Unix(),
+		"jti": u.ID.String(),
+		"sub": TokenPasswordReset,
+		"pwh": hashSecret(u.PasswordHash),
+	}
+
+	return signToken(claims)
+}
+
+// ResetPassword sets a new password for the user named by a password reset
+// token and revokes their existing sessions.
+func ResetPassword(tx *pop.Connection, token string, password string) (*User, error) {
+	claims, err := ParseToken(token, TokenPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := claims["jti"].(string)
+	id, err := uuid.FromString(jti)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify the user")
+	}
+
+	u, err := GetUserByID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if pwh, _ := claims["pwh"].(string); pwh != hashSecret(u.PasswordHash) {
+		return nil, fmt.Errorf("password reset link has already been used")
+	}
+
+	ph, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("cannot hash password")
+	}
+
+	u.PasswordHash = string(ph)
+	u.PasswordResetRequired = false
+	u.TokensValidAfter = nulls.NewTime(time.Now())
+
+	if err := tx.Update(u); err != nil {
+		return nil, fmt.Errorf("could not reset password %v", err)
+	}
+
+	return u, nil
+}
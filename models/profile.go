@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+)
+
+// Limits on what a user can put on their public profile.
+const (
+	MaxDisplayNameLength = 64
+	MaxBioLength         = 2000
+	MaxLocationLength    = 64
+	MaxPronounsLength    = 32
+	MaxLinks             = 10
+	MaxDisciplines       = 10
+	MaxDisciplineLength  = 32
+)
+
+// Profile is the public face of a user, as shown on their page.
+type Profile struct {
+	Username       string     `json:"username"`
+	DisplayName    string     `json:"display_name"`
+	Bio            string     `json:"bio"`
+	Location       string     `json:"location"`
+	Website        string     `json:"website"`
+	Links          []string   `json:"links"`
+	Pronouns       string     `json:"pronouns"`
+	Disciplines    []string   `json:"disciplines"`
+	AvatarURI      string     `json:"avatar_uri"`
+	BannerURI      string     `json:"banner_uri"`
+	Artist         bool       `json:"artist"`
+	VerifiedArtist bool       `json:"verified_artist"`
+	ArtistSince    *time.Time `json:"artist_since,omitempty"`
+	JoinedAt       time.Time  `json:"joined_at"`
+	MediaCount     int        `json:"media_count"`
+	FollowerCount  int        `json:"follower_count"`
+	FollowingCount int        `json:"following_count"`
+}
+
+// Name returns the name the user is shown by: their display name if they set
+// one, otherwise their full name, otherwise their username.
+func (u *User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+
+	if n := strings.TrimSpace(u.FirstName + " " + u.LastName); n != "" {
+		return n
+	}
+
+	return u.Username
+}
+
+// VerifiedArtist reports whether the user is shown with the verified artist
+// badge: their artist status was approved by a reviewer, rather than carried
+// over from when users could declare it themselves.
+func (u *User) VerifiedArtist() bool {
+	return u.Artist && u.ArtistVerifiedAt.Valid
+}
+
+// GetProfile returns the public profile of u.
+func GetProfile(tx *pop.Connection, u *User) (*Profile, error) {
+	p := &Profile{
+		Username:       u.Username,
+		DisplayName:    u.Name(),
+		Bio:            u.Bio,
+		Location:       u.Location,
+		Website:        u.Website,
+		Links:          append([]string{}, u.Links...),
+		Pronouns:       u.Pronouns,
+		Disciplines:    append([]string{}, u.Disciplines...),
+		AvatarURI:      AvatarImage.URI(u),
+		BannerURI:      BannerImage.URI(u),
+		Artist:         u.Artist,
+		VerifiedArtist: u.VerifiedArtist(),
+		JoinedAt:       u.CreatedAt,
+	}
+
+	if u.VerifiedArtist() {
+		since := u.ArtistVerifiedAt.Time
+		p.ArtistSince = &since
+	}
+
+	var err error
+
+	p.MediaCount, err = tx.Where("user_id = ? AND hidden_at IS NULL", u.ID).Count(&Medium{})
+	if err != nil {
+		return nil, fmt.Errorf("could not count media %v", err)
+	}
+
+	// follows by or of suspended accounts are not counted
+	now := time.Now()
+
+	p.FollowerCount, err = tx.Where("followed = ? AND follower NOT IN ("+suspendedIDs+")", u.ID, now).Count(&Follow{})
+	if err != nil {
+		return nil, fmt.Errorf("could not count followers %v", err)
+	}
+
+	p.FollowingCount, err = tx.Where("follower = ? AND followed NOT IN ("+suspendedIDs+")", u.ID, now).Count(&Follow{})
+	if err != nil {
+		return nil, fmt.Errorf("could not count follows %v", err)
+	}
+
+	return p, nil
+}
+
+// validWebURL reports whether s is an absolute http or https address.
+func validWebURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
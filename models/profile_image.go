@@ -0,0 +1,127 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+
+	"github.com/derhabicht/rmuse/storage"
+)
+
+// ProfileImage is one of the images a user can decorate their profile with.
+// Images are cropped to fill the given size.
+type ProfileImage struct {
+	Name   string
+	Width  int
+	Height int
+	field  func(u *User) *nulls.UUID
+}
+
+// AvatarImage is the square picture shown next to a user's name.
+var AvatarImage = ProfileImage{
+	Name:   "avatar",
+	Width:  400,
+	Height: 400,
+	field:  func(u *User) *nulls.UUID { return &u.Avatar },
+}
+
+// BannerImage is the wide picture shown across the top of a user's page.
+var BannerImage = ProfileImage{
+	Name:   "banner",
+	Width:  1500,
+	Height: 500,
+	field:  func(u *User) *nulls.UUID { return &u.Banner },
+}
+
+func (p ProfileImage) key(id uuid.UUID) string {
+	return p.Name + "s/" + id.String() + ".jpg"
+}
+
+// URI returns the address of u's image, or "" if they have not set one.
+func (p ProfileImage) URI(u *User) string {
+	id := p.field(u)
+	if !id.Valid {
+		return ""
+	}
+
+	return storage.URL(p.key(id.UUID))
+}
+
+// Set crops and resizes img, stores it, and makes it u's image in place of
+// any they had before. It returns the storage key of the old image, if there
+// was one, which the caller should delete once the transaction has
+// committed.
+func (p ProfileImage) Set(tx *pop.Connection, u *User, img image.Image) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("could not generate id %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	img = imaging.Fill(img, p.Width, p.Height, imaging.Center, imaging.Lanczos)
+	if err := imaging.Encode(buf, img, imaging.JPEG); err != nil {
+		return "", fmt.Errorf("could not encode %s %v", p.Name, err)
+	}
+
+	if err := storage.Put(p.key(id), buf); err != nil {
+		return "", err
+	}
+
+	old := *p.field(u)
+	*p.field(u) = nulls.NewUUID(id)
+	if err := tx.Update(u); err != nil {
+		storage.Delete(p.key(id))
+		return "", fmt.Errorf("could not set %s %v", p.Name, err)
+	}
+
+	if old.Valid {
+		return p.key(old.UUID), nil
+	}
+
+	return "", nil
+}
+
+// Remove clears u's image. Like Set, it returns the storage key of the old
+// image for the caller to delete once the transaction has committed.
+func (p ProfileImage) Remove(tx *pop.Connection, u *User) (string, error) {
+	old := *p.field(u)
+	if !old.Valid {
+		return "", nil
+	}
+
+	*p.field(u) = nulls.UUID{}
+	if err := tx.Update(u); err != nil {
+		return "", fmt.Errorf("could not remove %s %v", p.Name, err)
+	}
+
+	return p.key(old.UUID), nil
+}
+
+// ProfileImageOwner returns the user whose avatar or banner is stored under
+// key.
+func ProfileImageOwner(tx *pop.Connection, key string) (*User, error) {
+	for _, p := range []ProfileImage{AvatarImage, BannerImage} {
+		name := strings.TrimSuffix(strings.TrimPrefix(key, p.Name+"s/"), ".jpg")
+		id, err := uuid.FromString(name)
+		if err != nil || p.key(id) != key {
+			continue
+		}
+
+		u := Users{}
+		if err := tx.Where(p.Name+" = ?", id).All(&u); err != nil {
+			return nil, fmt.Errorf("could not find user %v", err)
+		}
+
+		if len(u) > 0 {
+			return &u[0], nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not find user")
+}
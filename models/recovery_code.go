@@ -0,0 +1,108 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/validate"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCodeCount is the number of recovery codes issued when a user
+// enables two-factor authentication.
+const recoveryCodeCount = 10
+
+// RecoveryCode is a single-use code that stands in for a TOTP code when the
+// user has lost their authenticator. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `json:"id"         db:"id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	User      uuid.UUID  `json:"-"          db:"user_id"`
+	CodeHash  string     `json:"-"          db:"code_hash"`
+	UsedAt    nulls.Time `json:"used_at"    db:"used_at"`
+}
+
+// GenerateRecoveryCodes replaces any recovery codes u already has with a new
+// set and returns the plaintext codes. They cannot be recovered afterwards.
+func GenerateRecoveryCodes(tx *pop.Connection, u *User) ([]string, error) {
+	err := tx.RawQuery("DELETE FROM recovery_codes WHERE user_id = ?", u.ID).Exec()
+	if err != nil {
+		return nil, fmt.Errorf("could not remove recovery codes %v", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("could not generate recovery code %v", err)
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+
+		h, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("could not hash recovery code %v", err)
+		}
+
+		rc := &RecoveryCode{
+			User:     u.ID,
+			CodeHash: string(h),
+		}
+		if err := tx.Create(rc); err != nil {
+			return nil, fmt.Errorf("could not store recovery code %v", err)
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode consumes one of u's unused recovery codes, reporting
+// whether code matched any of them.
+func UseRecoveryCode(tx *pop.Connection, u *User, code string) bool {
+	rcs := RecoveryCodes{}
+	err := tx.Where("user_id = ? AND used_at IS NULL", u.ID).All(&rcs)
+	if err != nil {
+		return false
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	for _, rc := range rcs {
+		if bcrypt.CompareHashAndPassword([]byte(rc.CodeHash), []byte(code)) == nil {
+			rc.UsedAt = nulls.NewTime(time.Now())
+			return tx.Update(&rc) == nil
+		}
+	}
+
+	return false
+}
+
+// String is not required by pop and may be deleted
+func (r RecoveryCode) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// RecoveryCodes is not required by pop and may be deleted
+type RecoveryCodes []RecoveryCode
+
+// String is not required by pop and may be deleted
+func (r RecoveryCodes) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (r *RecoveryCode) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
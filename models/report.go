@@ -0,0 +1,318 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// MaxReportDetailsLength limits what a reporter or moderator can write about
+// a report.
+const MaxReportDetailsLength = 2000
+
+// Reasons a medium or user can be reported for.
+const (
+	ReportSpam          = "spam"
+	ReportHarassment    = "harassment"
+	ReportHate          = "hate"
+	ReportSexual        = "sexual"
+	ReportViolence      = "violence"
+	ReportCopyright     = "copyright"
+	ReportImpersonation = "impersonation"
+	ReportOther         = "other"
+)
+
+// ReportCategories lists every reason a report can give.
+var ReportCategories = []string{
+	ReportSpam,
+	ReportHarassment,
+	ReportHate,
+	ReportSexual,
+	ReportViolence,
+	ReportCopyright,
+	ReportImpersonation,
+	ReportOther,
+}
+
+// States a report can be in.
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// Report is a user's complaint about a medium or another user, waiting for
+// or bearing a moderator's decision. Reports about a medium also name its
+// owner, so that everything reported about a user can be found together.
+type Report struct {
+	ID         uuid.UUID  `json:"id"          db:"id"`
+	CreatedAt  time.Time  `json:"created_at"  db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"  db:"updated_at"`
+	Reporter   uuid.UUID  `json:"reporter_id" db:"reporter_id"`
+	User       uuid.UUID  `json:"user_id"     db:"user_id"`
+	Medium     nulls.UUID `json:"medium_id"   db:"medium_id"`
+	Category   string     `json:"category"    db:"category"`
+	Details    string     `json:"details"     db:"details"`
+	Status     string     `json:"status"      db:"status"`
+	AssignedTo nulls.UUID `json:"assigned_to" db:"assigned_to"`
+	Action     string     `json:"action"      db:"action"`
+	Note       string     `json:"note"        db:"note"`
+	ResolvedBy nulls.UUID `json:"resolved_by" db:"resolved_by"`
+	ResolvedAt nulls.Time `json:"resolved_at" db:"resolved_at"`
+}
+
+// NewReport starts a report by reporter about u, or about m if it is not
+// nil, in which case u must be its owner.
+func NewReport(reporter *User, u *User, m *Medium, category string, details string) *Report {
+	r := &Report{
+		Reporter: reporter.ID,
+		User:     u.ID,
+		Category: strings.TrimSpace(category),
+		Details:  strings.TrimSpace(details),
+		Status:   ReportOpen,
+	}
+	if m != nil {
+		r.Medium = nulls.NewUUID(m.ID)
+	}
+
+	return r
+}
+
+func (r *Report) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(r)
+}
+
+// Open reports whether the report is waiting for a decision.
+func (r *Report) Open() bool {
+	return r.Status == ReportOpen
+}
+
+// Assign hands the report to moderator, or back to the queue if moderator is
+// nil.
+func (r *Report) Assign(tx *pop.Connection, moderator *User) error {
+	r.AssignedTo = nulls.UUID{}
+	if moderator != nil {
+		r.AssignedTo = nulls.NewUUID(moderator.ID)
+	}
+
+	if err := tx.Update(r); err != nil {
+		return fmt.Errorf("could not assign report %v", err)
+	}
+
+	return nil
+}
+
+// OutcomeMessage tells the reporter how their report was dealt with, without
+// saying what was done to the reported account.
+func (r *Report) OutcomeMessage() string {
+	if r.Status == ReportActioned {
+		return "A moderator has reviewed your report and taken action."
+	}
+
+	return "A moderator has reviewed your report and found that it does not break the rules."
+}
+
+// Resolve closes the report, noting what moderator did about it. Dismissing
+// a report is recorded as the action AuditDismissReport.
+func (r *Report) Resolve(tx *pop.Connection, moderator *User, action string, note string) (*validate.Errors, error) {
+	if !r.Open() {
+		return nil, fmt.Errorf("report has already been %s", r.Status)
+	}
+
+	r.Status = ReportActioned
+	if action == AuditDismissReport {
+		r.Status = ReportDismissed
+	}
+	r.Action = action
+	r.Note = strings.TrimSpace(note)
+	r.ResolvedBy = nulls.NewUUID(moderator.ID)
+	r.ResolvedAt = nulls.NewTime(time.Now())
+
+	return tx.ValidateAndUpdate(r)
+}
+
+// GetReportByID returns the report with the given id.
+func GetReportByID(tx *pop.Connection, id uuid.UUID) (*Report, error) {
+	r := Report{}
+	err := tx.Find(&r, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find report %v", err)
+	}
+
+	return &r, nil
+}
+
+// GetReportsByReporter returns the reports u has made, newest first.
+func GetReportsByReporter(tx *pop.Connection, u *User) (Reports, error) {
+	r := Reports{}
+	err := tx.Where("reporter_id = ?", u.ID).Order("created_at desc").All(&r)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find reports %v", err)
+	}
+
+	return r, nil
+}
+
+// HasOpenReport reports whether reporter is already waiting on a report
+// about u, or about m if it is not nil.
+func HasOpenReport(tx *pop.Connection, reporter *User, u *User, m *Medium) (bool, error) {
+	q := tx.Where("reporter_id = ? AND status = ?", reporter.ID, ReportOpen)
+	if m != nil {
+		q = q.Where("medium_id = ?", m.ID)
+	} else {
+		q = q.Where("user_id = ? AND medium_id IS NULL", u.ID)
+	}
+
+	return q.Exists(&Report{})
+}
+
+// ReportFilter narrows the moderation queue. Empty fields match everything.
+type ReportFilter struct {
+	Status   string
+	Category string
+	// Target is "medium" or "user" to see only reports about media or only
+	// reports about accounts.
+	Target string
+	// AssignedTo limits the queue to one moderator's reports, and Unassigned
+	// to the reports nobody has picked up.
+	AssignedTo nulls.UUID
+	Unassigned bool
+	User       nulls.UUID
+}
+
+// QueuedReport is a report in the moderation queue, with the usernames of
+// its reporter and of the reported user. Either is empty if the account has
+// since been deleted.
+type QueuedReport struct {
+	Report
+	Reporter string `json:"reporter" db:"reporter_username"`
+	Username string `json:"username" db:"username"`
+}
+
+// GetReports returns a page of the reports matching f, oldest first so that
+// the longest waiting are dealt with first.
+func GetReports(tx *pop.Connection, f ReportFilter, page int, perPage int) ([]QueuedReport, error) {
+	query := "SELECT r.*, COALESCE(ru.username, '') AS reporter_username, COALESCE(u.username, '') AS username FROM reports r " +
+		"LEFT JOIN users ru ON ru.id = r.reporter_id " +
+		"LEFT JOIN users u ON u.id = r.user_id " +
+		"WHERE TRUE"
+	args := []interface{}{}
+
+	if f.Status != "" {
+		query += " AND r.status = ?"
+		args = append(args, f.Status)
+	}
+	if f.Category != "" {
+		query += " AND r.category = ?"
+		args = append(args, f.Category)
+	}
+	switch f.Target {
+	case "medium":
+		query += " AND r.medium_id IS NOT NULL"
+	case "user":
+		query += " AND r.medium_id IS NULL"
+	}
+	if f.AssignedTo.Valid {
+		query += " AND r.assigned_to = ?"
+		args = append(args, f.AssignedTo.UUID)
+	}
+	if f.Unassigned {
+		query += " AND r.assigned_to IS NULL"
+	}
+	if f.User.Valid {
+		query += " AND r.user_id = ?"
+		args = append(args, f.User.UUID)
+	}
+
+	query += " ORDER BY r.created_at LIMIT ? OFFSET ?"
+	args = append(args, perPage, (page-1)*perPage)
+
+	r := []QueuedReport{}
+	if err := tx.RawQuery(query, args...).All(&r); err != nil {
+		return nil, fmt.Errorf("could not find reports %v", err)
+	}
+
+	return r, nil
+}
+
+// validReportCategory reports whether c is one of ReportCategories.
+func validReportCategory(c string) bool {
+	for _, rc := range ReportCategories {
+		if c == rc {
+			return true
+		}
+	}
+	return false
+}
+
+// String is not required by pop and may be deleted
+func (r Report) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Reports is not required by pop and may be deleted
+type Reports []Report
+
+// String is not required by pop and may be deleted
+func (r Reports) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (r *Report) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "User",
+			Message: "you cannot report yourself",
+			Fn: func() bool {
+				return r.Reporter != r.User
+			},
+		},
+		&validators.FuncValidator{
+			Field:   strings.Join(ReportCategories, ", "),
+			Name:    "Category",
+			Message: "category must be one of %s",
+			Fn: func() bool {
+				return validReportCategory(r.Category)
+			},
+		},
+		&validators.FuncValidator{
+			Field:   "",
+			Name:    "Details",
+			Message: "details are required when the category is other",
+			Fn: func() bool {
+				return r.Category != ReportOther || r.Details != ""
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxReportDetailsLength),
+			Name:    "Details",
+			Message: "details must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(r.Details) <= MaxReportDetailsLength
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxReportDetailsLength),
+			Name:    "Note",
+			Message: "note must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(r.Note) <= MaxReportDetailsLength
+			},
+		},
+	), nil
+}
@@ -0,0 +1,406 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
+	"github.com/markbates/validate"
+	"github.com/markbates/validate/validators"
+	"github.com/satori/go.uuid"
+)
+
+// Permissions a role can carry.
+const (
+	PermissionMediaUpload     = "media:upload"
+	PermissionContentModerate = "content:moderate"
+	PermissionArtistsReview   = "artists:review"
+	PermissionUsersManage     = "users:manage"
+	PermissionRolesManage     = "roles:manage"
+)
+
+// Permissions lists every permission a role can carry.
+var Permissions = []string{
+	PermissionMediaUpload,
+	PermissionContentModerate,
+	PermissionArtistsReview,
+	PermissionUsersManage,
+	PermissionRolesManage,
+}
+
+// ValidPermission reports whether p is a known permission.
+func ValidPermission(p string) bool {
+	for _, s := range Permissions {
+		if s == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Built in roles. Every user is a listener, and artists are those with the
+// artist flag set by an approved application; neither is stored in
+// user_roles.
+const (
+	RoleListener  = "listener"
+	RoleArtist    = "artist"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// BuiltinRoles are the roles every installation has. They cannot be changed
+// or deleted.
+var BuiltinRoles = Roles{
+	{Name: RoleListener, Description: "Follows artists and views their media", Permissions: slices.String{}, Builtin: true},
+	{Name: RoleArtist, Description: "Uploads media", Permissions: slices.String{PermissionMediaUpload}, Builtin: true},
+	{Name: RoleModerator, Description: "Reviews reported content and artist applications", Permissions: slices.String{PermissionContentModerate, PermissionArtistsReview}, Builtin: true},
+	{Name: RoleAdmin, Description: "Manages users and roles", Permissions: slices.String(Permissions), Builtin: true},
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,29}$`)
+
+// Role is a named set of permissions. Custom roles are stored in the roles
+// table; built in roles are not.
+type Role struct {
+	ID          uuid.UUID     `json:"-"           db:"id"`
+	CreatedAt   time.Time     `json:"-"           db:"created_at"`
+	UpdatedAt   time.Time     `json:"-"           db:"updated_at"`
+	Name        string        `json:"name"        db:"name"`
+	Description string        `json:"description" db:"description"`
+	Permissions slices.String `json:"permissions" db:"permissions"`
+	Builtin     bool          `json:"builtin"     db:"-"`
+}
+
+// UserRole grants a stored role to a user.
+type UserRole struct {
+	ID        uuid.UUID  `json:"-"          db:"id"`
+	CreatedAt time.Time  `json:"granted_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"-"          db:"updated_at"`
+	User      uuid.UUID  `json:"-"          db:"user_id"`
+	Role      string     `json:"role"       db:"role"`
+	GrantedBy nulls.UUID `json:"-"          db:"granted_by"`
+}
+
+func (r *Role) Create(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndCreate(r)
+}
+
+func (r *Role) Update(tx *pop.Connection) (*validate.Errors, error) {
+	return tx.ValidateAndUpdate(r)
+}
+
+// HasPermission reports whether the role carries p.
+func (r *Role) HasPermission(p string) bool {
+	for _, s := range r.Permissions {
+		if s == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+func builtinRole(name string) *Role {
+	for i := range BuiltinRoles {
+		if BuiltinRoles[i].Name == name {
+			r := BuiltinRoles[i]
+			return &r
+		}
+	}
+
+	return nil
+}
+
+// GetRoles returns the built in roles followed by the custom ones.
+func GetRoles(tx *pop.Connection) (Roles, error) {
+	custom := Roles{}
+	if err := tx.Order("name").All(&custom); err != nil {
+		return nil, fmt.Errorf("could not find roles %v", err)
+	}
+
+	roles := append(Roles{}, BuiltinRoles...)
+	return append(roles, custom...), nil
+}
+
+// GetRoleByName returns the built in or custom role with the given name.
+func GetRoleByName(tx *pop.Connection, name string) (*Role, error) {
+	if r := builtinRole(name); r != nil {
+		return r, nil
+	}
+
+	r := Role{}
+	err := tx.Where("name = ?", name).First(&r)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find role %v", err)
+	}
+
+	return &r, nil
+}
+
+// DeleteRole deletes a custom role and takes it from everyone who held it.
+func DeleteRole(tx *pop.Connection, r *Role) error {
+	if r.Builtin {
+		return fmt.Errorf("built in role %s cannot be deleted", r.Name)
+	}
+
+	if err := tx.RawQuery("DELETE FROM user_roles WHERE role = ?", r.Name).Exec(); err != nil {
+		return fmt.Errorf("could not revoke role %v", err)
+	}
+
+	return tx.Destroy(r)
+}
+
+// RoleNames returns the names of the roles u holds, the implied ones first.
+func (u *User) RoleNames(tx *pop.Connection) ([]string, error) {
+	names := []string{RoleListener}
+	if u.Artist && u.ArtistVerifiedAt.Valid {
+		names = append(names, RoleArtist)
+	}
+
+	granted := UserRoles{}
+	if err := tx.Where("user_id = ?", u.ID).Order("created_at").All(&granted); err != nil {
+		return nil, fmt.Errorf("could not find roles %v", err)
+	}
+
+	for _, g := range granted {
+		names = append(names, g.Role)
+	}
+
+	return names, nil
+}
+
+// HasRole reports whether u holds the named role.
+func (u *User) HasRole(tx *pop.Connection, name string) (bool, error) {
+	names, err := u.RoleNames(tx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// PermissionSet returns every permission u holds through their roles.
+func (u *User) PermissionSet(tx *pop.Connection) ([]string, error) {
+	names, err := u.RoleNames(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	held := map[string]bool{}
+	for _, n := range names {
+		r, err := GetRoleByName(tx, n)
+		if err != nil {
+			continue
+		}
+		for _, p := range r.Permissions {
+			held[p] = true
+		}
+	}
+
+	perms := []string{}
+	for _, p := range Permissions {
+		if held[p] {
+			perms = append(perms, p)
+		}
+	}
+
+	return perms, nil
+}
+
+// HasPermission reports whether any of u's roles carries p.
+func (u *User) HasPermission(tx *pop.Connection, p string) (bool, error) {
+	perms, err := u.PermissionSet(tx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range perms {
+		if s == p {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GrantRole gives u the role r. by is the user granting it, if any.
+func GrantRole(tx *pop.Connection, u *User, r *Role, by *User) error {
+	switch r.Name {
+	case RoleListener:
+		return nil
+	case RoleArtist:
+		u.Artist = true
+		if !u.ArtistVerifiedAt.Valid {
+			u.ArtistVerifiedAt = nulls.NewTime(time.Now())
+		}
+		if err := tx.Update(u); err != nil {
+			return fmt.Errorf("could not grant role %v", err)
+		}
+		return nil
+	}
+
+	held, err := u.HasRole(tx, r.Name)
+	if err != nil || held {
+		return err
+	}
+
+	g := &UserRole{
+		User: u.ID,
+		Role: r.Name,
+	}
+	if by != nil {
+		g.GrantedBy = nulls.NewUUID(by.ID)
+	}
+
+	if err := tx.Create(g); err != nil {
+		return fmt.Errorf("could not grant role %v", err)
+	}
+
+	return nil
+}
+
+// RevokeRole takes the role r from u. The listener role cannot be revoked,
+// and the last admin cannot lose the admin role.
+func RevokeRole(tx *pop.Connection, u *User, r *Role) error {
+	switch r.Name {
+	case RoleListener:
+		return fmt.Errorf("role %s cannot be revoked", r.Name)
+	case RoleArtist:
+		u.Artist = false
+		u.ArtistVerifiedAt = nulls.Time{}
+		if err := tx.Update(u); err != nil {
+			return fmt.Errorf("could not revoke role %v", err)
+		}
+		return nil
+	case RoleAdmin:
+		n, err := tx.Where("role = ? AND user_id != ?", RoleAdmin, u.ID).Count(&UserRole{})
+		if err != nil {
+			return fmt.Errorf("could not count admins %v", err)
+		}
+		if n == 0 {
+			return fmt.Errorf("cannot revoke the last admin")
+		}
+	}
+
+	err := tx.RawQuery("DELETE FROM user_roles WHERE user_id = ? AND role = ?", u.ID, r.Name).Exec()
+	if err != nil {
+		return fmt.Errorf("could not revoke role %v", err)
+	}
+
+	return nil
+}
+
+// SolePermissionHolder reports whether u is the only active account whose
+// roles carry p, so that suspending them would leave no one able to use it.
+func (u *User) SolePermissionHolder(tx *pop.Connection, p string) (bool, error) {
+	roles, err := GetRoles(tx)
+	if err != nil {
+		return false, err
+	}
+
+	names := []interface{}{}
+	for _, r := range roles {
+		if r.HasPermission(p) {
+			names = append(names, r.Name)
+		}
+	}
+	if len(names) == 0 {
+		return false, nil
+	}
+	in := "(?" + strings.Repeat(", ?", len(names)-1) + ")"
+
+	n, err := tx.Where("role IN "+in+" AND user_id != ? AND user_id NOT IN ("+hiddenAuthors+")", append(names, u.ID, time.Now())...).Count(&UserRole{})
+	if err != nil {
+		return false, fmt.Errorf("could not count role holders %v", err)
+	}
+
+	return n == 0, nil
+}
+
+// String is not required by pop and may be deleted
+func (r Role) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Roles is not required by pop and may be deleted
+type Roles []Role
+
+// String is not required by pop and may be deleted
+func (r Roles) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// String is not required by pop and may be deleted
+func (g UserRole) String() string {
+	jg, _ := json.Marshal(g)
+	return string(jg)
+}
+
+// UserRoles is not required by pop and may be deleted
+type UserRoles []UserRole
+
+// String is not required by pop and may be deleted
+func (g UserRoles) String() string {
+	jg, _ := json.Marshal(g)
+	return string(jg)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (r *Role) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	var err error
+	return validate.Validate(
+		&validators.FuncValidator{
+			Field:   r.Name,
+			Name:    "Name",
+			Message: "role name %s must be 2-30 lower case letters, digits, '_' or '-', starting with a letter",
+			Fn: func() bool {
+				return roleNamePattern.MatchString(r.Name)
+			},
+		},
+		&validators.FuncValidator{
+			Field:   r.Name,
+			Name:    "Name",
+			Message: "role %s already exists",
+			Fn: func() bool {
+				if builtinRole(r.Name) != nil {
+					return false
+				}
+				var b bool
+				b, err = tx.Where("name = ? AND id != ?", r.Name, r.ID).Exists(r)
+				if err != nil {
+					return false
+				}
+				return !b
+			},
+		},
+		&validators.FuncValidator{
+			Field:   strings.Join(r.Permissions, ", "),
+			Name:    "Permissions",
+			Message: "unknown permission in %s",
+			Fn: func() bool {
+				for _, p := range r.Permissions {
+					if !ValidPermission(p) {
+						return false
+					}
+				}
+				return true
+			},
+		},
+	), err
+}
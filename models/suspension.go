@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+)
+
+// MaxAppealLength limits what a suspended user can write in an appeal.
+const MaxAppealLength = 2000
+
+// suspendedIDs selects the ids of the accounts that are suspended at the
+// time given as its parameter.
+const suspendedIDs = "SELECT id FROM users WHERE suspended_at IS NOT NULL AND (suspended_until IS NULL OR suspended_until > ?)"
+
+// hiddenAuthors selects the ids of the accounts whose content and activity
+// are not shown to others: those that are deactivated, and those suspended at
+// the time given as its parameter.
+const hiddenAuthors = "SELECT id FROM users WHERE deactivated_at IS NOT NULL UNION " + suspendedIDs
+
+// Suspended reports whether the account is suspended. A suspension with an
+// expiry lapses by itself once the expiry has passed.
+func (u *User) Suspended() bool {
+	if !u.SuspendedAt.Valid {
+		return false
+	}
+
+	return !u.SuspendedUntil.Valid || time.Now().Before(u.SuspendedUntil.Time)
+}
+
+// Suspend stops u from logging in or using the API, for the given reason,
+// until they are unsuspended or until passes if it is valid. Suspending an
+// account that is already suspended replaces the reason and expiry but keeps
+// any appeal.
+func (u *User) Suspend(tx *pop.Connection, reason string, until nulls.Time) error {
+	if !u.Suspended() {
+		u.SuspendedAt = nulls.NewTime(time.Now())
+		u.SuspensionAppeal = ""
+		u.SuspensionAppealedAt = nulls.Time{}
+	}
+
+	u.SuspensionReason = strings.TrimSpace(reason)
+	u.SuspendedUntil = until
+
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not suspend account %v", err)
+	}
+
+	return nil
+}
+
+// Unsuspend lifts u's suspension and clears its reason and any appeal.
+func (u *User) Unsuspend(tx *pop.Connection) error {
+	if !u.SuspendedAt.Valid {
+		return nil
+	}
+
+	u.SuspendedAt = nulls.Time{}
+	u.SuspendedUntil = nulls.Time{}
+	u.SuspensionReason = ""
+	u.SuspensionAppeal = ""
+	u.SuspensionAppealedAt = nulls.Time{}
+
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not unsuspend account %v", err)
+	}
+
+	return nil
+}
+
+// Appeal records u's appeal against their suspension. Only one appeal can be
+// made per suspension.
+func (u *User) Appeal(tx *pop.Connection, appeal string) error {
+	if !u.Suspended() {
+		return fmt.Errorf("account is not suspended")
+	}
+
+	if u.SuspensionAppealedAt.Valid {
+		return fmt.Errorf("suspension has already been appealed")
+	}
+
+	appeal = strings.TrimSpace(appeal)
+	if appeal == "" {
+		return fmt.Errorf("appeal is empty")
+	}
+
+	if utf8.RuneCountInString(appeal) > MaxAppealLength {
+		return fmt.Errorf("appeal must be at most %d characters", MaxAppealLength)
+	}
+
+	u.SuspensionAppeal = appeal
+	u.SuspensionAppealedAt = nulls.NewTime(time.Now())
+
+	if err := tx.Update(u); err != nil {
+		return fmt.Errorf("could not record appeal %v", err)
+	}
+
+	return nil
+}
+
+// GetSuspensionAppeals returns the suspended users who have appealed, oldest
+// appeal first.
+func GetSuspensionAppeals(tx *pop.Connection) (Users, error) {
+	users := Users{}
+	q := tx.Where("suspension_appealed_at IS NOT NULL AND id IN ("+suspendedIDs+")", time.Now())
+	if err := q.Order("suspension_appealed_at").All(&users); err != nil {
+		return nil, fmt.Errorf("could not find appeals %v", err)
+	}
+
+	return users, nil
+}
+
+// CreateSuspensionAppealToken returns a token that lets u, while suspended,
+// appeal their suspension. It is issued in place of a session token when a
+// suspended user logs in.
+func (u *User) CreateSuspensionAppealToken() (string, error) {
+	claims := jwt.MapClaims{
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"jti": u.ID.String(),
+		"sub": TokenSuspensionAppeal,
+	}
+
+	return signToken(claims)
+}
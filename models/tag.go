@@ -0,0 +1,218 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/satori/go.uuid"
+)
+
+// MaxTagLength is the longest hashtag that is indexed.
+const MaxTagLength = 64
+
+var (
+	// mentionPattern matches @username, but not the @ in an email address.
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([\p{L}\p{N}][\p{L}\p{N}_.\-]*)`)
+
+	// hashtagPattern matches #tag, but not an HTML entity such as &#39;.
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])#([\p{L}\p{N}_]+)`)
+)
+
+// ParseMentions returns the usernames mentioned in text, each once, in the
+// order they first appear. Punctuation ending a sentence is not taken as part
+// of a username.
+func ParseMentions(text string) []string {
+	mentions := []string{}
+	seen := map[string]bool{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		username := strings.TrimRight(match[1], ".-")
+		if key := CanonicalUsername(username); !seen[key] {
+			seen[key] = true
+			mentions = append(mentions, username)
+		}
+	}
+
+	return mentions
+}
+
+// ParseHashtags returns the hashtags in text, lower cased and without the #,
+// each once, in the order they first appear. Tags longer than MaxTagLength
+// are left out.
+func ParseHashtags(text string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+
+	for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(match[1])
+		if utf8.RuneCountInString(tag) <= MaxTagLength && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// MediumTag indexes a medium under a hashtag in its caption.
+type MediumTag struct {
+	ID        uuid.UUID `json:"id"         db:"id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"-"          db:"updated_at"`
+	Medium    uuid.UUID `json:"medium_id"  db:"medium_id"`
+	Tag       string    `json:"tag"        db:"tag"`
+}
+
+// String is not required by pop and may be deleted
+func (t MediumTag) String() string {
+	jt, _ := json.Marshal(t)
+	return string(jt)
+}
+
+// MediumTags is not required by pop and may be deleted
+type MediumTags []MediumTag
+
+// String is not required by pop and may be deleted
+func (t MediumTags) String() string {
+	jt, _ := json.Marshal(t)
+	return string(jt)
+}
+
+// Mention links a user to a medium's caption, or a comment on it, that
+// mentions them. A mention edited out of the text is kept as removed, so that
+// the user is not told again if it is put back.
+type Mention struct {
+	ID        uuid.UUID  `json:"id"         db:"id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"-"          db:"updated_at"`
+	User      uuid.UUID  `json:"user_id"    db:"user_id"`
+	Author    uuid.UUID  `json:"author_id"  db:"author_id"`
+	Medium    uuid.UUID  `json:"medium_id"  db:"medium_id"`
+	Comment   nulls.UUID `json:"comment_id" db:"comment_id"`
+	RemovedAt nulls.Time `json:"-"          db:"removed_at"`
+}
+
+// String is not required by pop and may be deleted
+func (m Mention) String() string {
+	jm, _ := json.Marshal(m)
+	return string(jm)
+}
+
+// Mentions is not required by pop and may be deleted
+type Mentions []Mention
+
+// String is not required by pop and may be deleted
+func (m Mentions) String() string {
+	jm, _ := json.Marshal(m)
+	return string(jm)
+}
+
+// recordMentions links the users mentioned in text, written by author in
+// m's caption or in comment if it is valid, replacing the links from an
+// earlier version of the text. Mentions of the author, of accounts that are
+// deactivated or suspended, and of users who cannot see m are ignored. It
+// returns the users who were never mentioned in the text before.
+func recordMentions(tx *pop.Connection, author uuid.UUID, m *Medium, comment nulls.UUID, text string) ([]*User, error) {
+	q := tx.Where("medium_id = ? AND comment_id IS NULL", m.ID)
+	if comment.Valid {
+		q = tx.Where("comment_id = ?", comment.UUID)
+	}
+
+	existing := Mentions{}
+	if err := q.All(&existing); err != nil {
+		return nil, fmt.Errorf("could not find mentions %v", err)
+	}
+
+	before := map[uuid.UUID]Mention{}
+	for _, e := range existing {
+		before[e.User] = e
+	}
+
+	mentioned := []*User{}
+	kept := map[uuid.UUID]bool{}
+
+	for _, username := range ParseMentions(text) {
+		u, err := GetUserByUsername(tx, username)
+		if err != nil || u.ID == author || kept[u.ID] || u.Deactivated() || u.Suspended() {
+			continue
+		}
+
+		if _, err := GetMediumByID(tx, m.ID, u); err != nil {
+			continue
+		}
+
+		kept[u.ID] = true
+		if e, ok := before[u.ID]; ok {
+			if e.RemovedAt.Valid {
+				e.RemovedAt = nulls.Time{}
+				if err := tx.Update(&e); err != nil {
+					return nil, fmt.Errorf("could not restore mention %v", err)
+				}
+			}
+			continue
+		}
+
+		mn := &Mention{User: u.ID, Author: author, Medium: m.ID, Comment: comment}
+		if err := tx.Create(mn); err != nil {
+			return nil, fmt.Errorf("could not record mention %v", err)
+		}
+		mentioned = append(mentioned, u)
+	}
+
+	for id, e := range before {
+		if !kept[id] && !e.RemovedAt.Valid {
+			e.RemovedAt = nulls.NewTime(time.Now())
+			if err := tx.Update(&e); err != nil {
+				return nil, fmt.Errorf("could not remove mention %v", err)
+			}
+		}
+	}
+
+	return mentioned, nil
+}
+
+// IndexCaption indexes the medium under the hashtags in its caption and links
+// the users it mentions, replacing any earlier index. It returns the users
+// newly mentioned.
+func (m *Medium) IndexCaption(tx *pop.Connection) ([]*User, error) {
+	if err := tx.RawQuery("DELETE FROM media_tags WHERE medium_id = ?", m.ID).Exec(); err != nil {
+		return nil, fmt.Errorf("could not clear tags %v", err)
+	}
+
+	for _, tag := range ParseHashtags(m.Caption) {
+		if err := tx.Create(&MediumTag{Medium: m.ID, Tag: tag}); err != nil {
+			return nil, fmt.Errorf("could not tag medium %v", err)
+		}
+	}
+
+	return recordMentions(tx, m.User, m, nulls.UUID{}, m.Caption)
+}
+
+// IndexMentions links the users the comment mentions, replacing any earlier
+// links. It returns the users newly mentioned.
+func (c *Comment) IndexMentions(tx *pop.Connection, m *Medium) ([]*User, error) {
+	return recordMentions(tx, c.User, m, nulls.NewUUID(c.ID), c.Body)
+}
+
+// GetMediaByTag returns a page of the media tagged with tag, newest first,
+// leaving out those viewer, who may be nil, may not see or hides with their
+// content preference p, and marking those they blur.
+func GetMediaByTag(tx *pop.Connection, tag string, viewer *User, p *ContentPreference, page int, perPage int) (Media, error) {
+	cond, args := visibleMedia(viewer, p)
+	args = append([]interface{}{strings.ToLower(strings.TrimPrefix(tag, "#"))}, args...)
+	args = append(args, perPage, (page-1)*perPage)
+
+	media := Media{}
+	q := tx.RawQuery("SELECT media.* FROM media JOIN media_tags ON media_tags.medium_id = media.id WHERE media_tags.tag = ? AND "+cond+" ORDER BY media_tags.created_at DESC LIMIT ? OFFSET ?", args...)
+	if err := q.All(&media); err != nil {
+		return nil, fmt.Errorf("could not find tagged media %v", err)
+	}
+
+	return FilterMedia(media, viewer, p), nil
+}
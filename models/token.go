@@ -0,0 +1,113 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gobuffalo/envy"
+)
+
+// Purposes for tokens that must not be accepted as session tokens. Session
+// tokens carry no subject.
+const (
+	TokenEmailVerification = "email_verification"
+	TokenMFA               = "mfa_pending"
+	TokenOIDCState         = "oidc_state"
+	TokenAccountDeletion   = "account_deletion"
+	TokenExportDownload    = "export_download"
+	TokenPasswordReset     = "password_reset"
+	TokenSuspensionAppeal  = "suspension_appeal"
+)
+
+// OAuthClaims are the claims of a session token issued to an OAuth client.
+type OAuthClaims struct {
+	jwt.StandardClaims
+	Client string `json:"cid"`
+	Scope  string `json:"scope"`
+}
+
+func jwtKey() ([]byte, error) {
+	sk, err := ioutil.ReadFile(envy.Get("JWT_KEY_PATH", "jwtRS256.key"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open jwt key, %v", err)
+	}
+
+	return sk, nil
+}
+
+func signToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signingKey, err := jwtKey()
+	if err != nil {
+		return "", err
+	}
+
+	tokenString, err := token.SignedString(signingKey)
+	if err != nil {
+		return "", fmt.Errorf("could sign token, %v", err)
+	}
+
+	return tokenString, nil
+}
+
+// CreateOIDCStateToken returns the state parameter for an OpenID Connect
+// login. It carries the nonce the ID token must contain, so no server-side
+// session is needed.
+func CreateOIDCStateToken(nonce string) (string, error) {
+	claims := jwt.MapClaims{
+		"exp":   time.Now().Add(10 * time.Minute).Unix(),
+		"sub":   TokenOIDCState,
+		"nonce": nonce,
+	}
+
+	return signToken(claims)
+}
+
+// ParseToken validates tokenString and returns its claims if it was issued
+// for the given purpose. Pass an empty purpose to parse a session token.
+func ParseToken(tokenString string, purpose string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return jwtKey()
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the token, %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("failed to validate token: %v", claims)
+	}
+
+	if sub, _ := claims["sub"].(string); sub != purpose {
+		return nil, fmt.Errorf("token was not issued for this purpose")
+	}
+
+	return claims, nil
+}
+
+// newSecret returns a random hex-encoded string with n bytes of entropy.
+func newSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate secret %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// hashSecret returns the hash under which a random secret such as an API key
+// is stored. The secrets are long enough that a fast hash is sufficient.
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
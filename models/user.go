@@ -3,28 +3,73 @@ package models
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gobuffalo/envy"
 	"github.com/markbates/pop"
+	"github.com/markbates/pop/nulls"
+	"github.com/markbates/pop/slices"
 	"github.com/markbates/validate"
 	"github.com/markbates/validate/validators"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
 	"github.com/satori/go.uuid"
 )
 
 type User struct {
-	ID           uuid.UUID `json:"user_id"    db:"id"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
-	Email        string    `json:"email"      db:"email"`
-	Username     string    `json:"username"   db:"username"`
-	FirstName    string    `json:"firstname"  db:"first_name"`
-	LastName     string    `json:"lastname"   db:"last_name"`
-	Artist       bool      `json:"artist"     db:"artist"`
-	PasswordHash string    `json:"-"          db:"password_hash"`
+	ID                    uuid.UUID     `json:"user_id"                 db:"id"`
+	CreatedAt             time.Time     `json:"created_at"              db:"created_at"`
+	UpdatedAt             time.Time     `json:"updated_at"              db:"updated_at"`
+	Email                 string        `json:"email"                   db:"email"`
+	EmailVerifiedAt       nulls.Time    `json:"email_verified_at"       db:"email_verified_at"`
+	Username              string        `json:"username"                db:"username"`
+	UsernameSkeleton      string        `json:"-"                       db:"username_skeleton"`
+	FirstName             string        `json:"firstname"               db:"first_name"`
+	LastName              string        `json:"lastname"                db:"last_name"`
+	Artist                bool          `json:"artist"                  db:"artist"`
+	ArtistVerifiedAt      nulls.Time    `json:"artist_verified_at"      db:"artist_verified_at"`
+	PasswordHash          string        `json:"-"                       db:"password_hash"`
+	TOTPSecret            string        `json:"-"                       db:"totp_secret"`
+	TOTPEnabled           bool          `json:"totp_enabled"            db:"totp_enabled"`
+	TOTPLastStep          int64         `json:"-"                       db:"totp_last_step"`
+	Avatar                nulls.UUID    `json:"-"                       db:"avatar"`
+	Banner                nulls.UUID    `json:"-"                       db:"banner"`
+	DisplayName           string        `json:"display_name"            db:"display_name"`
+	Bio                   string        `json:"bio"                     db:"bio"`
+	Location              string        `json:"location"                db:"location"`
+	Website               string        `json:"website"                 db:"website"`
+	Links                 slices.String `json:"links"                   db:"links"`
+	Pronouns              string        `json:"pronouns"                db:"pronouns"`
+	Disciplines           slices.String `json:"disciplines"             db:"disciplines"`
+	DeactivatedAt         nulls.Time    `json:"deactivated_at"          db:"deactivated_at"`
+	DeletionRequestedAt   nulls.Time    `json:"deletion_requested_at"   db:"deletion_requested_at"`
+	SuspendedAt           nulls.Time    `json:"suspended_at"            db:"suspended_at"`
+	SuspendedUntil        nulls.Time    `json:"suspended_until"         db:"suspended_until"`
+	SuspensionReason      string        `json:"suspension_reason"       db:"suspension_reason"`
+	SuspensionAppeal      string        `json:"suspension_appeal"       db:"suspension_appeal"`
+	SuspensionAppealedAt  nulls.Time    `json:"suspension_appealed_at"  db:"suspension_appealed_at"`
+	AgeConfirmedAt        nulls.Time    `json:"age_confirmed_at"        db:"age_confirmed_at"`
+	PasswordResetRequired bool          `json:"password_reset_required" db:"password_reset_required"`
+	TokensValidAfter      nulls.Time    `json:"-"                       db:"tokens_valid_after"`
+}
+
+// MarshalJSON adds the addresses of the user's avatar and banner to their
+// JSON.
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+
+	return json.Marshal(struct {
+		user
+		AvatarURI string `json:"avatar_uri"`
+		BannerURI string `json:"banner_uri"`
+	}{
+		user:      user(u),
+		AvatarURI: AvatarImage.URI(&u),
+		BannerURI: BannerImage.URI(&u),
+	})
 }
 
 func (u *User) CreateJWTToken() (string, error) {
@@ -32,31 +77,144 @@ func (u *User) CreateJWTToken() (string, error) {
 	exp, _ := time.ParseDuration("168h")
 	claims := jwt.StandardClaims{
 		ExpiresAt: time.Now().Add(exp).Unix(),
+		IssuedAt:  time.Now().Unix(),
 		Id:        u.ID.String(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signingKey, err := ioutil.ReadFile(envy.Get("JWT_KEY_PATH", "jwtRS256.key"))
-	if err != nil {
-		return "", fmt.Errorf("could not open jwt key, %v", err)
+	return signToken(claims)
+}
+
+// CreateOAuthToken returns a session token issued to an OAuth client on the
+// user's behalf. It only allows the given scopes, and stops working if the
+// user revokes the client's access.
+func (u *User) CreateOAuthToken(client uuid.UUID, scopes []string, ttl time.Duration) (string, error) {
+	claims := OAuthClaims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        u.ID.String(),
+		},
+		Client: client.String(),
+		Scope:  strings.Join(scopes, " "),
 	}
 
-	tokenString, err := token.SignedString(signingKey)
+	return signToken(claims)
+}
+
+// CreateEmailVerificationToken returns a token that confirms ownership of the
+// user's current email address. The token stops working if the address is
+// changed before it is used.
+func (u *User) CreateEmailVerificationToken() (string, error) {
+	exp, _ := time.ParseDuration("48h")
+	claims := jwt.MapClaims{
+		"exp":   time.Now().Add(exp).Unix(),
+		"jti":   u.ID.String(),
+		"sub":   TokenEmailVerification,
+		"email": u.Email,
+	}
+
+	return signToken(claims)
+}
+
+// CreateMFAToken returns a short-lived token showing that the user has given
+// the correct password but still has to supply a TOTP code before they are
+// issued a session token.
+func (u *User) CreateMFAToken() (string, error) {
+	exp, _ := time.ParseDuration("5m")
+	claims := jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(exp).Unix(),
+		Id:        u.ID.String(),
+		Subject:   TokenMFA,
+	}
+
+	return signToken(claims)
+}
+
+// BeginTOTPEnrollment gives the user a new TOTP secret and returns its
+// provisioning URI. Two-factor authentication stays disabled until a code
+// generated from the secret is confirmed.
+func (u *User) BeginTOTPEnrollment() (string, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      envy.Get("TOTP_ISSUER", "rmuse"),
+		AccountName: u.Email,
+	})
 	if err != nil {
-		return "", fmt.Errorf("could sign token, %v", err)
+		return "", fmt.Errorf("could not generate totp secret %v", err)
 	}
 
-	return tokenString, nil
+	u.TOTPSecret = key.Secret()
+	u.TOTPEnabled = false
+	u.TOTPLastStep = 0
+
+	return key.URL(), nil
+}
+
+// totpPeriod is how many seconds each TOTP code is valid for.
+const totpPeriod = 30
+
+// UseTOTP reports whether code is a current TOTP code for the user's secret,
+// one step either side of now, and marks it used. A code from the same or an
+// earlier step than the last one accepted is refused, so codes cannot be
+// replayed.
+func (u *User) UseTOTP(tx *pop.Connection, code string) bool {
+	if u.TOTPSecret == "" {
+		return false
+	}
+
+	opts := totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	code = strings.TrimSpace(code)
+	now := time.Now()
+	for skew := -1; skew <= 1; skew++ {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		step := t.Unix() / totpPeriod
+		if step <= u.TOTPLastStep {
+			continue
+		}
+
+		if ok, err := totp.ValidateCustom(code, u.TOTPSecret, t, opts); err == nil && ok {
+			u.TOTPLastStep = step
+			return tx.Update(u) == nil
+		}
+	}
+
+	return false
+}
+
+// EmailVerified reports whether the user has confirmed their email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt.Valid
+}
+
+// SetEmail changes the user's email address and reports whether it differs
+// from the current one. A changed address is marked unverified.
+func (u *User) SetEmail(email string) bool {
+	email = strings.ToLower(email)
+	if email == u.Email {
+		return false
+	}
+
+	u.Email = email
+	u.EmailVerifiedAt = nulls.Time{}
+	return true
 }
 
 func (u *User) Create(tx *pop.Connection) (*validate.Errors, error) {
 	u.Email = strings.ToLower(u.Email)
+	u.Username = CanonicalUsername(u.Username)
+	u.UsernameSkeleton = usernameSkeleton(u.Username)
 
 	return tx.ValidateAndCreate(u)
 }
 
 func (u *User) Update(tx *pop.Connection) (*validate.Errors, error) {
 	u.Email = strings.ToLower(u.Email)
+	u.Username = CanonicalUsername(u.Username)
+	u.UsernameSkeleton = usernameSkeleton(u.Username)
 
 	return tx.ValidateAndUpdate(u)
 }
@@ -91,7 +249,54 @@ func GetUserByID(tx *pop.Connection, id uuid.UUID) (*User, error) {
 	return &u, nil
 }
 
+// GetUserByEmail returns the user with the given email address.
+func GetUserByEmail(tx *pop.Connection, email string) (*User, error) {
+	u := User{}
+	err := tx.Where("email = ?", email).First(&u)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find user %v", err)
+	}
+
+	return &u, nil
+}
+
+// VerifyEmail marks the email address named in a verification token as
+// verified.
+func VerifyEmail(tx *pop.Connection, token string) (*User, error) {
+	claims, err := ParseToken(token, TokenEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := claims["jti"].(string)
+	id, err := uuid.FromString(jti)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify the user")
+	}
+
+	u, err := GetUserByID(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if email, _ := claims["email"].(string); email != u.Email {
+		return nil, fmt.Errorf("email address has changed since the token was issued")
+	}
+
+	if !u.EmailVerified() {
+		u.EmailVerifiedAt = nulls.NewTime(time.Now())
+		if err := tx.Update(u); err != nil {
+			return nil, fmt.Errorf("could not verify email %v", err)
+		}
+	}
+
+	return u, nil
+}
+
+// GetUserByUsername returns the user who holds the given username now.
 func GetUserByUsername(tx *pop.Connection, username string) (*User, error) {
+	username = CanonicalUsername(username)
 	u := User{}
 	query := tx.Where("username = ?", username)
 	err := query.First(&u)
@@ -112,10 +317,25 @@ func (u Users) String() string {
 	return string(ju)
 }
 
+// usernameChanged reports whether u's username differs from the one stored,
+// or u is not stored yet. Legacy usernames that predate the current rules
+// are only checked against them when they change.
+func (u *User) usernameChanged(tx *pop.Connection) bool {
+	users := Users{}
+	err := tx.Where("id = ?", u.ID).All(&users)
+
+	if err != nil || len(users) == 0 {
+		return true
+	}
+
+	return users[0].Username != u.Username
+}
+
 // Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
 // This method is not required and may be deleted.
 func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
 	var err error
+	changed := u.usernameChanged(tx)
 	return validate.Validate(
 		&validators.FuncValidator{
 			Field:   u.Email,
@@ -123,7 +343,7 @@ func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
 			Message: "a user with email %s already exists",
 			Fn: func() bool {
 				var b bool
-				q := tx.Where("email = ?", u.Email)
+				q := tx.Where("email = ? AND id != ?", u.Email, u.ID)
 				b, err = q.Exists(u)
 				if err != nil {
 					return false
@@ -147,13 +367,38 @@ func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
 				return u.Username != ""
 			},
 		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprintf("%d-%d", MinUsernameLength, MaxUsernameLength),
+			Name:    "Username",
+			Message: "username must be %s characters long",
+			Fn: func() bool {
+				n := utf8.RuneCountInString(u.Username)
+				return !changed || u.Username == "" || (n >= MinUsernameLength && n <= MaxUsernameLength)
+			},
+		},
+		&validators.FuncValidator{
+			Field:   u.Username,
+			Name:    "Username",
+			Message: "username %s may only contain letters, digits, '_', '.' and '-', and must start and end with a letter or digit",
+			Fn: func() bool {
+				return !changed || u.Username == "" || validUsername(u.Username)
+			},
+		},
+		&validators.FuncValidator{
+			Field:   u.Username,
+			Name:    "Username",
+			Message: "username %s is reserved",
+			Fn: func() bool {
+				return !changed || u.Username == "" || !usernameReserved(u.Username)
+			},
+		},
 		&validators.FuncValidator{
 			Field:   u.Username,
 			Name:    "Username",
 			Message: "username %s is already in use",
 			Fn: func() bool {
 				var b bool
-				q := tx.Where("username = ?", u.Username)
+				q := tx.Where("username = ? AND id != ?", u.Username, u.ID)
 				b, err = q.Exists(u)
 				if err != nil {
 					return false
@@ -161,6 +406,122 @@ func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
 				return !b
 			},
 		},
+		&validators.FuncValidator{
+			Field:   u.Username,
+			Name:    "Username",
+			Message: "username %s is too similar to one already in use",
+			Fn: func() bool {
+				if !changed {
+					return true
+				}
+				var b bool
+				q := tx.Where("username_skeleton = ? AND username != ? AND id != ?", usernameSkeleton(u.Username), u.Username, u.ID)
+				b, err = q.Exists(u)
+				if err != nil {
+					return false
+				}
+				return !b
+			},
+		},
+		&validators.FuncValidator{
+			Field:   u.Username,
+			Name:    "Username",
+			Message: "username %s was recently given up and cannot be claimed yet",
+			Fn: func() bool {
+				if !changed {
+					return true
+				}
+				var b bool
+				b, err = usernameHeld(tx, u.Username, u.ID)
+				if err != nil {
+					return false
+				}
+				return !b
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxDisplayNameLength),
+			Name:    "DisplayName",
+			Message: "display name must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(u.DisplayName) <= MaxDisplayNameLength
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxBioLength),
+			Name:    "Bio",
+			Message: "bio must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(u.Bio) <= MaxBioLength
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxLocationLength),
+			Name:    "Location",
+			Message: "location must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(u.Location) <= MaxLocationLength
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxPronounsLength),
+			Name:    "Pronouns",
+			Message: "pronouns must be at most %s characters",
+			Fn: func() bool {
+				return utf8.RuneCountInString(u.Pronouns) <= MaxPronounsLength
+			},
+		},
+		&validators.FuncValidator{
+			Field:   u.Website,
+			Name:    "Website",
+			Message: "website %s is not an http or https address",
+			Fn: func() bool {
+				return u.Website == "" || validWebURL(u.Website)
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxLinks),
+			Name:    "Links",
+			Message: "at most %s links are allowed",
+			Fn: func() bool {
+				return len(u.Links) <= MaxLinks
+			},
+		},
+		&validators.FuncValidator{
+			Field:   strings.Join(u.Links, " "),
+			Name:    "Links",
+			Message: "links %s must all be http or https addresses",
+			Fn: func() bool {
+				for _, l := range u.Links {
+					if !validWebURL(l) || strings.Contains(l, ",") {
+						return false
+					}
+				}
+				return true
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxDisciplines),
+			Name:    "Disciplines",
+			Message: "at most %s disciplines are allowed",
+			Fn: func() bool {
+				return len(u.Disciplines) <= MaxDisciplines
+			},
+		},
+		&validators.FuncValidator{
+			Field:   fmt.Sprint(MaxDisciplineLength),
+			Name:    "Disciplines",
+			Message: "disciplines must be non-empty, at most %s characters, and contain no commas",
+			Fn: func() bool {
+				for _, d := range u.Disciplines {
+					n := utf8.RuneCountInString(d)
+					if n == 0 || n > MaxDisciplineLength || strings.Contains(d, ",") {
+						return false
+					}
+				}
+				return true
+			},
+		},
 	), err
 }
 
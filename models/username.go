@@ -0,0 +1,146 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Limits on the length of a username, in characters.
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 30
+)
+
+// defaultReservedUsernames are names that could be mistaken for the site
+// itself or clash with its routes.
+var defaultReservedUsernames = []string{
+	"about", "account", "admin", "administrator", "age", "api", "apps",
+	"artist", "auth", "avatar", "banner", "content", "email", "export",
+	"exports", "help", "keys", "login", "logout", "me", "media", "mfa",
+	"moderator", "notifications", "null", "oauth", "official", "password",
+	"rmuse", "roles", "root", "settings", "signup", "staff", "static",
+	"support", "system", "undefined", "uploads", "user", "username",
+	"users", "verify", "www",
+}
+
+// confusables maps characters that look like Latin letters to the letters
+// they are mistaken for.
+var confusables = map[rune]rune{
+	// digits
+	'0': 'o', '1': 'l', '5': 's',
+	// Latin
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h',
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c',
+	'ѕ': 's', 'т': 't', 'у': 'y', 'х': 'x', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y',
+}
+
+// CanonicalUsername returns the form a username is stored and looked up in:
+// NFKC normalized and lower case.
+func CanonicalUsername(username string) string {
+	return strings.ToLower(norm.NFKC.String(strings.TrimSpace(username)))
+}
+
+// usernameSkeleton returns what username looks like, with accents dropped
+// and look-alike characters replaced, so that names which can be mistaken
+// for one another share a skeleton.
+func usernameSkeleton(username string) string {
+	skel := []rune{}
+	for _, r := range norm.NFD.String(CanonicalUsername(username)) {
+		if unicode.Is(unicode.Mn, r) || r == '_' || r == '.' || r == '-' {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		skel = append(skel, r)
+	}
+
+	return strings.Replace(string(skel), "rn", "m", -1)
+}
+
+// validUsername reports whether username keeps to the character policy:
+// letters, digits, '_', '.' and '-', starting with a letter or digit, and
+// not ending with punctuation.
+func validUsername(username string) bool {
+	if username == "" {
+		return false
+	}
+
+	for i, r := range username {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		case (r == '_' || r == '.' || r == '-') && i > 0:
+		default:
+			return false
+		}
+	}
+
+	last := username[len(username)-1]
+	return last != '_' && last != '.' && last != '-'
+}
+
+// ReservedUsernames returns the names nobody can register. They are set by
+// RESERVED_USERNAMES as a comma separated list, which replaces the defaults.
+func ReservedUsernames() []string {
+	names := envy.Get("RESERVED_USERNAMES", "")
+	if names == "" {
+		return defaultReservedUsernames
+	}
+
+	reserved := []string{}
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			reserved = append(reserved, n)
+		}
+	}
+
+	return reserved
+}
+
+// usernameReserved reports whether username is, or looks like, a reserved
+// name.
+func usernameReserved(username string) bool {
+	s := usernameSkeleton(username)
+	for _, r := range ReservedUsernames() {
+		if usernameSkeleton(r) == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RefreshUsernameSkeletons recomputes the skeleton of every username, for
+// users created before skeletons were kept or after the confusables change.
+func RefreshUsernameSkeletons(tx *pop.Connection) (int, error) {
+	users := Users{}
+	if err := tx.All(&users); err != nil {
+		return 0, fmt.Errorf("could not find users %v", err)
+	}
+
+	n := 0
+	for _, u := range users {
+		s := usernameSkeleton(u.Username)
+		if s == u.UsernameSkeleton {
+			continue
+		}
+
+		err := tx.RawQuery("UPDATE users SET username_skeleton = ? WHERE id = ?", s, u.ID).Exec()
+		if err != nil {
+			return n, fmt.Errorf("could not update %s %v", u.Username, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
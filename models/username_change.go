@@ -0,0 +1,157 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/pop"
+	"github.com/satori/go.uuid"
+)
+
+// UsernameChange records a username a user gave up, and when. Links to the
+// old name keep leading to the user until someone else claims it.
+type UsernameChange struct {
+	ID        uuid.UUID `json:"id"          db:"id"`
+	CreatedAt time.Time `json:"released_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"  db:"updated_at"`
+	User      uuid.UUID `json:"-"           db:"user_id"`
+	Username  string    `json:"username"    db:"username"`
+}
+
+// UsernameCooldown returns how long a released username is held for its old
+// owner before anyone else can claim it. It is set by USERNAME_COOLDOWN and
+// defaults to 30 days.
+func UsernameCooldown() time.Duration {
+	d, err := time.ParseDuration(envy.Get("USERNAME_COOLDOWN", "720h"))
+	if err != nil {
+		return 720 * time.Hour
+	}
+
+	return d
+}
+
+// UsernameChangeLimit returns how many times a user can change their username
+// within UsernameChangeWindow. It is set by USERNAME_CHANGE_LIMIT and
+// defaults to 3.
+func UsernameChangeLimit() int {
+	n, err := strconv.Atoi(envy.Get("USERNAME_CHANGE_LIMIT", "3"))
+	if err != nil {
+		return 3
+	}
+
+	return n
+}
+
+// UsernameChangeWindow returns the period over which username changes are
+// counted. It is set by USERNAME_CHANGE_WINDOW and defaults to 30 days.
+func UsernameChangeWindow() time.Duration {
+	d, err := time.ParseDuration(envy.Get("USERNAME_CHANGE_WINDOW", "720h"))
+	if err != nil {
+		return 720 * time.Hour
+	}
+
+	return d
+}
+
+// RecordUsernameChange notes that u has given up the username old.
+func RecordUsernameChange(tx *pop.Connection, u *User, old string) error {
+	c := &UsernameChange{
+		User:     u.ID,
+		Username: old,
+	}
+
+	if err := tx.Create(c); err != nil {
+		return fmt.Errorf("could not record username change %v", err)
+	}
+
+	return nil
+}
+
+// CanChangeUsername reports whether u has changes to their username left in
+// the current window, and if not, when they can next change it.
+func CanChangeUsername(tx *pop.Connection, u *User) (bool, time.Time, error) {
+	since := time.Now().Add(-UsernameChangeWindow())
+
+	changes := UsernameChanges{}
+	err := tx.Where("user_id = ? AND created_at > ?", u.ID, since).Order("created_at").All(&changes)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("could not count username changes %v", err)
+	}
+
+	limit := UsernameChangeLimit()
+	if limit < 1 {
+		return false, time.Time{}, nil
+	}
+
+	if len(changes) < limit {
+		return true, time.Time{}, nil
+	}
+
+	return false, changes[len(changes)-limit].CreatedAt.Add(UsernameChangeWindow()), nil
+}
+
+// GetUsernameHistory returns the usernames u has given up, newest first.
+func GetUsernameHistory(tx *pop.Connection, u *User) (UsernameChanges, error) {
+	changes := UsernameChanges{}
+	err := tx.Where("user_id = ?", u.ID).Order("created_at desc").All(&changes)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find username history %v", err)
+	}
+
+	return changes, nil
+}
+
+// usernameHeld reports whether username was released by someone other than
+// the user with the given id recently enough that it is still held for them.
+func usernameHeld(tx *pop.Connection, username string, id uuid.UUID) (bool, error) {
+	since := time.Now().Add(-UsernameCooldown())
+	q := tx.Where("username = ? AND user_id != ? AND created_at > ?", username, id, since)
+
+	return q.Exists(&UsernameChange{})
+}
+
+// ResolveUsername returns the user with the given username. A name nobody
+// holds now resolves to the user who last gave it up, so that old links keep
+// working; compare the result's Username to tell the two apart. Only pages
+// that redirect to the current name should use it.
+func ResolveUsername(tx *pop.Connection, username string) (*User, error) {
+	u, err := GetUserByUsername(tx, username)
+
+	if err != nil {
+		return getUserByFormerUsername(tx, CanonicalUsername(username))
+	}
+
+	return u, nil
+}
+
+// getUserByFormerUsername returns the user who most recently gave up
+// username.
+func getUserByFormerUsername(tx *pop.Connection, username string) (*User, error) {
+	c := UsernameChange{}
+	err := tx.Where("username = ?", username).Order("created_at desc").First(&c)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not find user %v", err)
+	}
+
+	return GetUserByID(tx, c.User)
+}
+
+// String is not required by pop and may be deleted
+func (c UsernameChange) String() string {
+	jc, _ := json.Marshal(c)
+	return string(jc)
+}
+
+// UsernameChanges is not required by pop and may be deleted
+type UsernameChanges []UsernameChange
+
+// String is not required by pop and may be deleted
+func (c UsernameChanges) String() string {
+	jc, _ := json.Marshal(c)
+	return string(jc)
+}
@@ -0,0 +1,153 @@
+// Package storage keeps the files users upload to rmuse on the local
+// filesystem and works out the addresses they are served from.
+//
+// Files are named by keys such as "avatars/<id>.jpg". STORAGE_DIR sets the
+// directory they are kept in and STORAGE_URL the address it is served at.
+// Keys starting with "private/" are kept apart in PRIVATE_STORAGE_DIR, which
+// is never served.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobuffalo/envy"
+)
+
+// PrivatePrefix starts the keys of files that are never served.
+const PrivatePrefix = "private/"
+
+// Dir returns the directory files are stored in.
+func Dir() string {
+	return envy.Get("STORAGE_DIR", "uploads")
+}
+
+// PrivateDir returns the directory private files are stored in. It must not
+// be inside Dir.
+func PrivateDir() string {
+	return envy.Get("PRIVATE_STORAGE_DIR", "private")
+}
+
+// BaseURL returns the address the storage directory is served at.
+func BaseURL() string {
+	return strings.TrimSuffix(envy.Get("STORAGE_URL", envy.Get("APP_URL", "http://127.0.0.1:3000")+"/uploads"), "/")
+}
+
+// URL returns the address the file stored under key is served at.
+func URL(key string) string {
+	return BaseURL() + "/" + key
+}
+
+// KeyFromURL returns the key of the stored file served at uri. It returns
+// false if uri does not point into storage.
+func KeyFromURL(uri string) (string, bool) {
+	prefix := BaseURL() + "/"
+	if !strings.HasPrefix(uri, prefix) {
+		return "", false
+	}
+
+	key := strings.TrimPrefix(uri, prefix)
+	if _, err := filename(key); err != nil || strings.HasPrefix(key, PrivatePrefix) {
+		return "", false
+	}
+
+	return key, true
+}
+
+// filename returns the path of the file stored under key, refusing keys that
+// would escape the storage directory.
+func filename(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean != "/"+key {
+		return "", fmt.Errorf("invalid storage key %s", key)
+	}
+
+	if strings.HasPrefix(key, PrivatePrefix) {
+		return filepath.Join(PrivateDir(), filepath.FromSlash(strings.TrimPrefix(key, PrivatePrefix))), nil
+	}
+
+	return filepath.Join(Dir(), filepath.FromSlash(key)), nil
+}
+
+// Put stores the contents of r under key, replacing any file already there.
+func Put(key string, r io.Reader) error {
+	name, err := filename(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("could not create storage directory %v", err)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("could not store %s %v", key, err)
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(name)
+		return fmt.Errorf("could not store %s %v", key, err)
+	}
+
+	return f.Close()
+}
+
+// Open returns the file stored under key. The caller must close it.
+func Open(key string) (io.ReadCloser, error) {
+	name, err := filename(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s %v", key, err)
+	}
+
+	return f, nil
+}
+
+// Delete removes the file stored under key. Deleting a file that does not
+// exist is not an error.
+func Delete(key string) error {
+	name, err := filename(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete %s %v", key, err)
+	}
+
+	return nil
+}
+
+// Serve writes the file stored under key to w, answering range and
+// conditional requests.
+func Serve(w http.ResponseWriter, r *http.Request, key string) error {
+	name, err := filename(key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("could not open %s %v", key, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return fmt.Errorf("could not open %s", key)
+	}
+
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), f)
+	return nil
+}